@@ -0,0 +1,137 @@
+// Package audit provides AuditSinks other than the storer's
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"py-server/server"
+	"sort"
+	"sync"
+)
+
+// FileSink is an AuditSink appending entries to a file as JSON lines.
+// Queries scan the whole file, so it suits small or single instance
+// deployments.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Record appends the entry as a line of JSON
+func (s *FileSink) Record(ctx context.Context, entry server.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Query scans the file for the user's entries
+func (s *FileSink) Query(ctx context.Context, query server.AuditQuery) ([]server.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []server.AuditEntry{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []server.AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := server.AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry: %w", err)
+		}
+		if entry.UserID != query.UserID {
+			continue
+		}
+		if !query.Includes(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return server.AuditListedBefore(entries[i], entries[j])
+	})
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+	return entries, nil
+}
+
+// EraseAudit rewrites the file without the user's entries, replacing it only
+// once the rewrite is complete
+func (s *FileSink) EraseAudit(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	temp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := server.AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			temp.Close()
+			return fmt.Errorf("failed to decode audit entry: %w", err)
+		}
+		if entry.UserID == userID {
+			continue
+		}
+		if _, err := temp.Write(append(scanner.Bytes(), '\n')); err != nil {
+			temp.Close()
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
+
+// MakeFileSink returns a FileSink appending to the file at path
+func MakeFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
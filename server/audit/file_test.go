@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"py-server/server"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	sink := MakeFileSink(filepath.Join(t.TempDir(), "audit.log"))
+
+	entries, err := sink.Query(ctx, server.AuditQuery{UserID: "a"})
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected no entries before any are recorded, got %v %v", entries, err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		userID := "a"
+		if i%2 == 1 {
+			userID = "b"
+		}
+		err := sink.Record(ctx, server.AuditEntry{
+			ID:     string(rune('0' + i)),
+			Time:   start.Add(time.Duration(i) * time.Second),
+			UserID: userID,
+			Action: server.ActionSaveUserSave,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err = sink.Query(ctx, server.AuditQuery{UserID: "a", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != "4" || entries[1].ID != "2" {
+		t.Errorf("expected newest 2 of a's entries, got %+v", entries)
+	}
+
+	entries, err = sink.Query(ctx, server.AuditQuery{UserID: "a", Before: start.Add(2 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "0" {
+		t.Errorf("expected a's entries before the third, got %+v", entries)
+	}
+
+	// entries at the same time page on from the last one listed
+	for _, id := range []string{"z", "x", "y"} {
+		err := sink.Record(ctx, server.AuditEntry{ID: id, Time: start.Add(10 * time.Second), UserID: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err = sink.Query(ctx, server.AuditQuery{UserID: "a", Before: start.Add(10 * time.Second), BeforeID: "x", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != "y" || entries[1].ID != "z" {
+		t.Errorf("expected the entries listed after x, got %+v", entries)
+	}
+
+	if err := sink.EraseAudit(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = sink.Query(ctx, server.AuditQuery{UserID: "a"}); err != nil || len(entries) != 0 {
+		t.Errorf("expected a's entries to be erased, got %+v %v", entries, err)
+	}
+	if entries, err = sink.Query(ctx, server.AuditQuery{UserID: "b"}); err != nil || len(entries) != 2 {
+		t.Errorf("expected b's entries to survive, got %+v %v", entries, err)
+	}
+}
@@ -0,0 +1,51 @@
+// devtoken mints tokens accepted by py-server when run with -development
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"py-server/token"
+	"strings"
+	"time"
+)
+
+func main() {
+	userID := flag.String("user", "", "User ID the token is issued to")
+	email := flag.String("email", "", "Verified email address claimed by the token")
+	hostedDomain := flag.String("hd", "", "Google hosted domain claimed by the token")
+	roles := flag.String("roles", "", "Comma separated roles claimed by the token")
+	ttl := flag.Duration("ttl", time.Hour, "How long the token is valid for, 0 for no expiry")
+	flag.Parse()
+
+	if len(*userID) < 1 {
+		log.Fatal("a user ID must be given with -user")
+	}
+
+	secret, found := os.LookupEnv("PYSERVER_DEV_SECRET")
+	if !found {
+		secret = token.DefaultDevSecret
+	}
+
+	now := time.Now()
+	claims := token.DevClaims{
+		Subject:       *userID,
+		Email:         *email,
+		EmailVerified: len(*email) > 0,
+		HostedDomain:  *hostedDomain,
+		IssuedAt:      now.Unix(),
+	}
+	if len(*roles) > 0 {
+		claims.Roles = strings.Split(*roles, ",")
+	}
+	if *ttl > 0 {
+		claims.ExpiresAt = now.Add(*ttl).Unix()
+	}
+
+	minted, err := token.MakeDevTokenChecker(secret).Mint(claims)
+	if err != nil {
+		log.Fatalf("failed to mint token: %s", err)
+	}
+	fmt.Println(minted)
+}
@@ -3,7 +3,7 @@ module py-server
 go 1.16
 
 require (
-	cloud.google.com/go/storage v1.15.0 // indirect
-	github.com/rs/xid v1.3.0 // indirect
-	google.golang.org/api v0.49.0 // indirect
+	cloud.google.com/go/storage v1.15.0
+	github.com/rs/xid v1.3.0
+	google.golang.org/api v0.49.0
 )
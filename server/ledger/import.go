@@ -0,0 +1,64 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Fingerprint identifies a transaction by its date, amount and description,
+// ignoring case and spacing, to find the same transaction imported twice
+func (t Transaction) Fingerprint() string {
+	description := strings.ToLower(strings.Join(strings.Fields(t.Description), " "))
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s",
+		t.Date, t.Amount.Minor, t.Amount.Currency, description)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ImportedTransaction is a transaction read from a statement
+type ImportedTransaction struct {
+	Transaction
+	Fingerprint string `json:"fingerprint"`
+	// Duplicate is set if the ledger already has the transaction
+	Duplicate bool `json:"duplicate"`
+	// Rule is the ID of the rule the transaction matched, if any
+	Rule string `json:"rule,omitempty"`
+}
+
+// MarkDuplicates returns the transactions, marking those the ledger already
+// has by fingerprint. A statement may list identical transactions, so as many
+// are marked as the ledger has and the rest are new.
+func (l *Ledger) MarkDuplicates(transactions []Transaction) []ImportedTransaction {
+	recorded := map[string]int{}
+	for _, transaction := range l.Transactions {
+		recorded[transaction.Fingerprint()]++
+	}
+
+	imported := make([]ImportedTransaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		fingerprint := transaction.Fingerprint()
+		duplicate := recorded[fingerprint] > 0
+		if duplicate {
+			recorded[fingerprint]--
+		}
+		imported = append(imported, ImportedTransaction{Transaction: transaction, Fingerprint: fingerprint, Duplicate: duplicate})
+	}
+	return imported
+}
+
+// PrepareImport returns the transactions marked as MarkDuplicates does, with
+// the ledger's rules setting any missing tag or expense of those that are new
+func (l *Ledger) PrepareImport(transactions []Transaction) ([]ImportedTransaction, error) {
+	categoriser, err := l.Categoriser()
+	if err != nil {
+		return nil, err
+	}
+	imported := l.MarkDuplicates(transactions)
+	for i := range imported {
+		if !imported[i].Duplicate {
+			imported[i].Rule, _ = categoriser.Categorise(&imported[i].Transaction, false)
+		}
+	}
+	return imported, nil
+}
@@ -0,0 +1,50 @@
+package ledger
+
+import (
+	"py-server/usersave"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	transaction := Transaction{
+		Date:        usersave.Date{Year: 2021, Month: time.June, Day: 1},
+		Amount:      usersave.Money{Minor: 450, Currency: "NZD"},
+		Description: "Coffee  Co",
+	}
+	same := transaction
+	same.ID, same.Note, same.Description = "other", "a note", " coffee co"
+	if transaction.Fingerprint() != same.Fingerprint() {
+		t.Error("expected fingerprints to ignore ID, note, case and spacing")
+	}
+
+	different := transaction
+	different.Amount.Minor = 451
+	if transaction.Fingerprint() == different.Fingerprint() {
+		t.Error("expected fingerprints of different amounts to differ")
+	}
+}
+
+func TestMarkDuplicates(t *testing.T) {
+	coffee := Transaction{
+		Date:        usersave.Date{Year: 2021, Month: time.June, Day: 1},
+		Amount:      usersave.Money{Minor: 450, Currency: "NZD"},
+		Description: "Coffee",
+	}
+	tea := coffee
+	tea.Description = "Tea"
+	recorded := coffee
+	recorded.ID = "a"
+	ledger := &Ledger{Transactions: []Transaction{recorded}}
+
+	imported := ledger.MarkDuplicates([]Transaction{coffee, tea, coffee})
+	duplicates := []bool{true, false, false}
+	for i, transaction := range imported {
+		if transaction.Duplicate != duplicates[i] {
+			t.Errorf("expected transaction %d duplicate to be %t", i+1, duplicates[i])
+		}
+		if transaction.Fingerprint != transaction.Transaction.Fingerprint() {
+			t.Errorf("expected transaction %d to have its fingerprint", i+1)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"py-server/usersave"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoTransaction    = errors.New("no such transaction")
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+const (
+	MaxNoteLength        = 500
+	MaxTagLength         = 64
+	MaxDescriptionLength = 200
+	MaxAccountLength     = 64
+)
+
+// Transaction is money actually spent, or received back if negative
+type Transaction struct {
+	ID     string         `json:"id"`
+	Date   usersave.Date  `json:"date"`
+	Amount usersave.Money `json:"amount"`
+	Tag    usersave.Tag   `json:"tag,omitempty"`
+	Note   string         `json:"note,omitempty"`
+	// Description is how the bank describes the transaction, such as the payee
+	Description string `json:"description,omitempty"`
+	// Account names the account the transaction was made from, if known
+	Account string `json:"account,omitempty"`
+	// Expense names the planned expense the transaction pays, if any
+	Expense   string    `json:"expense,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Validate checks the transaction has a date and an amount in a known
+// currency, and that its text isn't too long
+func (t Transaction) Validate() error {
+	if t.Date == (usersave.Date{}) {
+		return errors.New("transaction has no date")
+	}
+	if t.Amount.IsZero() {
+		return errors.New("transaction has no amount")
+	}
+	if !usersave.ValidCurrency(t.Amount.Currency) {
+		return fmt.Errorf("unknown currency %q", t.Amount.Currency)
+	}
+	if len(t.Tag) > MaxTagLength {
+		return fmt.Errorf("tag is longer than %d characters", MaxTagLength)
+	}
+	if len(t.Note) > MaxNoteLength {
+		return fmt.Errorf("note is longer than %d characters", MaxNoteLength)
+	}
+	if len(t.Description) > MaxDescriptionLength {
+		return fmt.Errorf("description is longer than %d characters", MaxDescriptionLength)
+	}
+	if len(t.Account) > MaxAccountLength {
+		return fmt.Errorf("account is longer than %d characters", MaxAccountLength)
+	}
+	return nil
+}
+
+// Ledger is every transaction a user has recorded, and their rules for
+// categorising transactions in priority order
+type Ledger struct {
+	Transactions []Transaction `json:"transactions"`
+	Rules        []Rule        `json:"rules,omitempty"`
+}
+
+// Find returns the index of the transaction with the ID
+func (l *Ledger) Find(id string) (int, error) {
+	for i, transaction := range l.Transactions {
+		if transaction.ID == id {
+			return i, nil
+		}
+	}
+	return 0, ErrNoTransaction
+}
+
+// Remove removes the transaction with the ID
+func (l *Ledger) Remove(id string) error {
+	i, err := l.Find(id)
+	if err != nil {
+		return err
+	}
+	l.Transactions = append(l.Transactions[:i], l.Transactions[i+1:]...)
+	return nil
+}
+
+// RenameTag renames the tag of the transactions and rules with it, returning
+// how many transactions were renamed
+func (l *Ledger) RenameTag(previous usersave.Tag, name usersave.Tag, now time.Time) int {
+	renamed := 0
+	for i := range l.Transactions {
+		if l.Transactions[i].Tag == previous {
+			l.Transactions[i].Tag = name
+			l.Transactions[i].UpdatedAt = now
+			renamed++
+		}
+	}
+	for i := range l.Rules {
+		if l.Rules[i].Tag == previous {
+			l.Rules[i].Tag = name
+		}
+	}
+	return renamed
+}
+
+// Query selects a page of transactions, newest first
+type Query struct {
+	// From and To include transactions on or after and on or before the
+	// dates, if set
+	From *usersave.Date
+	To   *usersave.Date
+	// Tag includes only transactions with the tag, if set
+	Tag       usersave.Tag
+	PageToken string
+	PageSize  int
+}
+
+// newer returns true if a is listed before b, by date then ID
+func newer(a Transaction, b Transaction) bool {
+	if a.Date != b.Date {
+		return a.Date.After(b.Date)
+	}
+	return a.ID > b.ID
+}
+
+// pageToken marks where the page after the transaction starts
+func pageToken(transaction Transaction) string {
+	return transaction.Date.String() + "/" + transaction.ID
+}
+
+// parsePageToken returns a transaction positioned where the page token marks
+func parsePageToken(token string) (Transaction, error) {
+	separator := strings.Index(token, "/")
+	if separator < 0 {
+		return Transaction{}, ErrInvalidPageToken
+	}
+	date, err := usersave.ParseDate(token[:separator])
+	if err != nil {
+		return Transaction{}, ErrInvalidPageToken
+	}
+	return Transaction{Date: date, ID: token[separator+1:]}, nil
+}
+
+// Page returns up to the query's page size of matching transactions, newest
+// first, and the token of the next page if there are more
+func (l *Ledger) Page(query Query) ([]Transaction, string, error) {
+	var after *Transaction
+	if len(query.PageToken) > 0 {
+		parsed, err := parsePageToken(query.PageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &parsed
+	}
+
+	matches := []Transaction{}
+	for _, transaction := range l.Transactions {
+		switch {
+		case query.From != nil && transaction.Date.Before(*query.From):
+		case query.To != nil && transaction.Date.After(*query.To):
+		case len(query.Tag) > 0 && transaction.Tag != query.Tag:
+		case after != nil && !newer(*after, transaction):
+		default:
+			matches = append(matches, transaction)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return newer(matches[i], matches[j])
+	})
+
+	if query.PageSize < 1 || len(matches) <= query.PageSize {
+		return matches, "", nil
+	}
+	page := matches[:query.PageSize]
+	return page, pageToken(page[len(page)-1]), nil
+}
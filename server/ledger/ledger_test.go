@@ -0,0 +1,108 @@
+package ledger
+
+import (
+	"py-server/usersave"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Transaction{Date: usersave.Date{Year: 2021, Month: time.June, Day: 1}, Amount: usersave.Money{Minor: 450, Currency: "NZD"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected transaction to be valid, got %s", err)
+	}
+
+	invalid := []Transaction{
+		{Amount: valid.Amount},
+		{Date: valid.Date},
+		{Date: valid.Date, Amount: usersave.Money{Minor: 450, Currency: "nzd"}},
+		{Date: valid.Date, Amount: valid.Amount, Tag: strings.Repeat("a", MaxTagLength+1)},
+		{Date: valid.Date, Amount: valid.Amount, Note: strings.Repeat("a", MaxNoteLength+1)},
+		{Date: valid.Date, Amount: valid.Amount, Description: strings.Repeat("a", MaxDescriptionLength+1)},
+		{Date: valid.Date, Amount: valid.Amount, Account: strings.Repeat("a", MaxAccountLength+1)},
+	}
+	for _, transaction := range invalid {
+		if err := transaction.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", transaction)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	june := func(day int) usersave.Date { return usersave.Date{Year: 2021, Month: time.June, Day: day} }
+	ledger := &Ledger{Transactions: []Transaction{
+		{ID: "a", Date: june(1), Tag: "Food"},
+		{ID: "b", Date: june(3)},
+		{ID: "c", Date: june(2), Tag: "Food"},
+		{ID: "d", Date: june(2), Tag: "Food"},
+		{ID: "e", Date: june(5)},
+	}}
+	ids := func(transactions []Transaction) string {
+		ids := ""
+		for _, transaction := range transactions {
+			ids += transaction.ID
+		}
+		return ids
+	}
+
+	page, next, err := ledger.Page(Query{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(page) != "eb" || next != "2021-06-03/b" {
+		t.Errorf("expected first page eb, got %s with token %q", ids(page), next)
+	}
+	if page, next, err = ledger.Page(Query{PageSize: 2, PageToken: next}); err != nil {
+		t.Fatal(err)
+	}
+	if ids(page) != "dc" {
+		t.Errorf("expected second page dc, got %s", ids(page))
+	}
+	if page, next, err = ledger.Page(Query{PageSize: 2, PageToken: next}); err != nil {
+		t.Fatal(err)
+	}
+	if ids(page) != "a" || len(next) > 0 {
+		t.Errorf("expected last page a, got %s with token %q", ids(page), next)
+	}
+
+	from, to := june(2), june(3)
+	if page, _, _ = ledger.Page(Query{From: &from, To: &to}); ids(page) != "bdc" {
+		t.Errorf("expected transactions between dates, got %s", ids(page))
+	}
+	if page, _, _ = ledger.Page(Query{Tag: "Food"}); ids(page) != "dca" {
+		t.Errorf("expected tagged transactions, got %s", ids(page))
+	}
+	for _, token := range []string{"nonsense", "June/a"} {
+		if _, _, err := ledger.Page(Query{PageToken: token}); err != ErrInvalidPageToken {
+			t.Errorf("expected page token %q to be invalid, got %v", token, err)
+		}
+	}
+
+	if err := ledger.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ledger.Find("c"); err != ErrNoTransaction {
+		t.Errorf("expected removed transaction to be gone, got %v", err)
+	}
+	if err := ledger.Remove("c"); err != ErrNoTransaction {
+		t.Errorf("expected removing a missing transaction to fail, got %v", err)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	ledger := &Ledger{
+		Transactions: []Transaction{{ID: "a", Tag: "Fod"}, {ID: "b", Tag: "Fun"}, {ID: "c", Tag: "Fod"}},
+		Rules:        []Rule{{ID: "r", Tag: "Fod"}},
+	}
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	if renamed := ledger.RenameTag("Fod", "Food", now); renamed != 2 {
+		t.Errorf("expected 2 transactions renamed, got %d", renamed)
+	}
+	if ledger.Transactions[2].Tag != "Food" || !ledger.Transactions[2].UpdatedAt.Equal(now) || ledger.Transactions[1].Tag != "Fun" {
+		t.Errorf("expected only Fod transactions renamed, got %+v", ledger.Transactions)
+	}
+	if ledger.Rules[0].Tag != "Food" {
+		t.Errorf("expected rule renamed, got %+v", ledger.Rules[0])
+	}
+}
@@ -0,0 +1,308 @@
+package ledger
+
+import (
+	"fmt"
+	"py-server/usersave"
+	"sort"
+	"time"
+)
+
+// Comparison is what was planned against what was actually spent
+type Comparison struct {
+	Planned usersave.Money `json:"planned"`
+	Actual  usersave.Money `json:"actual"`
+	// Difference is the actual less the planned, positive when overspent
+	Difference usersave.Money `json:"difference"`
+}
+
+// TagReport compares the planned expenses with a tag to the transactions with
+// it. Transactions without a tag take the tag of their planned expense.
+type TagReport struct {
+	Tag usersave.Tag `json:"tag"`
+	Comparison
+}
+
+// ExpenseReport compares a planned expense to the transactions paying it
+type ExpenseReport struct {
+	Name string `json:"name"`
+	Comparison
+}
+
+// TagRollupReport compares the planned expenses with a catalogued tag or any
+// tag beneath it to the transactions with them
+type TagRollupReport struct {
+	ID     string       `json:"id"`
+	Tag    usersave.Tag `json:"tag"`
+	Parent string       `json:"parent,omitempty"`
+	Comparison
+	// Limit is the tag's limit over the cycles compared, if it has one, and
+	// OverLimit is set when more was actually spent
+	Limit     *usersave.Money `json:"limit,omitempty"`
+	OverLimit bool            `json:"overLimit"`
+}
+
+// CycleReport compares the plan to the transactions dated in a pay cycle
+type CycleReport struct {
+	usersave.Period
+	Comparison
+	// Tags are sorted by tag, untagged first
+	Tags []TagReport `json:"tags"`
+	// Expenses are in the same order as the save's expenses
+	Expenses []ExpenseReport `json:"expenses"`
+	// Unplanned is the total of transactions paying no planned expense
+	Unplanned usersave.Money `json:"unplanned"`
+	// Rollups are in catalogue order
+	Rollups []TagRollupReport `json:"rollups"`
+	// Foreign totals transactions not in the report's currency, which aren't
+	// compared to the plan, by currency
+	Foreign []usersave.Money `json:"foreign"`
+}
+
+// Report compares the plan to what was actually spent over recent pay cycles
+type Report struct {
+	Cycle    usersave.Cycle `json:"cycle"`
+	Currency string         `json:"currency"`
+	// Cycles are oldest first, ending with the current cycle
+	Cycles []CycleReport `json:"cycles"`
+	// Tags totals every cycle by tag
+	Tags []TagReport `json:"tags"`
+	// Rollups totals every cycle by catalogued tag
+	Rollups []TagRollupReport `json:"rollups"`
+	// Foreign totals every cycle's transactions not in the report's currency
+	Foreign []usersave.Money `json:"foreign"`
+}
+
+// tally totals planned and actual amounts by name, remembering the order
+// names were first seen in
+type tally struct {
+	names   []string
+	planned map[string]usersave.Money
+	actual  map[string]usersave.Money
+}
+
+func newTally() *tally {
+	return &tally{planned: map[string]usersave.Money{}, actual: map[string]usersave.Money{}}
+}
+
+func (t *tally) add(totals map[string]usersave.Money, name string, amount usersave.Money) error {
+	if _, planned := t.planned[name]; !planned {
+		if _, actual := t.actual[name]; !actual {
+			t.names = append(t.names, name)
+		}
+	}
+	total, err := totals[name].Add(amount)
+	if err != nil {
+		return fmt.Errorf("failed to total %q: %w", name, err)
+	}
+	totals[name] = total
+	return nil
+}
+
+func (t *tally) plan(name string, amount usersave.Money) error {
+	return t.add(t.planned, name, amount)
+}
+
+func (t *tally) spend(name string, amount usersave.Money) error {
+	return t.add(t.actual, name, amount)
+}
+
+func (t *tally) merge(other *tally) error {
+	for _, name := range other.names {
+		if err := t.plan(name, other.planned[name]); err != nil {
+			return err
+		}
+		if err := t.spend(name, other.actual[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tally) compare(name string) (Comparison, error) {
+	difference, err := t.actual[name].Sub(t.planned[name])
+	if err != nil {
+		return Comparison{}, fmt.Errorf("failed to compare %q: %w", name, err)
+	}
+	return Comparison{t.planned[name], t.actual[name], difference}, nil
+}
+
+// tags returns a TagReport for every name, sorted
+func (t *tally) tags() ([]TagReport, error) {
+	names := append([]string(nil), t.names...)
+	sort.Strings(names)
+	reports := make([]TagReport, 0, len(names))
+	for _, name := range names {
+		comparison, err := t.compare(name)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, TagReport{name, comparison})
+	}
+	return reports, nil
+}
+
+// rollups returns a TagRollupReport for every tag in the save's catalogue,
+// limited to the tag's limit for each of the cycles
+func (t *tally) rollups(userSave *usersave.JSONUserSave, cycles int) ([]TagRollupReport, error) {
+	planned, err := userSave.RollUp(t.planned)
+	if err != nil {
+		return nil, err
+	}
+	actual, err := userSave.RollUp(t.actual)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]TagRollupReport, 0, len(userSave.Tags))
+	for _, tag := range userSave.Tags {
+		difference, err := actual[tag.ID].Sub(planned[tag.ID])
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %q: %w", tag.Name, err)
+		}
+		report := TagRollupReport{
+			ID:         tag.ID,
+			Tag:        tag.Name,
+			Parent:     tag.Parent,
+			Comparison: Comparison{planned[tag.ID], actual[tag.ID], difference},
+		}
+		if tag.Limit != nil {
+			limit, err := tag.Limit.Mul(int64(cycles))
+			if err != nil {
+				return nil, fmt.Errorf("failed to limit %q: %w", tag.Name, err)
+			}
+			report.Limit, report.OverLimit = &limit, report.Actual.Minor > limit.Minor
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// addForeign adds the amount to the total in its currency, keeping the totals
+// sorted by currency
+func addForeign(totals []usersave.Money, amount usersave.Money) ([]usersave.Money, error) {
+	for i, total := range totals {
+		if total.Currency == amount.Currency {
+			sum, err := total.Add(amount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to total %s transactions: %w", amount.Currency, err)
+			}
+			totals[i] = sum
+			return totals, nil
+		}
+	}
+	totals = append(totals, amount)
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Currency < totals[j].Currency
+	})
+	return totals, nil
+}
+
+// BuildReport compares the save's planned expenses to the transactions over
+// the count pay cycles up to and including the current one, in the user's
+// timezone. Transactions in currencies other than the save's, or the first
+// transaction's if the save has none, are totalled separately.
+func BuildReport(userSave *usersave.JSONUserSave, transactions []Transaction, now time.Time, count int) (Report, error) {
+	periods, err := usersave.Periods(userSave, now, count)
+	if err != nil {
+		return Report{}, err
+	}
+	expenseTags := map[string]usersave.Tag{}
+	for _, expense := range userSave.Expenses {
+		expenseTags[expense.Name] = expense.Tag
+	}
+
+	report := Report{
+		Cycle:    userSave.Cycle,
+		Currency: userSave.Currency(),
+		Cycles:   make([]CycleReport, 0, len(periods)),
+		Foreign:  []usersave.Money{},
+	}
+	allTags := newTally()
+	for _, period := range periods {
+		tags, expenses := newTally(), newTally()
+		total := newTally()
+		unplanned := usersave.Money{}
+		foreign := []usersave.Money{}
+		for _, expense := range userSave.Expenses {
+			if err := tags.plan(expense.Tag, expense.Amount); err != nil {
+				return Report{}, err
+			}
+			if err := expenses.plan(expense.Name, expense.Amount); err != nil {
+				return Report{}, err
+			}
+			if err := total.plan("", expense.Amount); err != nil {
+				return Report{}, err
+			}
+		}
+		for _, transaction := range transactions {
+			if transaction.Date.Before(period.Start) || transaction.Date.After(period.End) {
+				continue
+			}
+			if len(report.Currency) < 1 {
+				report.Currency = transaction.Amount.Currency
+			}
+			if transaction.Amount.Currency != report.Currency {
+				if foreign, err = addForeign(foreign, transaction.Amount); err != nil {
+					return Report{}, err
+				}
+				if report.Foreign, err = addForeign(report.Foreign, transaction.Amount); err != nil {
+					return Report{}, err
+				}
+				continue
+			}
+			if err := total.spend("", transaction.Amount); err != nil {
+				return Report{}, err
+			}
+			tag, planned := expenseTags[transaction.Expense]
+			if len(transaction.Tag) > 0 {
+				tag = transaction.Tag
+			}
+			if err := tags.spend(tag, transaction.Amount); err != nil {
+				return Report{}, err
+			}
+			if !planned {
+				if unplanned, err = unplanned.Add(transaction.Amount); err != nil {
+					return Report{}, fmt.Errorf("failed to total unplanned transactions: %w", err)
+				}
+				continue
+			}
+			if err := expenses.spend(transaction.Expense, transaction.Amount); err != nil {
+				return Report{}, err
+			}
+		}
+
+		cycle := CycleReport{
+			Period:    period,
+			Expenses:  make([]ExpenseReport, 0, len(userSave.Expenses)),
+			Unplanned: unplanned,
+			Foreign:   foreign,
+		}
+		if cycle.Comparison, err = total.compare(""); err != nil {
+			return Report{}, err
+		}
+		if cycle.Tags, err = tags.tags(); err != nil {
+			return Report{}, err
+		}
+		if cycle.Rollups, err = tags.rollups(userSave, 1); err != nil {
+			return Report{}, err
+		}
+		for _, expense := range userSave.Expenses {
+			comparison, err := expenses.compare(expense.Name)
+			if err != nil {
+				return Report{}, err
+			}
+			cycle.Expenses = append(cycle.Expenses, ExpenseReport{expense.Name, comparison})
+		}
+		if err := allTags.merge(tags); err != nil {
+			return Report{}, err
+		}
+		report.Cycles = append(report.Cycles, cycle)
+	}
+	if report.Tags, err = allTags.tags(); err != nil {
+		return Report{}, err
+	}
+	if report.Rollups, err = allTags.rollups(userSave, len(periods)); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
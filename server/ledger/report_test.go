@@ -0,0 +1,126 @@
+package ledger
+
+import (
+	"py-server/usersave"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	nzd := func(minor int64) usersave.Money { return usersave.Money{Minor: minor, Currency: "NZD"} }
+	date := func(month time.Month, day int) usersave.Date {
+		return usersave.Date{Year: 2021, Month: month, Day: day}
+	}
+	userSave := &usersave.JSONUserSave{
+		Cycle: usersave.CycleWeekly,
+		Expenses: []usersave.JSONExpense{
+			{Name: "Rent", Amount: nzd(500), Tag: "Home"},
+			{Name: "Groceries", Amount: nzd(100), Tag: "Food"},
+		},
+	}
+	transactions := []Transaction{
+		{Date: date(time.April, 1), Amount: nzd(1000), Expense: "Rent"},
+		{Date: date(time.May, 25), Amount: nzd(500), Expense: "Rent"},
+		{Date: date(time.May, 31), Amount: nzd(20), Tag: "Food"},
+		{Date: date(time.June, 1), Amount: nzd(120), Expense: "Groceries"},
+		{Date: date(time.June, 2), Amount: nzd(30), Tag: "Fun"},
+	}
+
+	report, err := BuildReport(userSave, transactions, time.Date(2021, time.June, 2, 12, 0, 0, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Cycles) != 2 || report.Currency != "NZD" {
+		t.Fatalf("expected 2 NZD cycles, got %+v", report)
+	}
+	last := report.Cycles[1]
+	if last.Start != date(time.May, 31) || last.End != date(time.June, 6) {
+		t.Errorf("expected cycle from Monday to Sunday, got %s to %s", last.Start, last.End)
+	}
+	if last.Planned != nzd(600) || last.Actual != nzd(170) || last.Difference != nzd(-430) || last.Unplanned != nzd(50) {
+		t.Errorf("unexpected totals %+v", last)
+	}
+
+	expect := []TagReport{
+		{"Food", Comparison{nzd(100), nzd(140), nzd(40)}},
+		{"Fun", Comparison{usersave.Money{}, nzd(30), nzd(30)}},
+		{"Home", Comparison{nzd(500), usersave.Money{}, nzd(-500)}},
+	}
+	if len(last.Tags) != len(expect) {
+		t.Fatalf("expected %d tags, got %+v", len(expect), last.Tags)
+	}
+	for i, tag := range expect {
+		if last.Tags[i] != tag {
+			t.Errorf("expected tag %+v, got %+v", tag, last.Tags[i])
+		}
+	}
+	if groceries := last.Expenses[1]; groceries.Name != "Groceries" || groceries.Difference != nzd(20) {
+		t.Errorf("expected groceries overspent, got %+v", groceries)
+	}
+	if rent := report.Cycles[0].Expenses[0]; rent.Actual != nzd(500) || !rent.Difference.IsZero() {
+		t.Errorf("expected rent paid as planned, got %+v", rent)
+	}
+	if home := report.Tags[2]; home.Planned != nzd(1000) || home.Actual != nzd(500) {
+		t.Errorf("expected tags totalled over every cycle, got %+v", home)
+	}
+
+	aud := usersave.Money{Minor: 1, Currency: "AUD"}
+	transactions = append(transactions,
+		Transaction{Date: date(time.June, 2), Amount: aud},
+		Transaction{Date: date(time.June, 1), Amount: usersave.Money{Minor: 2, Currency: "USD"}},
+		Transaction{Date: date(time.May, 25), Amount: aud},
+	)
+	report, err = BuildReport(userSave, transactions, time.Date(2021, time.June, 2, 12, 0, 0, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foreign := report.Cycles[1].Foreign; len(foreign) != 2 || foreign[0] != aud || foreign[1].Currency != "USD" {
+		t.Errorf("expected transactions in other currencies totalled by currency, got %+v", foreign)
+	}
+	if report.Cycles[1].Actual != last.Actual || report.Foreign[0].Minor != 2 {
+		t.Errorf("expected transactions in other currencies left out of the comparison, got %+v", report)
+	}
+}
+
+func TestBuildReportRollups(t *testing.T) {
+	nzd := func(minor int64) usersave.Money { return usersave.Money{Minor: minor, Currency: "NZD"} }
+	date := func(month time.Month, day int) usersave.Date {
+		return usersave.Date{Year: 2021, Month: month, Day: day}
+	}
+	limit := nzd(100)
+	userSave := &usersave.JSONUserSave{
+		Cycle: usersave.CycleWeekly,
+		Expenses: []usersave.JSONExpense{
+			{Name: "Rent", Amount: nzd(500), Tag: "Home"},
+			{Name: "Groceries", Amount: nzd(100), Tag: "Food"},
+		},
+		Tags: []usersave.TagDefinition{
+			{ID: "living", Name: "Living", Limit: &limit},
+			{ID: "home", Name: "Home", Parent: "living"},
+			{ID: "food", Name: "Food", Parent: "living"},
+		},
+	}
+	transactions := []Transaction{
+		{Date: date(time.May, 25), Amount: nzd(500), Expense: "Rent"},
+		{Date: date(time.May, 31), Amount: nzd(20), Tag: "Food"},
+		{Date: date(time.June, 1), Amount: nzd(30), Tag: "Fun"},
+	}
+
+	report, err := BuildReport(userSave, transactions, time.Date(2021, time.June, 2, 12, 0, 0, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := report.Cycles[1].Rollups
+	if len(last) != 3 || last[0].ID != "living" || last[2].Parent != "living" {
+		t.Fatalf("expected a rollup for each catalogued tag, got %+v", last)
+	}
+	if last[0].Comparison != (Comparison{nzd(600), nzd(20), nzd(-580)}) || *last[0].Limit != nzd(100) || last[0].OverLimit {
+		t.Errorf("expected living to roll up home and food within its limit, got %+v", last[0])
+	}
+	if last[1].Limit != nil || last[1].Comparison != (Comparison{nzd(500), usersave.Money{}, nzd(-500)}) {
+		t.Errorf("expected home without a limit, got %+v", last[1])
+	}
+	if all := report.Rollups[0]; all.Actual != nzd(520) || *all.Limit != nzd(200) || !all.OverLimit {
+		t.Errorf("expected living over its limit for both cycles, got %+v", all)
+	}
+}
@@ -0,0 +1,205 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"py-server/usersave"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNoRule = errors.New("no such rule")
+
+const (
+	MaxRules         = 100
+	MaxPatternLength = 200
+)
+
+// Rule tags transactions and links them to a planned expense. A transaction
+// matches if it meets every condition the rule sets.
+type Rule struct {
+	ID string `json:"id"`
+	// Priority orders rules, lowest first, the first matching rule applying
+	Priority int `json:"priority"`
+	// Description matches descriptions containing it, ignoring case
+	Description string `json:"description,omitempty"`
+	// Pattern is a regular expression matching descriptions
+	Pattern string `json:"pattern,omitempty"`
+	// MinAmount and MaxAmount match amounts in their currency on or between
+	// them
+	MinAmount *usersave.Money `json:"minAmount,omitempty"`
+	MaxAmount *usersave.Money `json:"maxAmount,omitempty"`
+	// Account matches the account, ignoring case
+	Account string `json:"account,omitempty"`
+	// Tag and Expense are what the rule sets of matching transactions
+	Tag     usersave.Tag `json:"tag,omitempty"`
+	Expense string       `json:"expense,omitempty"`
+}
+
+// Validate checks the rule has a condition and sets something, that its
+// pattern compiles and its amounts make a range
+func (r Rule) Validate() error {
+	if len(r.Description) < 1 && len(r.Pattern) < 1 && r.MinAmount == nil && r.MaxAmount == nil && len(r.Account) < 1 {
+		return errors.New("rule has no conditions")
+	}
+	if len(r.Tag) < 1 && len(r.Expense) < 1 {
+		return errors.New("rule sets no tag or expense")
+	}
+	if len(r.Tag) > MaxTagLength {
+		return fmt.Errorf("tag is longer than %d characters", MaxTagLength)
+	}
+	if len(r.Description) > MaxDescriptionLength {
+		return fmt.Errorf("description is longer than %d characters", MaxDescriptionLength)
+	}
+	if len(r.Account) > MaxAccountLength {
+		return fmt.Errorf("account is longer than %d characters", MaxAccountLength)
+	}
+	if len(r.Pattern) > MaxPatternLength {
+		return fmt.Errorf("pattern is longer than %d characters", MaxPatternLength)
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	for _, amount := range []*usersave.Money{r.MinAmount, r.MaxAmount} {
+		if amount != nil && !usersave.ValidCurrency(amount.Currency) {
+			return fmt.Errorf("unknown currency %q", amount.Currency)
+		}
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil {
+		if r.MinAmount.Currency != r.MaxAmount.Currency {
+			return fmt.Errorf("%w: %s and %s", usersave.ErrCurrencyMismatch, r.MinAmount.Currency, r.MaxAmount.Currency)
+		}
+		if r.MinAmount.Minor > r.MaxAmount.Minor {
+			return errors.New("minimum amount is more than maximum amount")
+		}
+	}
+	return nil
+}
+
+// FindRule returns the index of the rule with the ID
+func (l *Ledger) FindRule(id string) (int, error) {
+	for i, rule := range l.Rules {
+		if rule.ID == id {
+			return i, nil
+		}
+	}
+	return 0, ErrNoRule
+}
+
+// SetRule adds the rule, or replaces the rule with its ID, keeping the rules
+// in priority order
+func (l *Ledger) SetRule(rule Rule) error {
+	if i, err := l.FindRule(rule.ID); err == nil {
+		l.Rules[i] = rule
+	} else if len(l.Rules) >= MaxRules {
+		return fmt.Errorf("ledger already has %d rules", MaxRules)
+	} else {
+		l.Rules = append(l.Rules, rule)
+	}
+	sort.SliceStable(l.Rules, func(i, j int) bool {
+		return l.Rules[i].Priority < l.Rules[j].Priority
+	})
+	return nil
+}
+
+// RemoveRule removes the rule with the ID
+func (l *Ledger) RemoveRule(id string) error {
+	i, err := l.FindRule(id)
+	if err != nil {
+		return err
+	}
+	l.Rules = append(l.Rules[:i], l.Rules[i+1:]...)
+	return nil
+}
+
+// Categoriser applies a ledger's rules to transactions
+type Categoriser struct {
+	rules    []Rule
+	patterns []*regexp.Regexp
+}
+
+// Categoriser returns a Categoriser of the ledger's rules
+func (l *Ledger) Categoriser() (*Categoriser, error) {
+	categoriser := &Categoriser{rules: l.Rules, patterns: make([]*regexp.Regexp, len(l.Rules))}
+	for i, rule := range l.Rules {
+		if len(rule.Pattern) < 1 {
+			continue
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s has an invalid pattern: %w", rule.ID, err)
+		}
+		categoriser.patterns[i] = pattern
+	}
+	return categoriser, nil
+}
+
+// matches returns true if the transaction meets every condition of rule i
+func (c *Categoriser) matches(i int, transaction Transaction) bool {
+	rule := c.rules[i]
+	description := strings.ToLower(transaction.Description)
+	switch {
+	case len(rule.Description) > 0 && !strings.Contains(description, strings.ToLower(rule.Description)):
+	case c.patterns[i] != nil && !c.patterns[i].MatchString(transaction.Description):
+	case rule.MinAmount != nil && (rule.MinAmount.Currency != transaction.Amount.Currency ||
+		transaction.Amount.Minor < rule.MinAmount.Minor):
+	case rule.MaxAmount != nil && (rule.MaxAmount.Currency != transaction.Amount.Currency ||
+		transaction.Amount.Minor > rule.MaxAmount.Minor):
+	case len(rule.Account) > 0 && !strings.EqualFold(rule.Account, transaction.Account):
+	default:
+		return true
+	}
+	return false
+}
+
+// Categorise sets the tag and expense of the first rule the transaction
+// matches, returning the rule's ID and whether the transaction changed. Only
+// a missing tag or expense is set, unless overwrite is set.
+func (c *Categoriser) Categorise(transaction *Transaction, overwrite bool) (string, bool) {
+	for i, rule := range c.rules {
+		if !c.matches(i, *transaction) {
+			continue
+		}
+		changed := false
+		if len(rule.Tag) > 0 && rule.Tag != transaction.Tag && (overwrite || len(transaction.Tag) < 1) {
+			transaction.Tag, changed = rule.Tag, true
+		}
+		if len(rule.Expense) > 0 && rule.Expense != transaction.Expense && (overwrite || len(transaction.Expense) < 1) {
+			transaction.Expense, changed = rule.Expense, true
+		}
+		return rule.ID, changed
+	}
+	return "", false
+}
+
+// RuleChange is a change the rules made to a transaction
+type RuleChange struct {
+	Rule        string      `json:"rule"`
+	Transaction Transaction `json:"transaction"`
+	// PreviousTag and PreviousExpense are what the transaction had before
+	PreviousTag     usersave.Tag `json:"previousTag"`
+	PreviousExpense string       `json:"previousExpense"`
+}
+
+// ApplyRules categorises every transaction in the ledger by its rules,
+// returning the changes made
+func (l *Ledger) ApplyRules(now time.Time, overwrite bool) ([]RuleChange, error) {
+	categoriser, err := l.Categoriser()
+	if err != nil {
+		return nil, err
+	}
+	changes := []RuleChange{}
+	for i := range l.Transactions {
+		transaction := &l.Transactions[i]
+		previousTag, previousExpense := transaction.Tag, transaction.Expense
+		rule, changed := categoriser.Categorise(transaction, overwrite)
+		if !changed {
+			continue
+		}
+		transaction.UpdatedAt = now
+		changes = append(changes, RuleChange{rule, *transaction, previousTag, previousExpense})
+	}
+	return changes, nil
+}
@@ -0,0 +1,135 @@
+package ledger
+
+import (
+	"py-server/usersave"
+	"testing"
+	"time"
+)
+
+func TestRuleValidate(t *testing.T) {
+	nzd := func(minor int64) *usersave.Money { return &usersave.Money{Minor: minor, Currency: "NZD"} }
+	valid := []Rule{
+		{Description: "coffee", Tag: "Food"},
+		{Pattern: `^UBER\b`, Expense: "Transport"},
+		{MinAmount: nzd(100), MaxAmount: nzd(100), Account: "Cheque", Tag: "Food"},
+	}
+	for _, rule := range valid {
+		if err := rule.Validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %s", rule, err)
+		}
+	}
+
+	invalid := []Rule{
+		{Tag: "Food"},
+		{Description: "coffee"},
+		{Pattern: "(", Tag: "Food"},
+		{MinAmount: &usersave.Money{Minor: 100, Currency: "nzd"}, Tag: "Food"},
+		{MinAmount: nzd(200), MaxAmount: nzd(100), Tag: "Food"},
+		{MinAmount: nzd(100), MaxAmount: &usersave.Money{Minor: 200, Currency: "AUD"}, Tag: "Food"},
+	}
+	for _, rule := range invalid {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", rule)
+		}
+	}
+}
+
+func TestSetRule(t *testing.T) {
+	ledger := &Ledger{}
+	for _, rule := range []Rule{{ID: "a", Priority: 2}, {ID: "b", Priority: 1}, {ID: "c", Priority: 2}} {
+		if err := ledger.SetRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ledger.SetRule(Rule{ID: "b", Priority: 3}); err != nil {
+		t.Fatal(err)
+	}
+	order := ""
+	for _, rule := range ledger.Rules {
+		order += rule.ID
+	}
+	if order != "acb" {
+		t.Errorf("expected rules in order acb, got %s", order)
+	}
+	if err := ledger.RemoveRule("c"); err != nil || len(ledger.Rules) != 2 {
+		t.Errorf("expected rule c to be removed, got %v", err)
+	}
+	if err := ledger.RemoveRule("c"); err != ErrNoRule {
+		t.Errorf("expected ErrNoRule removing a removed rule, got %v", err)
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	nzd := func(minor int64) usersave.Money { return usersave.Money{Minor: minor, Currency: "NZD"} }
+	limit := nzd(1000)
+	ledger := &Ledger{
+		Rules: []Rule{
+			{ID: "small coffee", Priority: 1, Description: "COFFEE", MaxAmount: &limit, Tag: "Treats"},
+			{ID: "coffee", Priority: 2, Pattern: `(?i)coffee|cafe`, Tag: "Food", Expense: "Groceries"},
+			{ID: "cheque", Priority: 3, Account: "cheque", Tag: "Bills"},
+		},
+		Transactions: []Transaction{
+			{ID: "1", Description: "Coffee Co", Amount: nzd(450)},
+			{ID: "2", Description: "Corner Cafe", Amount: nzd(2500), Tag: "Dining"},
+			{ID: "3", Description: "Power", Amount: nzd(9000), Account: "Cheque"},
+			{ID: "4", Description: "Books", Amount: nzd(2000)},
+		},
+	}
+	before := append([]Transaction(nil), ledger.Transactions...)
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	changes, err := ledger.ApplyRules(now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ rule, id, tag, expense string }{
+		{"small coffee", "1", "Treats", ""},
+		{"coffee", "2", "Dining", "Groceries"},
+		{"cheque", "3", "Bills", ""},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, expect := range expected {
+		change := changes[i]
+		if change.Rule != expect.rule || change.Transaction.ID != expect.id || change.Transaction.Tag != expect.tag ||
+			change.Transaction.Expense != expect.expense || !change.Transaction.UpdatedAt.Equal(now) {
+			t.Errorf("expected change %d to be %+v, got %+v", i+1, expect, change)
+		}
+		if change.PreviousTag != before[i].Tag {
+			t.Errorf("expected change %d to have previous tag %q, got %q", i+1, before[i].Tag, change.PreviousTag)
+		}
+	}
+	if ledger.Transactions[3].Tag != "" {
+		t.Errorf("expected unmatched transaction not to change, got %+v", ledger.Transactions[3])
+	}
+
+	if changes, err = ledger.ApplyRules(now, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || ledger.Transactions[1].Tag != "Food" {
+		t.Errorf("expected overwriting to replace the dining tag, got %+v", changes)
+	}
+}
+
+func TestPrepareImport(t *testing.T) {
+	coffee := Transaction{
+		Date:        usersave.Date{Year: 2021, Month: time.June, Day: 1},
+		Amount:      usersave.Money{Minor: 450, Currency: "NZD"},
+		Description: "Coffee",
+	}
+	ledger := &Ledger{
+		Transactions: []Transaction{coffee},
+		Rules:        []Rule{{ID: "coffee", Description: "coffee", Tag: "Food"}},
+	}
+	imported, err := ledger.PrepareImport([]Transaction{coffee, coffee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported[0].Rule != "" || imported[0].Tag != "" {
+		t.Errorf("expected duplicate not to be categorised, got %+v", imported[0])
+	}
+	if imported[1].Rule != "coffee" || imported[1].Tag != "Food" {
+		t.Errorf("expected new transaction to be tagged, got %+v", imported[1])
+	}
+}
@@ -0,0 +1,429 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"py-server/usersave"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// StatementFormat is a kind of bank statement file
+type StatementFormat string
+
+const (
+	FormatCSV StatementFormat = "csv"
+	FormatOFX StatementFormat = "ofx"
+	FormatQIF StatementFormat = "qif"
+)
+
+// Valid returns true if the format is one statements can be parsed from
+func (f StatementFormat) Valid() bool {
+	return f == FormatCSV || f == FormatOFX || f == FormatQIF
+}
+
+// StatementOptions describe how to read a statement. Statements list money
+// out as negative amounts, which become positive transactions.
+type StatementOptions struct {
+	// Currency is the currency of the statement's amounts, unless the
+	// statement says otherwise as OFX does
+	Currency string
+	// Account names the account the statement is of, overriding any the
+	// statement names
+	Account string
+	// DateFormat orders a date's YYYY or YY, MM or M and DD or D parts, such
+	// as DD/MM/YYYY. CSV dates default to YYYY-MM-DD and QIF dates to
+	// MM/DD/YYYY.
+	DateFormat string
+	// Columns maps a CSV statement's columns, by header
+	Columns CSVColumns
+}
+
+// CSVColumns names the columns of a CSV statement by their header, ignoring
+// case
+type CSVColumns struct {
+	Date        string
+	Description string
+	// Amount is money in, negative for money out
+	Amount string
+	// Debit and Credit name separate columns of money out and in, instead of
+	// an Amount column
+	Debit  string
+	Credit string
+	// Tag and Account are optional
+	Tag     string
+	Account string
+	// DebitsPositive is set when the Amount column is positive for money out
+	DebitsPositive bool
+}
+
+// DefaultCSVColumns are used for any column left unnamed, unless Debit or
+// Credit are named instead of Amount
+var DefaultCSVColumns = CSVColumns{Date: "Date", Description: "Description", Amount: "Amount"}
+
+// dateLayoutTokens map DateFormat parts to time layouts, longest first. Month
+// and day layouts accept one or two digits.
+var dateLayoutTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"}, {"YY", "06"}, {"MM", "1"}, {"DD", "2"}, {"M", "1"}, {"D", "2"},
+}
+
+// dateLayout converts a DateFormat to a time layout
+func dateLayout(format string) string {
+	layout := ""
+	for len(format) > 0 {
+		matched := false
+		for _, part := range dateLayoutTokens {
+			if strings.HasPrefix(format, part.token) {
+				layout += part.layout
+				format = format[len(part.token):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			layout += format[:1]
+			format = format[1:]
+		}
+	}
+	return layout
+}
+
+// parseStatementDate parses the date per the DateFormat
+func parseStatementDate(value string, format string) (usersave.Date, error) {
+	parsed, err := time.Parse(dateLayout(format), strings.TrimSpace(value))
+	if err != nil {
+		return usersave.Date{}, fmt.Errorf("invalid date %q, expected %s", value, format)
+	}
+	return usersave.DateOf(parsed), nil
+}
+
+// parseStatementAmount parses an amount as banks write them, allowing
+// thousands separators, currency symbols and parentheses for negatives
+func parseStatementAmount(value string, currency string) (usersave.Money, error) {
+	cleaned := strings.TrimSpace(value)
+	negative := strings.HasPrefix(cleaned, "(") && strings.HasSuffix(cleaned, ")")
+	cleaned = strings.Trim(cleaned, "()")
+	cleaned = strings.NewReplacer(",", "", "$", "", "£", "", "€", "", " ", "", "+", "").Replace(cleaned)
+	if len(cleaned) < 1 {
+		return usersave.Money{}, nil
+	}
+	amount, err := usersave.ParseMoney(cleaned, currency)
+	if err != nil {
+		return usersave.Money{}, err
+	}
+	if negative {
+		amount.Minor = -amount.Minor
+	}
+	return amount, nil
+}
+
+// spent converts money in, negative for money out, to a transaction amount
+func spent(amount usersave.Money) usersave.Money {
+	amount.Minor = -amount.Minor
+	return amount
+}
+
+// SkippedTransaction is an entry of a statement that isn't a transaction,
+// such as one for nothing
+type SkippedTransaction struct {
+	// Index is the entry's position in the statement, from 1
+	Index int `json:"index"`
+	Transaction
+	Reason string `json:"reason"`
+}
+
+// ParseStatement parses the transactions from a statement in the format,
+// returning the entries for nothing separately
+func ParseStatement(r io.Reader, format StatementFormat, options StatementOptions) ([]Transaction, []SkippedTransaction, error) {
+	var transactions []Transaction
+	var err error
+	switch format {
+	case FormatCSV:
+		transactions, err = parseCSV(r, options)
+	case FormatOFX:
+		transactions, err = parseOFX(r, options)
+	case FormatQIF:
+		transactions, err = parseQIF(r, options)
+	default:
+		return nil, nil, fmt.Errorf("unknown statement format %q", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := make([]Transaction, 0, len(transactions))
+	skipped := []SkippedTransaction{}
+	for i, transaction := range transactions {
+		if len(options.Account) > 0 {
+			transaction.Account = options.Account
+		}
+		if transaction.Amount.IsZero() {
+			skipped = append(skipped, SkippedTransaction{i + 1, transaction, "no amount"})
+			continue
+		}
+		if err := transaction.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("transaction %d: %w", i+1, err)
+		}
+		kept = append(kept, transaction)
+	}
+	return kept, skipped, nil
+}
+
+func parseCSV(r io.Reader, options StatementOptions) ([]Transaction, error) {
+	columns := options.Columns
+	if len(columns.Date) < 1 {
+		columns.Date = DefaultCSVColumns.Date
+	}
+	if len(columns.Description) < 1 {
+		columns.Description = DefaultCSVColumns.Description
+	}
+	if len(columns.Amount) < 1 && len(columns.Debit) < 1 && len(columns.Credit) < 1 {
+		columns.Amount = DefaultCSVColumns.Amount
+	}
+	dateFormat := options.DateFormat
+	if len(dateFormat) < 1 {
+		dateFormat = "YYYY-MM-DD"
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	indexes := map[string]int{}
+	for i, name := range header {
+		indexes[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	var date, description, amountColumn, debit, credit, tag, account int
+	for _, named := range []struct {
+		name  string
+		index *int
+	}{
+		{columns.Date, &date}, {columns.Description, &description}, {columns.Amount, &amountColumn},
+		{columns.Debit, &debit}, {columns.Credit, &credit}, {columns.Tag, &tag}, {columns.Account, &account},
+	} {
+		*named.index = -1
+		if len(named.name) < 1 {
+			continue
+		}
+		i, found := indexes[strings.ToLower(named.name)]
+		if !found {
+			return nil, fmt.Errorf("CSV has no %q column", named.name)
+		}
+		*named.index = i
+	}
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	transactions := []Transaction{}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return transactions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if len(strings.Join(record, "")) < 1 {
+			continue
+		}
+
+		transaction := Transaction{
+			Description: field(record, description),
+			Tag:         field(record, tag),
+			Account:     field(record, account),
+		}
+		if transaction.Date, err = parseStatementDate(field(record, date), dateFormat); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if amountColumn >= 0 {
+			amount, err := parseStatementAmount(field(record, amountColumn), options.Currency)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", row, err)
+			}
+			if transaction.Amount = spent(amount); columns.DebitsPositive {
+				transaction.Amount = amount
+			}
+		} else {
+			debit, err := parseStatementAmount(field(record, debit), options.Currency)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", row, err)
+			}
+			credit, err := parseStatementAmount(field(record, credit), options.Currency)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", row, err)
+			}
+			if transaction.Amount, err = debit.Sub(credit); err != nil {
+				return nil, fmt.Errorf("row %d: %w", row, err)
+			}
+		}
+		transactions = append(transactions, transaction)
+	}
+}
+
+// ofxElement matches an OFX element's tag and any value following it, in
+// both SGML OFX 1 and XML OFX 2
+var ofxElement = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+func parseOFX(r io.Reader, options StatementOptions) ([]Transaction, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OFX: %w", err)
+	}
+
+	currency, account := options.Currency, ""
+	transactions := []Transaction{}
+	var current *Transaction
+	var amount string
+	for _, match := range ofxElement.FindAllStringSubmatch(string(content), -1) {
+		closing, tag, value := match[1] == "/", strings.ToUpper(match[2]), strings.TrimSpace(html.UnescapeString(match[3]))
+		if tag == "STMTTRN" {
+			if closing && current != nil {
+				parsed, err := parseStatementAmount(amount, currency)
+				if err != nil {
+					return nil, fmt.Errorf("transaction %d: %w", len(transactions)+1, err)
+				}
+				current.Amount, current.Account = spent(parsed), account
+				transactions = append(transactions, *current)
+				current = nil
+			} else if !closing {
+				current, amount = &Transaction{}, ""
+			}
+			continue
+		}
+		if closing || len(value) < 1 {
+			continue
+		}
+
+		switch {
+		case tag == "CURDEF":
+			currency = value
+		case tag == "ACCTID":
+			account = value
+		case current == nil:
+		case tag == "DTPOSTED":
+			if len(value) < 8 {
+				return nil, fmt.Errorf("transaction %d: invalid date %q", len(transactions)+1, value)
+			}
+			if current.Date, err = parseStatementDate(value[:8], "YYYYMMDD"); err != nil {
+				return nil, fmt.Errorf("transaction %d: %w", len(transactions)+1, err)
+			}
+		case tag == "TRNAMT":
+			amount = value
+		case tag == "NAME":
+			current.Description = value
+		case tag == "MEMO":
+			current.Note = value
+		}
+	}
+	if current != nil {
+		return nil, errors.New("OFX ends partway through a transaction")
+	}
+	for i := range transactions {
+		if len(transactions[i].Description) < 1 {
+			transactions[i].Description, transactions[i].Note = transactions[i].Note, ""
+		}
+	}
+	return transactions, nil
+}
+
+// parseQIFDate parses a QIF date, which Quicken writes with two digit years
+// after 2000 such as 1/31'21
+func parseQIFDate(value string, format string) (usersave.Date, error) {
+	value = strings.NewReplacer("'", "/", " ", "").Replace(value)
+	date, err := parseStatementDate(value, format)
+	if err != nil && strings.Contains(format, "YYYY") {
+		if short, shortErr := parseStatementDate(value, strings.Replace(format, "YYYY", "YY", 1)); shortErr == nil {
+			return short, nil
+		}
+	}
+	return date, err
+}
+
+// qifTransactionSections are the QIF sections listing transactions of an
+// account, lower cased. Other sections, such as of categories or investments,
+// are skipped.
+var qifTransactionSections = map[string]bool{
+	"type:bank": true, "type:cash": true, "type:ccard": true, "type:oth a": true, "type:oth l": true,
+}
+
+func parseQIF(r io.Reader, options StatementOptions) ([]Transaction, error) {
+	dateFormat := options.DateFormat
+	if len(dateFormat) < 1 {
+		dateFormat = "MM/DD/YYYY"
+	}
+
+	transactions := []Transaction{}
+	current, account, section := Transaction{}, "", ""
+	started := false
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if len(text) < 1 {
+			continue
+		}
+		code, value := text[0], strings.TrimSpace(text[1:])
+		if code == '!' {
+			section = strings.ToLower(value)
+			continue
+		}
+		if code == '^' {
+			if started {
+				if len(current.Description) < 1 {
+					current.Description, current.Note = current.Note, ""
+				}
+				current.Account = account
+				transactions = append(transactions, current)
+			}
+			current, started = Transaction{}, false
+			continue
+		}
+		if section == "account" && code == 'N' {
+			account = value
+		}
+		if !qifTransactionSections[section] {
+			continue
+		}
+
+		started = true
+		var err error
+		switch code {
+		case 'D':
+			current.Date, err = parseQIFDate(value, dateFormat)
+		case 'T', 'U':
+			var amount usersave.Money
+			amount, err = parseStatementAmount(value, options.Currency)
+			current.Amount = spent(amount)
+		case 'P':
+			current.Description = value
+		case 'M':
+			current.Note = value
+		case 'L':
+			// categories in brackets are transfers between accounts
+			if !strings.HasPrefix(value, "[") {
+				current.Tag = value
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read QIF: %w", err)
+	}
+	if started {
+		return nil, errors.New("QIF ends partway through a transaction")
+	}
+	return transactions, nil
+}
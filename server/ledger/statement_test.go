@@ -0,0 +1,148 @@
+package ledger
+
+import (
+	"py-server/usersave"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatement(t *testing.T) {
+	type expected struct {
+		date        usersave.Date
+		minor       int64
+		currency    string
+		description string
+		tag         string
+		account     string
+	}
+	june := func(day int) usersave.Date { return usersave.Date{Year: 2021, Month: time.June, Day: day} }
+	tests := []struct {
+		name      string
+		statement string
+		format    StatementFormat
+		options   StatementOptions
+		expect    []expected
+	}{
+		{
+			name:      "csv",
+			statement: "\ufeffDate,Description,Amount\n2021-06-01,Coffee,-4.50\n\n2021-06-02,Refund,\"1,200.00\"\n",
+			format:    FormatCSV,
+			options:   StatementOptions{Currency: "NZD"},
+			expect: []expected{
+				{june(1), 450, "NZD", "Coffee", "", ""},
+				{june(2), -120000, "NZD", "Refund", "", ""},
+			},
+		},
+		{
+			name:      "csv columns",
+			statement: "Posted,Payee,Out,In,Category\n1/6/2021,Rent,$500.00,,Home\n02/06/2021,Pay,,(10.00),\n",
+			format:    FormatCSV,
+			options: StatementOptions{Currency: "USD", Account: "Cheque", DateFormat: "DD/MM/YYYY", Columns: CSVColumns{
+				Date: "posted", Description: "Payee", Debit: "Out", Credit: "In", Tag: "Category",
+			}},
+			expect: []expected{
+				{june(1), 50000, "USD", "Rent", "Home", "Cheque"},
+				{june(2), 1000, "USD", "Pay", "", "Cheque"},
+			},
+		},
+		{
+			name:      "csv debits positive",
+			statement: "date,description,amount\n2021-06-01,Coffee,4.50\n",
+			format:    FormatCSV,
+			options:   StatementOptions{Currency: "NZD", Columns: CSVColumns{DebitsPositive: true}},
+			expect:    []expected{{june(1), 450, "NZD", "Coffee", "", ""}},
+		},
+		{
+			name: "ofx 1",
+			statement: `OFXHEADER:100
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><CURDEF>AUD
+<BANKACCTFROM><ACCTID>12-3456<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20210601120000[+12:NZST]<TRNAMT>-4.50<NAME>Coffee &amp; Co<MEMO>Card</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20210602<TRNAMT>100.00<MEMO>Transfer</STMTTRN>
+</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>`,
+			format:  FormatOFX,
+			options: StatementOptions{Currency: "NZD"},
+			expect: []expected{
+				{june(1), 450, "AUD", "Coffee & Co", "", "12-3456"},
+				{june(2), -10000, "AUD", "Transfer", "", "12-3456"},
+			},
+		},
+		{
+			name: "ofx 2",
+			statement: `<?xml version="1.0"?><OFX><STMTRS><BANKTRANLIST>
+<STMTTRN><DTPOSTED>20210603</DTPOSTED><TRNAMT>-20</TRNAMT><NAME>Books</NAME></STMTTRN>
+</BANKTRANLIST></STMTRS></OFX>`,
+			format:  FormatOFX,
+			options: StatementOptions{Currency: "NZD", Account: "Savings"},
+			expect:  []expected{{june(3), 2000, "NZD", "Books", "", "Savings"}},
+		},
+		{
+			name: "qif",
+			statement: "!Option:AutoSwitch\n!Account\nNEveryday\nTBank\n^\n!Clear:AutoSwitch\n" +
+				"!Type:Cat\nNFood\nDGroceries and eating out\nE\n^\n!Type:Bank\nD6/1'21\nT-4.50\nPCoffee\nLFood\n^\n" +
+				"D06/02/2021\r\nU1,000.00\r\nMSalary\r\nL[Savings]\r\n^\r\n!Type:Class\nNWork\nDWork expenses\n^\n",
+			format:  FormatQIF,
+			options: StatementOptions{Currency: "NZD"},
+			expect: []expected{
+				{june(1), 450, "NZD", "Coffee", "Food", "Everyday"},
+				{june(2), -100000, "NZD", "Salary", "", "Everyday"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		transactions, _, err := ParseStatement(strings.NewReader(test.statement), test.format, test.options)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(transactions) != len(test.expect) {
+			t.Errorf("%s: expected %d transactions, got %+v", test.name, len(test.expect), transactions)
+			continue
+		}
+		for i, expect := range test.expect {
+			got := transactions[i]
+			if got.Date != expect.date || got.Amount.Minor != expect.minor || got.Amount.Currency != expect.currency ||
+				got.Description != expect.description || got.Tag != expect.tag || got.Account != expect.account {
+				t.Errorf("%s: expected transaction %d to be %+v, got %+v", test.name, i+1, expect, got)
+			}
+		}
+	}
+}
+
+func TestParseStatementInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		format    StatementFormat
+	}{
+		{"unknown format", "", "xls"},
+		{"empty csv", "", FormatCSV},
+		{"missing column", "Date,Amount\n2021-06-01,1\n", FormatCSV},
+		{"bad date", "Date,Description,Amount\n01/06/2021,Coffee,1\n", FormatCSV},
+		{"bad amount", "Date,Description,Amount\n2021-06-01,Coffee,lots\n", FormatCSV},
+		{"unfinished ofx", "<STMTTRN><DTPOSTED>20210601<TRNAMT>1", FormatOFX},
+		{"unfinished qif", "!Type:Bank\nD6/1/2021\nT1\n", FormatQIF},
+	}
+	for _, test := range tests {
+		if _, _, err := ParseStatement(strings.NewReader(test.statement), test.format, StatementOptions{Currency: "NZD"}); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestParseStatementSkipsNothing(t *testing.T) {
+	statement := "Date,Description,Amount\n2021-06-01,Coffee,-4.50\n2021-06-02,Pending,\n2021-06-03,Fee waived,0.00\n"
+	transactions, skipped, err := ParseStatement(strings.NewReader(statement), FormatCSV, StatementOptions{Currency: "NZD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Description != "Coffee" {
+		t.Errorf("expected only the coffee, got %+v", transactions)
+	}
+	if len(skipped) != 2 || skipped[0].Index != 2 || skipped[1].Description != "Fee waived" || skipped[1].Reason != "no amount" {
+		t.Errorf("expected the entries for nothing skipped, got %+v", skipped)
+	}
+}
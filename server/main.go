@@ -2,13 +2,28 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"py-server/audit"
+	"py-server/policy"
 	"py-server/server"
 	"py-server/storage"
 	"py-server/token"
+	"py-server/usersave"
+	"strings"
+	"time"
+	// users' timezones are loaded even where the system has none
+	_ "time/tzdata"
+)
+
+const (
+	tokenCacheSize        = 10000
+	tokenCacheMaxTTL      = time.Hour
+	tokenCacheNegativeTTL = 10 * time.Second
 )
 
 type opts struct {
@@ -41,9 +56,72 @@ func getBucketName() string {
 	return name
 }
 
+func getDevSecret() string {
+	secret, found := os.LookupEnv("PYSERVER_DEV_SECRET")
+	if !found {
+		return token.DefaultDevSecret
+	}
+	return secret
+}
+
+// setLegacyCurrency sets the currency of amounts in saves from before
+// currencies were recorded
+func setLegacyCurrency() {
+	currency, found := os.LookupEnv("PYSERVER_LEGACY_CURRENCY")
+	if !found {
+		return
+	}
+	if !usersave.ValidCurrency(currency) {
+		log.Fatalf("legacy currency %q is not an ISO 4217 code", currency)
+	}
+	usersave.LegacyCurrency = currency
+}
+
+func splitEnv(key string) []string {
+	value := os.Getenv(key)
+	if len(value) < 1 {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// getAuthorizer returns a sign in policy if any restrictions are configured
+func getAuthorizer() (server.Authorizer, error) {
+	config := policy.Config{
+		HostedDomains: splitEnv("PYSERVER_ALLOWED_HOSTED_DOMAINS"),
+		EmailDomains:  splitEnv("PYSERVER_ALLOWED_EMAIL_DOMAINS"),
+		ListFile:      os.Getenv("PYSERVER_ACCESS_LIST"),
+	}
+	if len(config.HostedDomains) == 0 && len(config.EmailDomains) == 0 && len(config.ListFile) == 0 {
+		return nil, nil
+	}
+	return policy.MakePolicy(config)
+}
+
+// getRoleResolver returns the roles configured by user ID or token claim
+func getRoleResolver() server.RoleResolver {
+	return policy.MakeRoles(policy.RolesConfig{
+		UserIDs: map[server.Role][]string{
+			server.RoleAdmin:   splitEnv("PYSERVER_ADMIN_USERS"),
+			server.RoleSupport: splitEnv("PYSERVER_SUPPORT_USERS"),
+		},
+		Claim: os.Getenv("PYSERVER_ROLES_CLAIM"),
+	})
+}
+
+// getAuditSink returns a file sink if PYSERVER_AUDIT_LOG names a file, or
+// keeps the audit trail in the bucket otherwise
+func getAuditSink(storer *storage.GoogleStorer) server.AuditSink {
+	if path, found := os.LookupEnv("PYSERVER_AUDIT_LOG"); found {
+		return audit.MakeFileSink(path)
+	}
+	return storer.AuditSink()
+}
+
 func main() {
 	ctx := context.Background()
 	opts := getOpts()
+	setLegacyCurrency()
 
 	allowedOrigin := os.Getenv("PYSERVER_ALLOWED_ORIGIN")
 	serverAddr := getServerAddr()
@@ -62,10 +140,50 @@ func main() {
 	defer storer.Close()
 	log.Println("google cloud storer up")
 
+	// access tokens aren't cached so revoking them takes effect immediately
+	accessTokens := token.MakeAccessTokenChecker(storer)
+	googleTokens := token.MakeCachingTokenChecker(
+		token.MakeGoogleTokenChecker(checkerClientID),
+		tokenCacheSize, tokenCacheMaxTTL, tokenCacheNegativeTTL,
+	)
+	expvar.Publish("googleTokenCache", expvar.Func(func() interface{} {
+		return googleTokens.Stats()
+	}))
+	tokenChecker := token.MultiTokenChecker{
+		accessTokens,
+		googleTokens,
+	}
+	if opts.development {
+		log.Println("!! accepting development tokens, anyone can sign in as any user")
+		tokenChecker = append(token.MultiTokenChecker{token.MakeDevTokenChecker(getDevSecret())}, tokenChecker...)
+	}
+
+	authorizer, err := getAuthorizer()
+	if err != nil {
+		log.Fatalf("failed to make sign in policy: %s", err)
+	}
+	if authorizer != nil {
+		log.Println("restricting sign in by policy")
+	}
+
 	routeHandlers := server.AppRouteHandlers{
-		UserSaveStorer: storer,
-		TokenChecker:   token.MakeGoogleTokenChecker(checkerClientID),
+		UserSaveStorer:     storer,
+		TokenChecker:       tokenChecker,
+		Authorizer:         authorizer,
+		RoleResolver:       getRoleResolver(),
+		AuditSink:          getAuditSink(storer),
+		AccessTokenManager: accessTokens,
+		TombstoneStorer:    storer,
+		LedgerStorer:       storer,
+		Caches:             []server.UserForgetter{googleTokens},
 	}
+	if metricsAddr, found := os.LookupEnv("PYSERVER_METRICS_ADDR"); found {
+		go func() {
+			log.Printf("serving metrics on %s", metricsAddr)
+			log.Printf("!! metrics server exited: %s", http.ListenAndServe(metricsAddr, expvar.Handler()))
+		}()
+	}
+
 	shutdownServer := make(chan error)
 	go server.Serve(ctx, serverAddr, shutdownServer, server.Route(routeHandlers, allowedOrigin))
 
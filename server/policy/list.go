@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval limits how often the list file is checked for changes
+const reloadCheckInterval = 5 * time.Second
+
+// AccessList allows or denies emails, or whole domains written as "@domain".
+// Denials win over allowances.
+type AccessList struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// Allows returns true if the email or its domain is allowed
+func (l AccessList) Allows(email string, domain string) bool {
+	return l.allow[email] || l.allow["@"+domain]
+}
+
+// Denies returns true if the email or its domain is denied
+func (l AccessList) Denies(email string, domain string) bool {
+	return l.deny[email] || l.deny["@"+domain]
+}
+
+// AllowsAny returns true if the list allows anything, restricting sign in to
+// what it allows
+func (l AccessList) AllowsAny() bool {
+	return len(l.allow) > 0
+}
+
+// ParseAccessList reads a list of "allow" or "deny" lines, each followed by
+// an email or "@domain". Blank lines and lines starting with # are ignored.
+func ParseAccessList(r io.Reader) (AccessList, error) {
+	list := AccessList{
+		allow: map[string]bool{},
+		deny:  map[string]bool{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return list, fmt.Errorf("line %d: expected a rule and an email or @domain", lineNumber)
+		}
+		entry := strings.ToLower(fields[1])
+		switch fields[0] {
+		case "allow":
+			list.allow[entry] = true
+		case "deny":
+			list.deny[entry] = true
+		default:
+			return list, fmt.Errorf("line %d: unknown rule %q", lineNumber, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return list, fmt.Errorf("failed to read access list: %w", err)
+	}
+	return list, nil
+}
+
+// reloadingList is an AccessList loaded from a file, reloaded when the
+// file's modification time changes. A list which fails to reload is kept.
+type reloadingList struct {
+	path string
+	now  func() time.Time
+
+	mu          sync.Mutex
+	list        AccessList
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+func (r *reloadingList) load() error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	list, err := ParseAccessList(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.path, err)
+	}
+
+	r.list = list
+	r.modTime = info.ModTime()
+	return nil
+}
+
+func (r *reloadingList) current() AccessList {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.lastChecked) < reloadCheckInterval {
+		return r.list
+	}
+	r.lastChecked = now
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		log.Printf("!! failed to check access list: %s", err)
+		return r.list
+	}
+	if info.ModTime().Equal(r.modTime) {
+		return r.list
+	}
+	if err := r.load(); err != nil {
+		log.Printf("!! failed to reload access list, keeping previous: %s", err)
+		return r.list
+	}
+	log.Printf("reloaded access list %s", r.path)
+	return r.list
+}
+
+func makeReloadingList(path string) (*reloadingList, error) {
+	list := &reloadingList{
+		path: path,
+		now:  time.Now,
+	}
+	if err := list.load(); err != nil {
+		return nil, fmt.Errorf("failed to load access list: %w", err)
+	}
+	list.lastChecked = list.now()
+	return list, nil
+}
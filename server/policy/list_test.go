@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAccessList(t *testing.T) {
+	list, err := ParseAccessList(strings.NewReader(`
+# staff
+allow @Example.com
+allow bob@gmail.com
+deny eve@example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Allows("alice@example.com", "example.com") || !list.Allows("bob@gmail.com", "gmail.com") {
+		t.Error("expected allowed entries to be allowed")
+	}
+	if list.Allows("eve@gmail.com", "gmail.com") {
+		t.Error("expected unlisted email not to be allowed")
+	}
+	if !list.Denies("eve@example.com", "example.com") {
+		t.Error("expected denied email to be denied")
+	}
+
+	invalid := []string{"allow", "permit bob@gmail.com", "allow a b"}
+	for _, text := range invalid {
+		if _, err := ParseAccessList(strings.NewReader(text)); err == nil {
+			t.Errorf("expected error parsing %q", text)
+		}
+	}
+}
+
+func TestReloadingList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.list")
+	if err := os.WriteFile(path, []byte("allow bob@gmail.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := makeReloadingList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	list.now = func() time.Time { return now }
+
+	if err := os.WriteFile(path, []byte("allow alice@gmail.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !list.current().Allows("bob@gmail.com", "gmail.com") {
+		t.Error("expected list not to be reloaded before the check interval")
+	}
+
+	now = now.Add(reloadCheckInterval)
+	if current := list.current(); !current.Allows("alice@gmail.com", "gmail.com") || current.Allows("bob@gmail.com", "gmail.com") {
+		t.Error("expected list to be reloaded once changed")
+	}
+
+	if err := os.WriteFile(path, []byte("nonsense\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(reloadCheckInterval)
+	if !list.current().Allows("alice@gmail.com", "gmail.com") {
+		t.Error("expected previous list to be kept when reloading fails")
+	}
+}
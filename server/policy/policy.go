@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"py-server/server"
+	"strings"
+)
+
+// Config describes who a Policy allows. Domains and emails are compared case
+// insensitively.
+type Config struct {
+	// HostedDomains are Google Workspace domains, from the token's hd claim
+	HostedDomains []string
+	// EmailDomains are domains of verified email addresses
+	EmailDomains []string
+	// ListFile is an optional AccessList file, reloaded when it changes
+	ListFile string
+}
+
+// Policy is an Authorizer restricting the server to identities from allowed
+// hosted domains or email domains, or allowed by an AccessList. Anyone not
+// denied is allowed when nothing is configured to be allowed.
+type Policy struct {
+	hostedDomains map[string]bool
+	emailDomains  map[string]bool
+	list          *reloadingList
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return email[at+1:]
+}
+
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if len(value) > 0 {
+			set[value] = true
+		}
+	}
+	return set
+}
+
+// Authorize allows the identity if its hosted domain, verified email or email
+// domain is allowed and it isn't denied by the list
+func (p *Policy) Authorize(ctx context.Context, identity server.Identity) error {
+	list := AccessList{}
+	if p.list != nil {
+		list = p.list.current()
+	}
+
+	email := strings.ToLower(identity.Email)
+	domain := emailDomain(email)
+	if len(email) > 0 && list.Denies(email, domain) {
+		return fmt.Errorf("%s is not allowed to sign in", email)
+	}
+
+	if len(p.hostedDomains) == 0 && len(p.emailDomains) == 0 && !list.AllowsAny() {
+		return nil
+	}
+	if hostedDomain := strings.ToLower(identity.HostedDomain); len(hostedDomain) > 0 && p.hostedDomains[hostedDomain] {
+		return nil
+	}
+	if len(email) < 1 || !identity.EmailVerified {
+		return errors.New("a verified email address is required to sign in")
+	}
+	if p.emailDomains[domain] || list.Allows(email, domain) {
+		return nil
+	}
+	return fmt.Errorf("%s is not allowed to sign in", email)
+}
+
+// MakePolicy returns a Policy for the config, failing if the list file
+// can't be loaded
+func MakePolicy(config Config) (*Policy, error) {
+	policy := &Policy{
+		hostedDomains: toSet(config.HostedDomains),
+		emailDomains:  toSet(config.EmailDomains),
+	}
+	if len(config.ListFile) > 0 {
+		list, err := makeReloadingList(config.ListFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.list = list
+	}
+	return policy, nil
+}
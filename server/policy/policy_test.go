@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"py-server/server"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	listFile := filepath.Join(t.TempDir(), "access.list")
+	err := os.WriteFile(listFile, []byte("allow alice@gmail.com\ndeny mallory@example.com\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := MakePolicy(Config{
+		HostedDomains: []string{"example.com"},
+		EmailDomains:  []string{"Example.org"},
+		ListFile:      listFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		identity server.Identity
+		allowed  bool
+	}{
+		"hosted domain":         {server.Identity{HostedDomain: "example.com"}, true},
+		"other hosted domain":   {server.Identity{HostedDomain: "example.net"}, false},
+		"email domain":          {server.Identity{Email: "bob@example.org", EmailVerified: true}, true},
+		"unverified email":      {server.Identity{Email: "bob@example.org"}, false},
+		"listed email":          {server.Identity{Email: "Alice@gmail.com", EmailVerified: true}, true},
+		"unlisted email":        {server.Identity{Email: "eve@gmail.com", EmailVerified: true}, false},
+		"denied in hosted":      {server.Identity{Email: "mallory@example.com", EmailVerified: true, HostedDomain: "example.com"}, false},
+		"no email or domain":    {server.Identity{UserID: "someone"}, false},
+		"hosted, no email":      {server.Identity{UserID: "someone", HostedDomain: "EXAMPLE.COM"}, true},
+		"unverified in allowed": {server.Identity{Email: "alice@gmail.com"}, false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := policy.Authorize(context.Background(), test.identity)
+			if allowed := err == nil; allowed != test.allowed {
+				t.Errorf("expected allowed %t, got error %v", test.allowed, err)
+			}
+		})
+	}
+}
+
+func TestPolicyOnlyDenies(t *testing.T) {
+	policy, err := MakePolicy(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Authorize(context.Background(), server.Identity{UserID: "anyone"}); err != nil {
+		t.Errorf("expected empty policy to allow anyone, got %s", err)
+	}
+
+	if _, err := MakePolicy(Config{ListFile: "does not exist"}); err == nil {
+		t.Error("expected error for missing list file")
+	}
+}
@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"context"
+	"py-server/server"
+	"sort"
+	"strings"
+)
+
+// knownRoles are the only roles which can be granted by a claim
+var knownRoles = map[server.Role]bool{
+	server.RoleAdmin:   true,
+	server.RoleSupport: true,
+}
+
+// RolesConfig describes who holds which roles
+type RolesConfig struct {
+	// UserIDs maps each role to the IDs of the users holding it
+	UserIDs map[server.Role][]string
+	// Claim optionally names a token claim listing the user's roles, either
+	// as a list or a comma separated string
+	Claim string
+}
+
+// Roles is a RoleResolver granting roles by user ID or token claim
+type Roles struct {
+	userRoles map[string][]server.Role
+	claim     string
+}
+
+func claimRoles(value interface{}) []server.Role {
+	names := []string{}
+	switch value := value.(type) {
+	case string:
+		names = strings.Split(value, ",")
+	case []interface{}:
+		for _, name := range value {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	roles := []server.Role{}
+	for _, name := range names {
+		role := server.Role(strings.TrimSpace(name))
+		if knownRoles[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Roles returns the roles held by the identity's user ID and claims
+func (r Roles) Roles(ctx context.Context, identity server.Identity) []server.Role {
+	held := map[server.Role]bool{}
+	roles := []server.Role{}
+	grant := func(granted []server.Role) {
+		for _, role := range granted {
+			if !held[role] {
+				held[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	grant(r.userRoles[identity.UserID])
+	if len(r.claim) > 0 {
+		if value, ok := identity.Claims[r.claim]; ok {
+			grant(claimRoles(value))
+		}
+	}
+	return roles
+}
+
+// MakeRoles returns a new Roles for the config
+func MakeRoles(config RolesConfig) Roles {
+	userRoles := map[string][]server.Role{}
+	for role, userIDs := range config.UserIDs {
+		for _, userID := range userIDs {
+			userID = strings.TrimSpace(userID)
+			if len(userID) > 0 {
+				userRoles[userID] = append(userRoles[userID], role)
+			}
+		}
+	}
+	for _, roles := range userRoles {
+		sort.Slice(roles, func(i, j int) bool { return roles[i] < roles[j] })
+	}
+	return Roles{
+		userRoles: userRoles,
+		claim:     config.Claim,
+	}
+}
@@ -0,0 +1,40 @@
+package policy
+
+import (
+	"context"
+	"py-server/server"
+	"reflect"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	roles := MakeRoles(RolesConfig{
+		UserIDs: map[server.Role][]string{
+			server.RoleAdmin:   {"admin-user"},
+			server.RoleSupport: {"support-user", "admin-user"},
+		},
+		Claim: "py_roles",
+	})
+
+	tests := map[string]struct {
+		identity server.Identity
+		expect   []server.Role
+	}{
+		"nobody":          {server.Identity{UserID: "nobody"}, []server.Role{}},
+		"support by id":   {server.Identity{UserID: "support-user"}, []server.Role{server.RoleSupport}},
+		"admin by id":     {server.Identity{UserID: "admin-user"}, []server.Role{server.RoleAdmin, server.RoleSupport}},
+		"claim list":      {server.Identity{Claims: map[string]interface{}{"py_roles": []interface{}{"admin", "root"}}}, []server.Role{server.RoleAdmin}},
+		"claim string":    {server.Identity{Claims: map[string]interface{}{"py_roles": "support, admin"}}, []server.Role{server.RoleSupport, server.RoleAdmin}},
+		"other claim":     {server.Identity{Claims: map[string]interface{}{"roles": "admin"}}, []server.Role{}},
+		"id and claim":    {server.Identity{UserID: "support-user", Claims: map[string]interface{}{"py_roles": "support"}}, []server.Role{server.RoleSupport}},
+		"malformed claim": {server.Identity{Claims: map[string]interface{}{"py_roles": 1}}, []server.Role{}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := roles.Roles(context.Background(), test.identity)
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %v, got %v", test.expect, got)
+			}
+		})
+	}
+}
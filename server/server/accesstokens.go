@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrNoAccessToken = errors.New("no such access token")
+
+const maxAccessTokenNameLength = 64
+
+// AccessTokenScope limits what a personal access token may be used for
+type AccessTokenScope string
+
+const (
+	ScopeRead      AccessTokenScope = "read"
+	ScopeReadWrite AccessTokenScope = "read-write"
+)
+
+// Valid returns true if the scope is a known scope
+func (s AccessTokenScope) Valid() bool {
+	return s == ScopeRead || s == ScopeReadWrite
+}
+
+// AccessToken describes a personal access token. The token itself is only
+// ever available at the time it is created.
+type AccessToken struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Scope      AccessTokenScope `json:"scope"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	LastUsedAt *time.Time       `json:"lastUsedAt,omitempty"`
+}
+
+// AccessTokenManager defines methods for managing a user's personal access
+// tokens
+type AccessTokenManager interface {
+	// CreateAccessToken creates a new named token for the owner, returning
+	// the token itself alongside its description. Tokens carry the owner's
+	// email and domain so they are authorized just as the owner is.
+	CreateAccessToken(ctx context.Context, owner Identity, name string, scope AccessTokenScope) (string, AccessToken, error)
+	// ListAccessTokens returns all tokens belonging to the UserID
+	ListAccessTokens(ctx context.Context, userID string) ([]AccessToken, error)
+	// RevokeAccessToken should return ErrNoAccessToken if the UserID has no
+	// token with the given ID
+	RevokeAccessToken(ctx context.Context, userID string, tokenID string) error
+}
+
+type createAccessTokenRequest struct {
+	Name  string           `json:"name"`
+	Scope AccessTokenScope `json:"scope"`
+}
+
+type createAccessTokenResponse struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+// listAccessTokensHandler generates an authenticatedRequestHandler listing the
+// user's personal access tokens
+func listAccessTokensHandler(manager AccessTokenManager) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to list access tokens")
+
+		tokens, err := manager.ListAccessTokens(req.req.Context(), req.userID)
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to list access tokens: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to list access tokens")
+			return
+		}
+		if tokens == nil {
+			tokens = []AccessToken{}
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, tokens)
+		LogWithID(req.req.Context(), "sent %d access tokens", len(tokens))
+	}
+}
+
+// createAccessTokenHandler generates an authenticatedRequestHandler creating a
+// personal access token from the JSON name and scope in the request body
+func createAccessTokenHandler(manager AccessTokenManager) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to create access token")
+
+		if req.req.Body == nil {
+			LogWithID(req.req.Context(), "no body given")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "No body")
+			return
+		}
+
+		body := createAccessTokenRequest{}
+		if err := json.NewDecoder(req.req.Body).Decode(&body); err != nil {
+			LogWithID(req.req.Context(), "failed to decode access token request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Failed to decode access token request")
+			return
+		}
+
+		body.Name = strings.TrimSpace(body.Name)
+		if len(body.Name) < 1 || len(body.Name) > maxAccessTokenNameLength {
+			LogWithID(req.req.Context(), "invalid access token name")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Name must be between 1 and %d characters", maxAccessTokenNameLength)
+			return
+		}
+		if !body.Scope.Valid() {
+			LogWithID(req.req.Context(), "invalid access token scope %q", body.Scope)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Scope must be %q or %q", ScopeRead, ScopeReadWrite)
+			return
+		}
+
+		token, accessToken, err := manager.CreateAccessToken(req.req.Context(), req.identity, body.Name, body.Scope)
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to create access token: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to create access token")
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusCreated, createAccessTokenResponse{
+			AccessToken: accessToken,
+			Token:       token,
+		})
+		LogWithID(req.req.Context(), "created access token %s", accessToken.ID)
+	}
+}
+
+// revokeAccessTokenHandler generates an authenticatedRequestHandler revoking
+// the personal access token whose ID is the last element of the path
+func revokeAccessTokenHandler(manager AccessTokenManager) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to revoke access token")
+
+		tokenID := strings.TrimPrefix(req.req.URL.Path, "/v1/tokens/")
+		if len(tokenID) < 1 || strings.Contains(tokenID, "/") {
+			LogWithID(req.req.Context(), "invalid access token id")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "No such access token")
+			return
+		}
+
+		err := manager.RevokeAccessToken(req.req.Context(), req.userID, tokenID)
+		if err != nil {
+			if errors.Is(err, ErrNoAccessToken) {
+				LogWithID(req.req.Context(), "failed to revoke access token: none found")
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "No such access token")
+				return
+			}
+			LogWithID(req.req.Context(), "!! failed to revoke access token: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to revoke access token")
+			return
+		}
+		LogWithID(req.req.Context(), "revoked access token %s", tokenID)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Revoked access token")
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fake manager holding tokens in memory
+type testAccessTokenManager struct {
+	tokens map[string][]AccessToken
+}
+
+func (m *testAccessTokenManager) CreateAccessToken(ctx context.Context, owner Identity, name string, scope AccessTokenScope) (string, AccessToken, error) {
+	token := AccessToken{ID: name + "-id", Name: name, Scope: scope, CreatedAt: time.Now()}
+	m.tokens[owner.UserID] = append(m.tokens[owner.UserID], token)
+	return "secret", token, nil
+}
+
+func (m *testAccessTokenManager) ListAccessTokens(ctx context.Context, userID string) ([]AccessToken, error) {
+	return m.tokens[userID], nil
+}
+
+func (m *testAccessTokenManager) RevokeAccessToken(ctx context.Context, userID string, tokenID string) error {
+	for i, token := range m.tokens[userID] {
+		if token.ID == tokenID {
+			m.tokens[userID] = append(m.tokens[userID][:i], m.tokens[userID][i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoAccessToken
+}
+
+func makeAuthedRequest(t *testing.T, method string, path string, body string) *authenticatedRequest {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticatedRequest{
+		req:      req,
+		userID:   "some user id",
+		identity: Identity{UserID: "some user id"},
+	}
+}
+
+func TestAccessTokenHandlers(t *testing.T) {
+	manager := &testAccessTokenManager{tokens: map[string][]AccessToken{}}
+
+	createTests := map[string]int{
+		`{"name": "cron", "scope": "read"}`:         http.StatusCreated,
+		`{"name": "deploy", "scope": "read-write"}`: http.StatusCreated,
+		`{"name": "", "scope": "read"}`:             http.StatusBadRequest,
+		`{"name": "cron", "scope": "admin"}`:        http.StatusBadRequest,
+		`not json`:                                  http.StatusBadRequest,
+	}
+	for body, expectedCode := range createTests {
+		t.Run(body, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			createAccessTokenHandler(manager)(rr, makeAuthedRequest(t, "POST", "/v1/tokens", body))
+			if code := rr.Code; code != expectedCode {
+				t.Errorf("expected status code %d, got %d", expectedCode, code)
+			}
+			if expectedCode == http.StatusCreated && !strings.Contains(rr.Body.String(), `"token":"secret"`) {
+				t.Errorf("expected token in response, got %s", rr.Body.String())
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	listAccessTokensHandler(manager)(rr, makeAuthedRequest(t, "GET", "/v1/tokens", ""))
+	tokens := []AccessToken{}
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(tokens))
+	}
+
+	revokeTests := map[string]int{
+		"/v1/tokens/cron-id":   http.StatusOK,
+		"/v1/tokens/cron-id/a": http.StatusNotFound,
+		"/v1/tokens/missing":   http.StatusNotFound,
+	}
+	for path, expectedCode := range revokeTests {
+		t.Run(path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			revokeAccessTokenHandler(manager)(rr, makeAuthedRequest(t, "DELETE", path, ""))
+			if code := rr.Code; code != expectedCode {
+				t.Errorf("expected status code %d, got %d", expectedCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"py-server/usersave"
+	"time"
+)
+
+var ErrNoTombstone = errors.New("no such tombstone")
+
+// ActionDeleteAccount is audited once the account is erased, so the trail
+// begins again with who deleted it
+const ActionDeleteAccount = "account.delete"
+
+// Tombstone records the erasure of an account, proving it was deleted
+// without holding on to who it belonged to.
+type Tombstone struct {
+	// UserHash is the hex encoded SHA-256 hash of the user's ID
+	UserHash    string    `json:"userHash"`
+	RequestedAt time.Time `json:"requestedAt"`
+	// Steps maps each erasure step to when it completed
+	Steps       map[string]time.Time `json:"steps"`
+	CompletedAt *time.Time           `json:"completedAt,omitempty"`
+}
+
+// TombstoneStorer defines methods for keeping Tombstones
+type TombstoneStorer interface {
+	// FetchTombstone should return ErrNoTombstone if there is none for the
+	// user hash
+	FetchTombstone(ctx context.Context, userHash string) (Tombstone, error)
+	// SaveTombstone should create or overwrite the tombstone
+	SaveTombstone(ctx context.Context, tombstone Tombstone) error
+}
+
+// UserSaveEraser may be implemented by a UserSaveStorer which keeps more than
+// the current save, such as previous versions
+type UserSaveEraser interface {
+	// EraseUserSave removes everything held for the user's save, succeeding
+	// if there is nothing
+	EraseUserSave(ctx context.Context, userID string) error
+}
+
+// AuditEraser may be implemented by an AuditSink able to erase a user's
+// entries
+type AuditEraser interface {
+	// EraseAudit removes all the user's entries
+	EraseAudit(ctx context.Context, userID string) error
+}
+
+// UserForgetter is implemented by caches holding anything about a user
+type UserForgetter interface {
+	ForgetUser(userID string)
+}
+
+// HashUserID returns the hash identifying a user in their Tombstone
+func HashUserID(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:])
+}
+
+// UserSaveVersioner may be implemented by a UserSaveStorer which keeps the
+// previous versions of saves
+type UserSaveVersioner interface {
+	// ListUserSaveVersions describes every version of the user's save,
+	// including the current one
+	ListUserSaveVersions(ctx context.Context, userID string) ([]UserSaveInfo, error)
+	// FetchUserSaveVersion returns a reader for the given generation of the
+	// user's save, or ErrNoUserSave if there is no such version
+	FetchUserSaveVersion(ctx context.Context, userID string, generation int64) (io.ReadCloser, error)
+}
+
+// exportPart writes some of a user's data into their export
+type exportPart struct {
+	name  string
+	write func(ctx context.Context, userID string, archive *zip.Writer) error
+}
+
+// createFile adds a file to the archive, stamped with the current time
+func createFile(archive *zip.Writer, name string) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+func writeJSONFile(archive *zip.Writer, name string, v interface{}) error {
+	file, err := createFile(archive, name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// userSaveExport exports the current save as JSON, with CSVs of its expenses
+// and savings if it can be decoded
+func userSaveExport(storer UserSaveStorer) exportPart {
+	return exportPart{"usersave", func(ctx context.Context, userID string, archive *zip.Writer) error {
+		reader, err := storer.Fetch(userID)
+		if err != nil {
+			if errors.Is(err, ErrNoUserSave) {
+				return nil
+			}
+			return err
+		}
+		defer reader.Close()
+
+		file, err := createFile(archive, "usersave.json")
+		if err != nil {
+			return err
+		}
+		// the save is written to the export as it's decoded, then whatever
+		// the decoder didn't read is copied after it
+		userSave, err := usersave.DecodeUserSave(io.TeeReader(reader, file))
+		if _, copyErr := io.Copy(file, reader); copyErr != nil {
+			return copyErr
+		}
+		if err != nil {
+			LogWithID(ctx, "not exporting CSVs of undecodable usersave: %s", err)
+			return nil
+		}
+		if file, err = createFile(archive, "expenses.csv"); err != nil {
+			return err
+		}
+		if err := usersave.WriteExpensesCSV(userSave, file); err != nil {
+			return err
+		}
+		if file, err = createFile(archive, "savings.csv"); err != nil {
+			return err
+		}
+		return usersave.WriteSavingsCSV(userSave, file)
+	}}
+}
+
+// versionsExport exports every previous version of the save
+func versionsExport(versioner UserSaveVersioner) exportPart {
+	return exportPart{"versions", func(ctx context.Context, userID string, archive *zip.Writer) error {
+		versions, err := versioner.ListUserSaveVersions(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := writeJSONFile(archive, "versions/index.json", versions); err != nil {
+			return err
+		}
+
+		for _, version := range versions {
+			reader, err := versioner.FetchUserSaveVersion(ctx, userID, version.Generation)
+			if err != nil {
+				if errors.Is(err, ErrNoUserSave) {
+					continue
+				}
+				return err
+			}
+			file, err := createFile(archive, fmt.Sprintf("versions/%d.json", version.Generation))
+			if err == nil {
+				_, err = io.Copy(file, reader)
+			}
+			reader.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// auditExport exports the user's whole audit trail, each page starting after
+// the last entry of the one before so entries recorded at the same time
+// aren't missed
+func auditExport(sink AuditSink) exportPart {
+	return exportPart{"audit", func(ctx context.Context, userID string, archive *zip.Writer) error {
+		entries := []AuditEntry{}
+		query := AuditQuery{UserID: userID, Limit: maxAuditLimit}
+		for {
+			page, err := sink.Query(ctx, query)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			last := page[len(page)-1]
+			query.Before, query.BeforeID = last.Time, last.ID
+		}
+		return writeJSONFile(archive, "audit.json", entries)
+	}}
+}
+
+// accessTokensExport exports descriptions of the user's access tokens
+func accessTokensExport(manager AccessTokenManager) exportPart {
+	return exportPart{"accesstokens", func(ctx context.Context, userID string, archive *zip.Writer) error {
+		tokens, err := manager.ListAccessTokens(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if tokens == nil {
+			tokens = []AccessToken{}
+		}
+		return writeJSONFile(archive, "accesstokens.json", tokens)
+	}}
+}
+
+// exportHandler generates an authenticatedRequestHandler streaming a zip of
+// everything held about the user. Once streaming starts failures can't be
+// reported, so a failed export is cut short.
+func exportHandler(parts []exportPart) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to export account")
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="payyourself-export.zip"`)
+		w.WriteHeader(http.StatusOK)
+
+		archive := zip.NewWriter(w)
+		for _, part := range parts {
+			if err := part.write(ctx, req.userID, archive); err != nil {
+				LogWithID(ctx, "!! failed to export %s, abandoning export: %s", part.name, err)
+				return
+			}
+		}
+		if err := archive.Close(); err != nil {
+			LogWithID(ctx, "!! failed to finish export: %s", err)
+			return
+		}
+		LogWithID(ctx, "exported account")
+	}
+}
+
+// erasureStep erases some of a user's data. Steps must succeed when there is
+// nothing left to erase, so they can be repeated.
+type erasureStep struct {
+	name  string
+	erase func(ctx context.Context, userID string) error
+}
+
+// userSaveErasure removes the user's save, and everything else the storer
+// holds for it if it can
+func userSaveErasure(storer UserSaveStorer) erasureStep {
+	return erasureStep{"usersave", func(ctx context.Context, userID string) error {
+		if eraser, ok := storer.(UserSaveEraser); ok {
+			return eraser.EraseUserSave(ctx, userID)
+		}
+		err := storer.Remove(ctx, userID)
+		if errors.Is(err, ErrNoUserSave) {
+			return nil
+		}
+		return err
+	}}
+}
+
+// accessTokensErasure revokes all the user's access tokens
+func accessTokensErasure(manager AccessTokenManager) erasureStep {
+	return erasureStep{"accesstokens", func(ctx context.Context, userID string) error {
+		tokens, err := manager.ListAccessTokens(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			err := manager.RevokeAccessToken(ctx, userID, token.ID)
+			if err != nil && !errors.Is(err, ErrNoAccessToken) {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// cachesErasure has every cache forget the user
+func cachesErasure(caches []UserForgetter) erasureStep {
+	return erasureStep{"caches", func(ctx context.Context, userID string) error {
+		for _, cache := range caches {
+			cache.ForgetUser(userID)
+		}
+		return nil
+	}}
+}
+
+// auditErasure removes the user's audit trail
+func auditErasure(eraser AuditEraser) erasureStep {
+	return erasureStep{"audit", eraser.EraseAudit}
+}
+
+// eraseAccount runs each step not already completed in the user's tombstone,
+// saving the tombstone after each so a failed erasure can be resumed. A
+// completed tombstone is started over, so erasure can always be repeated.
+func eraseAccount(ctx context.Context, tombstones TombstoneStorer, steps []erasureStep, userID string) (Tombstone, error) {
+	now := time.Now().UTC()
+	tombstone, err := tombstones.FetchTombstone(ctx, HashUserID(userID))
+	if err != nil && !errors.Is(err, ErrNoTombstone) {
+		return tombstone, fmt.Errorf("failed to fetch tombstone: %w", err)
+	}
+	if err != nil || tombstone.CompletedAt != nil {
+		tombstone = Tombstone{
+			UserHash:    HashUserID(userID),
+			RequestedAt: now,
+			Steps:       map[string]time.Time{},
+		}
+	}
+
+	for _, step := range steps {
+		if _, done := tombstone.Steps[step.name]; done {
+			continue
+		}
+		if err := step.erase(ctx, userID); err != nil {
+			return tombstone, fmt.Errorf("failed to erase %s: %w", step.name, err)
+		}
+		tombstone.Steps[step.name] = time.Now().UTC()
+		if err := tombstones.SaveTombstone(ctx, tombstone); err != nil {
+			return tombstone, fmt.Errorf("failed to save tombstone: %w", err)
+		}
+	}
+
+	completed := time.Now().UTC()
+	tombstone.CompletedAt = &completed
+	if err := tombstones.SaveTombstone(ctx, tombstone); err != nil {
+		return tombstone, fmt.Errorf("failed to save tombstone: %w", err)
+	}
+	return tombstone, nil
+}
+
+// deleteAccountHandler generates an authenticatedRequestHandler erasing
+// everything held about the user, responding with their tombstone
+func deleteAccountHandler(tombstones TombstoneStorer, steps []erasureStep) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to delete account")
+
+		tombstone, err := eraseAccount(req.req.Context(), tombstones, steps, req.userID)
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to delete account, %d of %d steps done: %s",
+				len(tombstone.Steps), len(steps), err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to delete account, try again to finish deleting it")
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, tombstone)
+		LogWithID(req.req.Context(), "deleted account %s", tombstone.UserHash)
+	}
+}
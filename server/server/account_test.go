@@ -0,0 +1,229 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// in memory TombstoneStorer
+type memoryTombstoneStorer struct {
+	tombstones map[string]Tombstone
+}
+
+func (m *memoryTombstoneStorer) FetchTombstone(ctx context.Context, userHash string) (Tombstone, error) {
+	tombstone, ok := m.tombstones[userHash]
+	if !ok {
+		return Tombstone{}, ErrNoTombstone
+	}
+	return tombstone, nil
+}
+
+func (m *memoryTombstoneStorer) SaveTombstone(ctx context.Context, tombstone Tombstone) error {
+	steps := map[string]time.Time{}
+	for name, done := range tombstone.Steps {
+		steps[name] = done
+	}
+	tombstone.Steps = steps
+	m.tombstones[tombstone.UserHash] = tombstone
+	return nil
+}
+
+// cache counting the users it is told to forget
+type countingForgetter struct {
+	forgotten []string
+}
+
+func (c *countingForgetter) ForgetUser(userID string) {
+	c.forgotten = append(c.forgotten, userID)
+}
+
+func TestExportHandler(t *testing.T) {
+	ctx := context.Background()
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "expenses": [{"name": "Rent", "amount": 50000}]}`),
+	}}
+	sink := &memoryAuditSink{}
+	sink.Record(ctx, AuditEntry{UserID: "some user id", Action: ActionSaveUserSave})
+	sink.Record(ctx, AuditEntry{UserID: "someone else", Action: ActionSaveUserSave})
+	manager := &testAccessTokenManager{tokens: map[string][]AccessToken{}}
+	manager.CreateAccessToken(ctx, Identity{UserID: "some user id"}, "cron", ScopeRead)
+	ledgers := makeMemoryLedgerStorer()
+	ledgers.ledgers["some user id"] = []byte(`{"transactions": [{"id": "coffee"}]}`)
+
+	handlers := AppRouteHandlers{
+		UserSaveStorer:     storer,
+		AuditSink:          sink,
+		AccessTokenManager: manager,
+		LedgerStorer:       ledgers,
+	}
+	rr := httptest.NewRecorder()
+	exportHandler(handlers.exportParts())(rr, makeAuthedRequest(t, "GET", "/v1/account/export", ""))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("expected a valid zip: %s", err)
+	}
+
+	files := map[string]string{}
+	names := []string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(content)
+		names = append(names, file.Name)
+	}
+	sort.Strings(names)
+
+	if expect := "accesstokens.json,audit.json,expenses.csv,ledger.json,savings.csv,usersave.json"; strings.Join(names, ",") != expect {
+		t.Errorf("expected files %s, got %v", expect, names)
+	}
+	if !strings.Contains(files["expenses.csv"], "Rent,500.00,NZD,,Weekly") {
+		t.Errorf("expected rent in expenses, got %s", files["expenses.csv"])
+	}
+	if strings.Count(files["audit.json"], `"action"`) != 1 {
+		t.Errorf("expected only the user's audit entry, got %s", files["audit.json"])
+	}
+	if !strings.Contains(files["accesstokens.json"], `"cron"`) {
+		t.Errorf("expected access token, got %s", files["accesstokens.json"])
+	}
+	if !strings.Contains(files["ledger.json"], `"coffee"`) {
+		t.Errorf("expected transaction, got %s", files["ledger.json"])
+	}
+	if files["usersave.json"] != string(storer.saves["some user id"]) {
+		t.Errorf("expected the stored save, got %s", files["usersave.json"])
+	}
+}
+
+func TestAuditExportPages(t *testing.T) {
+	ctx := context.Background()
+	sink := &memoryAuditSink{}
+	// more entries than fit a page, recorded at the same time
+	at := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxAuditLimit+2; i++ {
+		sink.Record(ctx, AuditEntry{ID: fmt.Sprintf("%04d", i), Time: at, UserID: "some user id"})
+	}
+
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+	if err := auditExport(sink).write(ctx, "some user id", archive); err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := reader.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries := []AuditEntry{}
+	if err := json.NewDecoder(file).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != maxAuditLimit+2 || entries[maxAuditLimit].ID != fmt.Sprintf("%04d", maxAuditLimit) {
+		t.Errorf("expected every entry exported once in order, got %d", len(entries))
+	}
+}
+
+func TestDeleteAccountHandler(t *testing.T) {
+	ctx := context.Background()
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"income": 1}`),
+		"someone else": []byte(`{"income": 2}`),
+	}}
+	sink := &memoryAuditSink{eraseErr: errors.New("unavailable")}
+	sink.Record(ctx, AuditEntry{UserID: "some user id", Action: ActionSaveUserSave})
+	sink.Record(ctx, AuditEntry{UserID: "someone else", Action: ActionSaveUserSave})
+	manager := &testAccessTokenManager{tokens: map[string][]AccessToken{}}
+	manager.CreateAccessToken(ctx, Identity{UserID: "some user id"}, "cron", ScopeRead)
+	cache := &countingForgetter{}
+	tombstones := &memoryTombstoneStorer{tombstones: map[string]Tombstone{}}
+	ledgers := makeMemoryLedgerStorer()
+	ledgers.ledgers["some user id"] = []byte(`{"transactions": []}`)
+
+	handlers := AppRouteHandlers{
+		UserSaveStorer:     storer,
+		AuditSink:          sink,
+		AccessTokenManager: manager,
+		TombstoneStorer:    tombstones,
+		LedgerStorer:       ledgers,
+		Caches:             []UserForgetter{cache},
+	}
+	deleteAccount := audited(sink, ActionDeleteAccount, nil, deleteAccountHandler(tombstones, handlers.erasureSteps()))
+	userHash := HashUserID("some user id")
+
+	rr := httptest.NewRecorder()
+	deleteAccount(rr, makeAuthedRequest(t, "DELETE", "/v1/account", ""))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected failed audit erasure to fail deletion, got %d", rr.Code)
+	}
+	partial := tombstones.tombstones[userHash]
+	if partial.CompletedAt != nil || len(partial.Steps) != 4 {
+		t.Errorf("expected incomplete tombstone with 4 steps done, got %+v", partial)
+	}
+	if _, ok := storer.saves["some user id"]; ok {
+		t.Error("expected save to be erased before the failure")
+	}
+	if len(manager.tokens["some user id"]) != 0 {
+		t.Error("expected access tokens to be revoked before the failure")
+	}
+	if _, ok := ledgers.ledgers["some user id"]; ok {
+		t.Error("expected ledger to be erased before the failure")
+	}
+
+	sink.eraseErr = nil
+	rr = httptest.NewRecorder()
+	deleteAccount(rr, makeAuthedRequest(t, "DELETE", "/v1/account", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected resumed deletion to succeed, got %d", rr.Code)
+	}
+	tombstone := Tombstone{}
+	if err := json.NewDecoder(rr.Body).Decode(&tombstone); err != nil {
+		t.Fatal(err)
+	}
+	if tombstone.UserHash != userHash || tombstone.CompletedAt == nil || len(tombstone.Steps) != 5 {
+		t.Errorf("expected completed tombstone, got %+v", tombstone)
+	}
+	if len(cache.forgotten) != 1 {
+		t.Errorf("expected completed steps not to be repeated, caches forgot %v", cache.forgotten)
+	}
+	if len(sink.entries) != 2 || sink.entries[0].UserID != "someone else" ||
+		sink.entries[1].UserID != "some user id" || sink.entries[1].Action != ActionDeleteAccount {
+		t.Errorf("expected only the user's audit entries to be erased, then the deletion audited, got %+v", sink.entries)
+	}
+	if _, ok := storer.saves["someone else"]; !ok {
+		t.Error("expected other users' saves to survive")
+	}
+
+	rr = httptest.NewRecorder()
+	deleteAccount(rr, makeAuthedRequest(t, "DELETE", "/v1/account", ""))
+	if rr.Code != http.StatusOK || len(cache.forgotten) != 2 {
+		t.Errorf("expected deletion to be repeatable, got %d", rr.Code)
+	}
+	if len(sink.entries) != 2 || sink.entries[1].Action != ActionDeleteAccount {
+		t.Errorf("expected the repeated deletion to replace the audit of the first, got %+v", sink.entries)
+	}
+}
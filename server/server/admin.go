@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminSavesPath    = "/admin/v1/saves"
+	adminSavePath     = adminSavesPath + "/"
+	adminMetadataPath = "/admin/v1/metadata/"
+	adminAuditPath    = "/admin/v1/audit/"
+	maxReasonLength   = 500
+	defaultPageSize   = 100
+	maxPageSize       = 1000
+)
+
+// UserSaveInfo describes a stored UserSave without its content
+type UserSaveInfo struct {
+	UserID  string    `json:"userId"`
+	Size    int64     `json:"size"`
+	Updated time.Time `json:"updated"`
+	// Generation changes every time the save is written
+	Generation int64 `json:"generation"`
+	// ContentHash is the base64 encoded MD5 hash of the save's content
+	ContentHash string `json:"contentHash"`
+}
+
+// UserSaveLister may be implemented by a UserSaveStorer able to enumerate
+// the saves it holds
+type UserSaveLister interface {
+	// ListUserSaves returns up to pageSize descriptions of saves following
+	// the page token, and the token of the next page, which is empty once
+	// there are no more saves. An empty page token starts from the beginning.
+	ListUserSaves(ctx context.Context, pageToken string, pageSize int) ([]UserSaveInfo, string, error)
+}
+
+// UserSaveStater may be implemented by a UserSaveStorer able to describe a
+// save without fetching it
+type UserSaveStater interface {
+	// StatUserSave should return ErrNoUserSave if there is no save for the
+	// UserID
+	StatUserSave(ctx context.Context, userID string) (UserSaveInfo, error)
+}
+
+// EachUserSave calls fn with every save described by the lister, page by
+// page, stopping at the first error
+func EachUserSave(ctx context.Context, lister UserSaveLister, fn func(UserSaveInfo) error) error {
+	pageToken := ""
+	for {
+		saves, next, err := lister.ListUserSaves(ctx, pageToken, maxPageSize)
+		if err != nil {
+			return err
+		}
+		for _, save := range saves {
+			if err := fn(save); err != nil {
+				return err
+			}
+		}
+		if len(next) < 1 {
+			return nil
+		}
+		pageToken = next
+	}
+}
+
+type listSavesResponse struct {
+	Saves         []UserSaveInfo `json:"saves"`
+	NextPageToken string         `json:"nextPageToken,omitempty"`
+}
+
+// adminStats are aggregate counts over all saves
+type adminStats struct {
+	Saves          int   `json:"saves"`
+	TotalSize      int64 `json:"totalSize"`
+	UpdatedInDay   int   `json:"updatedInDay"`
+	UpdatedInWeek  int   `json:"updatedInWeek"`
+	UpdatedInMonth int   `json:"updatedInMonth"`
+}
+
+// requireReason returns an authenticatedRequestHandler which rejects requests
+// without a reason query parameter, logging the reason and passing it on to be
+// audited otherwise
+func requireReason(next authenticatedRequestHandler) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		reason := strings.TrimSpace(req.req.URL.Query().Get("reason"))
+		if len(reason) < 1 || len(reason) > maxReasonLength {
+			LogWithID(req.req.Context(), "no valid reason given")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "A reason of up to %d characters must be given", maxReasonLength)
+			return
+		}
+		LogWithID(req.req.Context(), "user %s gave reason %q", req.identity.UserID, reason)
+		req.reason = reason
+		next(w, req)
+	}
+}
+
+// asPathUser returns an authenticatedRequestHandler calling next as if the
+// request were made for the user whose ID is the last element of the path.
+// The request's identity remains that of the user acting.
+func asPathUser(prefix string, next authenticatedRequestHandler) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		userID := strings.TrimPrefix(req.req.URL.Path, prefix)
+		if len(userID) < 1 || strings.Contains(userID, "/") {
+			LogWithID(req.req.Context(), "invalid user id in path")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "No such user")
+			return
+		}
+
+		LogWithID(req.req.Context(), "user %s acting on user %s", req.identity.UserID, userID)
+		next(w, &authenticatedRequest{
+			req:      req.req,
+			userID:   userID,
+			identity: req.identity,
+			roles:    req.roles,
+			reason:   req.reason,
+		})
+	}
+}
+
+// listSavesHandler generates an authenticatedRequestHandler listing a page
+// of saves, from the pageToken and pageSize query parameters
+func listSavesHandler(lister UserSaveLister) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to list usersaves")
+
+		query := req.req.URL.Query()
+		pageSize := defaultPageSize
+		if size := query.Get("pageSize"); len(size) > 0 {
+			parsed, err := strconv.Atoi(size)
+			if err != nil || parsed < 1 || parsed > maxPageSize {
+				LogWithID(req.req.Context(), "invalid page size %q", size)
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Page size must be between 1 and %d", maxPageSize)
+				return
+			}
+			pageSize = parsed
+		}
+
+		saves, next, err := lister.ListUserSaves(req.req.Context(), query.Get("pageToken"), pageSize)
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to list usersaves: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to list usersaves")
+			return
+		}
+		if saves == nil {
+			saves = []UserSaveInfo{}
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, listSavesResponse{
+			Saves:         saves,
+			NextPageToken: next,
+		})
+		LogWithID(req.req.Context(), "sent %d usersave descriptions", len(saves))
+	}
+}
+
+// statSaveHandler generates an authenticatedRequestHandler describing the
+// request user's save
+func statSaveHandler(stater UserSaveStater) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to describe usersave")
+
+		info, err := stater.StatUserSave(req.req.Context(), req.userID)
+		if err != nil {
+			if errors.Is(err, ErrNoUserSave) {
+				LogWithID(req.req.Context(), "no usersave")
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "No usersave for this user")
+				return
+			}
+			LogWithID(req.req.Context(), "!! failed to describe usersave: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to describe usersave")
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, info)
+		LogWithID(req.req.Context(), "sent usersave description")
+	}
+}
+
+// statsHandler generates an authenticatedRequestHandler counting saves
+func statsHandler(lister UserSaveLister) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to count usersaves")
+
+		now := time.Now()
+		stats := adminStats{}
+		err := EachUserSave(req.req.Context(), lister, func(save UserSaveInfo) error {
+			stats.Saves++
+			stats.TotalSize += save.Size
+			age := now.Sub(save.Updated)
+			if age <= 24*time.Hour {
+				stats.UpdatedInDay++
+			}
+			if age <= 7*24*time.Hour {
+				stats.UpdatedInWeek++
+			}
+			if age <= 30*24*time.Hour {
+				stats.UpdatedInMonth++
+			}
+			return nil
+		})
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to list usersaves: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to count usersaves")
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, stats)
+		LogWithID(req.req.Context(), "sent usersave counts")
+	}
+}
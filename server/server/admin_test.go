@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// in memory storer of user saves which can list them
+type memoryUserSaveStorer struct {
+	saves map[string][]byte
+	// saveErr fails closing save writers, as a failed upload does
+	saveErr error
+	// generations counts the times each save is stored
+	generations map[string]int64
+	// beforeVersionedSave is called before a versioned save is stored, to
+	// change the save as another request would
+	beforeVersionedSave func(userID string)
+}
+
+type memoryWriteCloser struct {
+	bytes.Buffer
+	close func([]byte) error
+}
+
+func (w *memoryWriteCloser) Close() error {
+	return w.close(w.Bytes())
+}
+
+func (m *memoryUserSaveStorer) Fetch(userID string) (io.ReadCloser, error) {
+	save, ok := m.saves[userID]
+	if !ok {
+		return nil, ErrNoUserSave
+	}
+	return ioutil.NopCloser(bytes.NewReader(save)), nil
+}
+
+func (m *memoryUserSaveStorer) Save(ctx context.Context, userID string) (io.WriteCloser, error) {
+	return &memoryWriteCloser{close: func(save []byte) error {
+		return m.store(userID, save)
+	}}, nil
+}
+
+func (m *memoryUserSaveStorer) store(userID string, save []byte) error {
+	if m.saveErr != nil {
+		return m.saveErr
+	}
+	if m.generations == nil {
+		m.generations = map[string]int64{}
+	}
+	m.saves[userID] = save
+	m.generations[userID]++
+	return nil
+}
+
+// FetchVersion versions saves one more than the times they've been stored, so
+// saves made by tests are at version 1
+func (m *memoryUserSaveStorer) FetchVersion(ctx context.Context, userID string) (io.ReadCloser, int64, error) {
+	reader, err := m.Fetch(userID)
+	return reader, m.generations[userID] + 1, err
+}
+
+func (m *memoryUserSaveStorer) SaveVersion(ctx context.Context, userID string, version int64) (io.WriteCloser, error) {
+	return &memoryWriteCloser{close: func(save []byte) error {
+		if m.beforeVersionedSave != nil {
+			m.beforeVersionedSave(userID)
+		}
+		if version != m.generations[userID]+1 {
+			return ErrUserSaveChanged
+		}
+		return m.store(userID, save)
+	}}, nil
+}
+
+func (m *memoryUserSaveStorer) Remove(ctx context.Context, userID string) error {
+	if _, ok := m.saves[userID]; !ok {
+		return ErrNoUserSave
+	}
+	delete(m.saves, userID)
+	return nil
+}
+
+func (m *memoryUserSaveStorer) info(userID string) UserSaveInfo {
+	save := m.saves[userID]
+	sum := md5.Sum(save)
+	return UserSaveInfo{
+		UserID:      userID,
+		Size:        int64(len(save)),
+		Updated:     time.Now(),
+		ContentHash: base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+// pages through saves in user ID order, the page token being the last ID sent
+func (m *memoryUserSaveStorer) ListUserSaves(ctx context.Context, pageToken string, pageSize int) ([]UserSaveInfo, string, error) {
+	userIDs := []string{}
+	for userID := range m.saves {
+		if userID > pageToken {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+
+	next := ""
+	if len(userIDs) > pageSize {
+		userIDs = userIDs[:pageSize]
+		next = userIDs[pageSize-1]
+	}
+	saves := []UserSaveInfo{}
+	for _, userID := range userIDs {
+		saves = append(saves, m.info(userID))
+	}
+	return saves, next, nil
+}
+
+func (m *memoryUserSaveStorer) StatUserSave(ctx context.Context, userID string) (UserSaveInfo, error) {
+	if _, ok := m.saves[userID]; !ok {
+		return UserSaveInfo{}, ErrNoUserSave
+	}
+	return m.info(userID), nil
+}
+
+// token checker accepting any token as the user ID of the same name
+type userIsTokenChecker struct{}
+
+func (c userIsTokenChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	return token, true
+}
+
+func TestAdminRoutes(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"user": []byte(`{"cycle": "Fortnightly"}`),
+	}}
+	router := Route(AppRouteHandlers{
+		TokenChecker:   userIsTokenChecker{},
+		UserSaveStorer: storer,
+		RoleResolver:   testRoleResolver{},
+	}, "*")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		expect int
+	}{
+		{"list as user", http.MethodGet, "/admin/v1/saves", "user", "", http.StatusForbidden},
+		{"list as support", http.MethodGet, "/admin/v1/saves", "support", "", http.StatusOK},
+		{"list invalid page size", http.MethodGet, "/admin/v1/saves?pageSize=0", "support", "", http.StatusBadRequest},
+		{"metadata as support", http.MethodGet, "/admin/v1/metadata/user", "support", "", http.StatusOK},
+		{"metadata missing", http.MethodGet, "/admin/v1/metadata/nobody", "support", "", http.StatusNotFound},
+		{"stats as admin", http.MethodGet, "/admin/v1/stats", "admin", "", http.StatusOK},
+		{"fetch as user", http.MethodGet, "/admin/v1/saves/user", "user", "", http.StatusForbidden},
+		{"fetch as support", http.MethodGet, "/admin/v1/saves/user", "support", "", http.StatusOK},
+		{"fetch missing", http.MethodGet, "/admin/v1/saves/nobody", "admin", "", http.StatusNotFound},
+		{"replace as support", http.MethodPut, "/admin/v1/saves/user?reason=fix", "support", `{"income": 5}`, http.StatusForbidden},
+		{"replace without reason", http.MethodPut, "/admin/v1/saves/user", "admin", `{"income": 5}`, http.StatusBadRequest},
+		{"replace invalid", http.MethodPut, "/admin/v1/saves/user?reason=fix", "admin", `nope`, http.StatusBadRequest},
+		{"replace", http.MethodPut, "/admin/v1/saves/user?reason=fix", "admin", `{"income": 5}`, http.StatusOK},
+		{"delete without reason", http.MethodDelete, "/admin/v1/saves/user", "admin", "", http.StatusBadRequest},
+		{"delete", http.MethodDelete, "/admin/v1/saves/user?reason=closed", "admin", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Token", test.token)
+		t.Run(test.name, StatusCodeTest(req, test.expect, router))
+
+		if test.name == "replace" && !bytes.Contains(storer.saves["user"], []byte(`"income":{"amount":"0.05"`)) {
+			t.Errorf("expected save to be replaced, got %s", storer.saves["user"])
+		}
+	}
+	if _, ok := storer.saves["user"]; ok {
+		t.Error("expected save to be deleted")
+	}
+	if _, ok := storer.saves["admin"]; ok {
+		t.Error("expected admin not to act on their own save")
+	}
+}
+
+func TestListSavesHandler(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"a": []byte("1"),
+		"b": []byte("2"),
+		"c": []byte("3"),
+	}}
+
+	userIDs := []string{}
+	pageToken := ""
+	for pages := 0; pages < 5; pages++ {
+		rr := httptest.NewRecorder()
+		listSavesHandler(storer)(rr, makeAuthedRequest(t, "GET", "/admin/v1/saves?pageSize=2&pageToken="+pageToken, ""))
+		page := listSavesResponse{}
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		for _, save := range page.Saves {
+			userIDs = append(userIDs, save.UserID)
+		}
+		if len(page.NextPageToken) < 1 {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	if strings.Join(userIDs, ",") != "a,b,c" {
+		t.Errorf("expected every save to be listed once, got %v", userIDs)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"a": []byte("12"),
+		"b": []byte("345"),
+	}}
+
+	rr := httptest.NewRecorder()
+	statsHandler(storer)(rr, makeAuthedRequest(t, "GET", "/admin/v1/stats", ""))
+	stats := adminStats{}
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Saves != 2 || stats.TotalSize != 5 || stats.UpdatedInDay != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
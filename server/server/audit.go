@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// Audited actions
+const (
+	ActionSaveUserSave      = "usersave.save"
+	ActionRemoveUserSave    = "usersave.remove"
+	ActionAllocateUserSave  = "usersave.allocate"
+	ActionRenameTag         = "usersave.tags.rename"
+	ActionCreateAccessToken = "accesstoken.create"
+	ActionRevokeAccessToken = "accesstoken.revoke"
+)
+
+// AuditEntry records a change made to a user's data
+type AuditEntry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// UserID is the user whose data was changed
+	UserID string `json:"userId"`
+	// Actor is the user who made the change, which differs from UserID
+	// when an admin acts on another user's data
+	Actor string `json:"actor"`
+	// AccessTokenID is set if the actor used a personal access token
+	AccessTokenID string `json:"accessTokenId,omitempty"`
+	Action        string `json:"action"`
+	RequestID     string `json:"requestId"`
+	IP            string `json:"ip"`
+	// BeforeHash and AfterHash are the base64 encoded MD5 hashes of the
+	// user's save before and after the change, empty if there was none
+	BeforeHash string `json:"beforeHash,omitempty"`
+	AfterHash  string `json:"afterHash,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// AuditQuery selects a user's audit entries, newest first. Entries recorded at
+// the same time are listed by ID.
+type AuditQuery struct {
+	UserID string
+	// Before excludes entries at or after the time if set
+	Before time.Time
+	// BeforeID, with Before, includes the entries at the time listed after the
+	// entry with the ID, so a page can start after the last entry of another
+	BeforeID string
+	Limit    int
+}
+
+// Includes returns true if the query's Before and BeforeID include the entry
+func (q AuditQuery) Includes(entry AuditEntry) bool {
+	if q.Before.IsZero() || entry.Time.Before(q.Before) {
+		return true
+	}
+	return len(q.BeforeID) > 0 && entry.Time.Equal(q.Before) && entry.ID > q.BeforeID
+}
+
+// AuditListedBefore returns true if a is listed before b, newest first then
+// by ID
+func AuditListedBefore(a AuditEntry, b AuditEntry) bool {
+	if !a.Time.Equal(b.Time) {
+		return a.Time.After(b.Time)
+	}
+	return a.ID < b.ID
+}
+
+// AuditSink defines methods for recording and querying an append only trail
+// of AuditEntries
+type AuditSink interface {
+	// Record appends the entry
+	Record(ctx context.Context, entry AuditEntry) error
+	// Query returns up to the query's limit of the user's entries, newest first
+	Query(ctx context.Context, query AuditQuery) ([]AuditEntry, error)
+}
+
+// requestID returns the ID Serve gave the request, if any
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// clientIP returns the IP the request came from, preferring the last address
+// forwarded, which is appended by the proxy in front of the server such as
+// Cloud Run's. Earlier addresses are sent by the client, so can be forged.
+func clientIP(req *http.Request) string {
+	if forwarded := req.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+		if last := strings.TrimSpace(addresses[len(addresses)-1]); len(last) > 0 {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// UserSaveHash returns the base64 encoded MD5 hash of the user's save, or an
+// empty string if there is none. The save is only fetched if the storer
+// can't describe it.
+func UserSaveHash(ctx context.Context, storer UserSaveStorer, userID string) (string, error) {
+	if stater, ok := storer.(UserSaveStater); ok {
+		info, err := stater.StatUserSave(ctx, userID)
+		if errors.Is(err, ErrNoUserSave) {
+			return "", nil
+		}
+		return info.ContentHash, err
+	}
+
+	reader, err := storer.Fetch(userID)
+	if err != nil {
+		if errors.Is(err, ErrNoUserSave) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// statusRecorder remembers the status written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// audited returns an authenticatedRequestHandler which records an AuditEntry
+// for the action into the sink if next succeeds. If a storer is given the
+// hashes of the user's save before and after are recorded.
+func audited(sink AuditSink, action string, storer UserSaveStorer, next authenticatedRequestHandler) authenticatedRequestHandler {
+	if sink == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		entry := AuditEntry{
+			ID:            xid.New().String(),
+			UserID:        req.userID,
+			Actor:         req.identity.UserID,
+			AccessTokenID: req.identity.AccessTokenID,
+			Action:        action,
+			RequestID:     requestID(ctx),
+			IP:            clientIP(req.req),
+			Reason:        req.reason,
+		}
+
+		var err error
+		if storer != nil {
+			if entry.BeforeHash, err = UserSaveHash(ctx, storer, req.userID); err != nil {
+				LogWithID(ctx, "!! failed to hash usersave before %s: %s", action, err)
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, req)
+		if recorder.status < 200 || recorder.status > 299 {
+			return
+		}
+
+		if storer != nil {
+			if entry.AfterHash, err = UserSaveHash(ctx, storer, req.userID); err != nil {
+				LogWithID(ctx, "!! failed to hash usersave after %s: %s", action, err)
+			}
+		}
+		entry.Time = time.Now().UTC()
+		if err := sink.Record(ctx, entry); err != nil {
+			LogWithID(ctx, "!! failed to record audit entry for %s: %s", action, err)
+			return
+		}
+		LogWithID(ctx, "audited %s", action)
+	}
+}
+
+// auditHandler generates an authenticatedRequestHandler listing the request
+// user's audit entries, from the before and limit query parameters
+func auditHandler(sink AuditSink) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to query audit entries")
+
+		query := AuditQuery{UserID: req.userID, Limit: defaultAuditLimit}
+		params := req.req.URL.Query()
+		if before := params.Get("before"); len(before) > 0 {
+			parsed, err := time.Parse(time.RFC3339Nano, before)
+			if err != nil {
+				LogWithID(req.req.Context(), "invalid before time %q", before)
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "Before must be an RFC 3339 time")
+				return
+			}
+			query.Before = parsed
+		}
+		if limit := params.Get("limit"); len(limit) > 0 {
+			parsed, err := strconv.Atoi(limit)
+			if err != nil || parsed < 1 || parsed > maxAuditLimit {
+				LogWithID(req.req.Context(), "invalid limit %q", limit)
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Limit must be between 1 and %d", maxAuditLimit)
+				return
+			}
+			query.Limit = parsed
+		}
+
+		entries, err := sink.Query(req.req.Context(), query)
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to query audit entries: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to query audit entries")
+			return
+		}
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, entries)
+		LogWithID(req.req.Context(), "sent %d audit entries", len(entries))
+	}
+}
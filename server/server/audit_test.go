@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// in memory AuditSink
+type memoryAuditSink struct {
+	entries []AuditEntry
+	// eraseErr fails erasures if set
+	eraseErr error
+}
+
+func (s *memoryAuditSink) Record(ctx context.Context, entry AuditEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memoryAuditSink) Query(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if s.entries[i].UserID == query.UserID && query.Includes(s.entries[i]) {
+			entries = append(entries, s.entries[i])
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return AuditListedBefore(entries[i], entries[j])
+	})
+	if len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+	return entries, nil
+}
+
+func (s *memoryAuditSink) EraseAudit(ctx context.Context, userID string) error {
+	if s.eraseErr != nil {
+		return s.eraseErr
+	}
+	entries := []AuditEntry{}
+	for _, entry := range s.entries {
+		if entry.UserID != userID {
+			entries = append(entries, entry)
+		}
+	}
+	s.entries = entries
+	return nil
+}
+
+func TestAuditedRoutes(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{}}
+	sink := &memoryAuditSink{}
+	router := Route(AppRouteHandlers{
+		TokenChecker:   userIsTokenChecker{},
+		UserSaveStorer: storer,
+		RoleResolver:   testRoleResolver{},
+		AuditSink:      sink,
+	}, "*")
+
+	requests := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+	}{
+		{http.MethodPost, "/v1/usersave?reason=spoofed", "user", `{"income": 1}`},
+		{http.MethodPost, "/v1/usersave", "user", `invalid`},
+		{http.MethodPut, "/admin/v1/saves/user?reason=repair", "admin", `{"income": 2}`},
+		{http.MethodDelete, "/v1/usersave", "user", ``},
+		{http.MethodDelete, "/v1/usersave", "user", ``},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Token", r.token)
+		req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.1")
+		router(httptest.NewRecorder(), req)
+	}
+
+	if len(sink.entries) != 3 {
+		t.Fatalf("expected only the 3 successful changes to be audited, got %+v", sink.entries)
+	}
+	save, repair, remove := sink.entries[0], sink.entries[1], sink.entries[2]
+
+	if save.Action != ActionSaveUserSave || save.Actor != "user" || save.BeforeHash != "" || save.AfterHash == "" {
+		t.Errorf("unexpected save entry %+v", save)
+	}
+	if save.Reason != "" {
+		t.Errorf("expected reason only to be audited where one is required, got %q", save.Reason)
+	}
+	if save.IP != "203.0.113.1" {
+		t.Errorf("expected IP forwarded by the proxy, got %s", save.IP)
+	}
+	if repair.UserID != "user" || repair.Actor != "admin" || repair.Reason != "repair" {
+		t.Errorf("expected admin repair of user's save with reason, got %+v", repair)
+	}
+	if repair.BeforeHash != save.AfterHash || repair.AfterHash == save.AfterHash {
+		t.Errorf("expected repair hashes to follow the save, got %+v", repair)
+	}
+	if remove.Action != ActionRemoveUserSave || remove.BeforeHash != repair.AfterHash || remove.AfterHash != "" {
+		t.Errorf("unexpected remove entry %+v", remove)
+	}
+
+	rr := httptest.NewRecorder()
+	auditHandler(sink)(rr, &authenticatedRequest{
+		req:    httptest.NewRequest("GET", "/v1/audit?limit=2", nil),
+		userID: "user",
+	})
+	entries := []AuditEntry{}
+	if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != ActionRemoveUserSave {
+		t.Errorf("expected newest 2 entries, got %+v", entries)
+	}
+
+	invalid := []string{"/v1/audit?limit=0", "/v1/audit?limit=x", "/v1/audit?before=yesterday"}
+	for _, path := range invalid {
+		rr := httptest.NewRecorder()
+		auditHandler(sink)(rr, makeAuthedRequest(t, "GET", path, ""))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", path, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
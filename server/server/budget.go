@@ -0,0 +1,596 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"py-server/usersave"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUserSaveChanged is returned by a VersionedUserSaveStorer when the save
+// changed since it was fetched
+var ErrUserSaveChanged = errors.New("usersave changed since it was fetched")
+
+const (
+	// maxUserSaveAttempts bounds how many times a change is retried when the
+	// save is changed by another request at the same time
+	maxUserSaveAttempts = 3
+
+	defaultPaydays = 6
+	maxPaydays     = 100
+
+	defaultForecastCycles = 12
+	maxForecastCycles     = 520
+)
+
+// formatMediaTypes are the media types of the response formats handlers can
+// negotiate
+var formatMediaTypes = map[string]string{
+	"json":  "application/json",
+	"csv":   "text/csv",
+	"xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"jsonl": "application/jsonl",
+}
+
+// fetchUserSave fetches and decodes the request user's save for computing
+// with, writing an error response and returning nil if it can't
+func fetchUserSave(w http.ResponseWriter, req *authenticatedRequest, storer UserSaveStorer) *usersave.JSONUserSave {
+	reader, err := storer.Fetch(req.userID)
+	if err != nil {
+		if errors.Is(err, ErrNoUserSave) {
+			LogWithID(req.req.Context(), "no usersave")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "No usersave for this user")
+			return nil
+		}
+		LogWithID(req.req.Context(), "!! failed to fetch usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to fetch usersave")
+		return nil
+	}
+	defer reader.Close()
+
+	userSave, err := usersave.DecodeUserSave(reader)
+	if err != nil {
+		LogWithID(req.req.Context(), "!! failed to decode stored usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to decode usersave")
+		return nil
+	}
+	return userSave
+}
+
+// VersionedUserSaveStorer may be implemented by a UserSaveStorer able to store
+// a save only if it hasn't changed since it was fetched, so concurrent changes
+// aren't lost
+type VersionedUserSaveStorer interface {
+	// FetchVersion returns a reader for the user's save and its version, and
+	// should return ErrNoUserSave if there is none
+	FetchVersion(ctx context.Context, userID string) (io.ReadCloser, int64, error)
+	// SaveVersion returns a writer for the user's save, storing it when closed
+	// if the stored save is still at the version. Close should return
+	// ErrUserSaveChanged if it isn't.
+	SaveVersion(ctx context.Context, userID string, version int64) (io.WriteCloser, error)
+}
+
+// fetchUserSaveVersion is fetchUserSave, also returning the save's version if
+// the storer is a VersionedUserSaveStorer, otherwise 0
+func fetchUserSaveVersion(w http.ResponseWriter, req *authenticatedRequest, storer UserSaveStorer) (*usersave.JSONUserSave, int64) {
+	versioned, ok := storer.(VersionedUserSaveStorer)
+	if !ok {
+		return fetchUserSave(w, req, storer), 0
+	}
+
+	reader, version, err := versioned.FetchVersion(req.req.Context(), req.userID)
+	if err != nil {
+		if errors.Is(err, ErrNoUserSave) {
+			LogWithID(req.req.Context(), "no usersave")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "No usersave for this user")
+			return nil, 0
+		}
+		LogWithID(req.req.Context(), "!! failed to fetch usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to fetch usersave")
+		return nil, 0
+	}
+	defer reader.Close()
+
+	userSave, err := usersave.DecodeUserSave(reader)
+	if err != nil {
+		LogWithID(req.req.Context(), "!! failed to decode stored usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to decode usersave")
+		return nil, 0
+	}
+	return userSave, version
+}
+
+// writeUserSave encodes the save into the storer, on condition the stored save
+// is still at the version unless it is 0
+func writeUserSave(ctx context.Context, storer UserSaveStorer, userID string, userSave *usersave.JSONUserSave, version int64) error {
+	var writer io.WriteCloser
+	var err error
+	if versioned, ok := storer.(VersionedUserSaveStorer); ok && version > 0 {
+		writer, err = versioned.SaveVersion(ctx, userID, version)
+	} else {
+		writer, err = storer.Save(ctx, userID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := usersave.EncodeUserSave(userSave, writer); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// storeUserSave encodes the save into the storer, replacing whatever is
+// stored, writing an error response and returning false if it can't
+func storeUserSave(w http.ResponseWriter, req *authenticatedRequest, storer UserSaveStorer, userSave *usersave.JSONUserSave) bool {
+	if err := userSave.Validate(); err != nil {
+		LogWithID(req.req.Context(), "changed usersave is invalid: %s", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "Changed usersave would be invalid: %s", err)
+		return false
+	}
+
+	if err := writeUserSave(req.req.Context(), storer, req.userID, userSave, 0); err != nil {
+		LogWithID(req.req.Context(), "!! failed to store usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to store usersave")
+		return false
+	}
+	return true
+}
+
+// updateUserSave fetches the user's save, makes the change and stores it,
+// trying again if another request changes the save in the meantime. change
+// writes an error response and returns false if it can't make the change, and
+// so does updateUserSave if anything else fails.
+func updateUserSave(w http.ResponseWriter, req *authenticatedRequest, storer UserSaveStorer, change func(*usersave.JSONUserSave) bool) (*usersave.JSONUserSave, bool) {
+	ctx := req.req.Context()
+	for attempt := 1; ; attempt++ {
+		userSave, version := fetchUserSaveVersion(w, req, storer)
+		if userSave == nil || !change(userSave) {
+			return nil, false
+		}
+		if err := userSave.Validate(); err != nil {
+			LogWithID(ctx, "changed usersave is invalid: %s", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, "Changed usersave would be invalid: %s", err)
+			return nil, false
+		}
+
+		err := writeUserSave(ctx, storer, req.userID, userSave, version)
+		if err == nil {
+			return userSave, true
+		}
+		if !errors.Is(err, ErrUserSaveChanged) {
+			LogWithID(ctx, "!! failed to store usersave: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to store usersave")
+			return nil, false
+		}
+		if attempt >= maxUserSaveAttempts {
+			LogWithID(ctx, "usersave kept changing, giving up after %d attempts", attempt)
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "Usersave is being changed by another request, try again")
+			return nil, false
+		}
+		LogWithID(ctx, "usersave changed, trying again")
+	}
+}
+
+// queryCycle parses the cycle query parameter, empty if not given, writing an
+// error response and returning false if it is invalid
+func queryCycle(w http.ResponseWriter, req *authenticatedRequest) (usersave.Cycle, bool) {
+	name := req.req.URL.Query().Get("cycle")
+	if len(name) < 1 {
+		return "", true
+	}
+	cycle, err := usersave.ParseCycle(name)
+	if err != nil {
+		LogWithID(req.req.Context(), "invalid cycle %q", name)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown cycle")
+		return "", false
+	}
+	return cycle, true
+}
+
+// queryCount parses the named query parameter as a count between 1 and max,
+// fallback if not given, writing an error response and returning false if it
+// is invalid
+func queryCount(w http.ResponseWriter, req *authenticatedRequest, name string, fallback int, max int) (int, bool) {
+	value := req.req.URL.Query().Get(name)
+	if len(value) < 1 {
+		return fallback, true
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > max {
+		LogWithID(req.req.Context(), "invalid %s %q", name, value)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Query parameter %s must be between 1 and %d", name, max)
+		return 0, false
+	}
+	return count, true
+}
+
+// acceptQuality returns the quality the Accept header gives the media type,
+// from its most specific matching range, and false if no range matches
+func acceptQuality(accept string, mediaType string) (float64, bool) {
+	best, quality := -1, 0.0
+	for _, accepted := range strings.Split(accept, ",") {
+		parts := strings.Split(accepted, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(parts[0]))
+		specificity := -1
+		switch {
+		case mediaRange == mediaType:
+			specificity = 2
+		case mediaRange == "*/*":
+			specificity = 0
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+			specificity = 1
+		}
+		if specificity <= best {
+			continue
+		}
+		best, quality = specificity, 1
+		for _, param := range parts[1:] {
+			name, value := param, ""
+			if equals := strings.Index(param, "="); equals >= 0 {
+				name, value = param[:equals], param[equals+1:]
+			}
+			if strings.TrimSpace(name) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+	}
+	return quality, best >= 0
+}
+
+// negotiateFormat picks the response format from the format query parameter,
+// or else the format the Accept header gives the highest quality, the first
+// of the formats listed breaking ties, or else the first format. It writes an
+// error response and returns false if the format query parameter isn't one of
+// the formats.
+func negotiateFormat(w http.ResponseWriter, req *authenticatedRequest, formats ...string) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+	if format := req.req.URL.Query().Get("format"); len(format) > 0 {
+		for _, known := range formats {
+			if format == known {
+				return format, true
+			}
+		}
+		LogWithID(req.req.Context(), "unknown format %q", format)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Format must be one of %s", strings.Join(formats, ", "))
+		return "", false
+	}
+
+	accept := strings.Join(req.req.Header.Values("Accept"), ",")
+	picked, pickedQuality := formats[0], 0.0
+	for _, format := range formats {
+		quality, matched := acceptQuality(accept, formatMediaTypes[format])
+		if matched && quality > pickedQuality {
+			picked, pickedQuality = format, quality
+		}
+	}
+	return picked, true
+}
+
+// writeFile responds with the file written by write, as an attachment of the
+// format's media type
+func writeFile(w http.ResponseWriter, req *authenticatedRequest, format string, name string, write func(io.Writer) error) bool {
+	buffer := &bytes.Buffer{}
+	if err := write(buffer); err != nil {
+		LogWithID(req.req.Context(), "!! failed to write %s: %s", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to write %s", format)
+		return false
+	}
+	w.Header().Set("Content-Type", formatMediaTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.WriteHeader(http.StatusOK)
+	if _, err := buffer.WriteTo(w); err != nil {
+		LogWithID(req.req.Context(), "!! failed to send %s: %s", name, err)
+		return false
+	}
+	return true
+}
+
+// writeComputeError responds to a usersave which can't be computed with
+func writeComputeError(w http.ResponseWriter, req *authenticatedRequest, what string, err error) {
+	LogWithID(req.req.Context(), "can't compute %s: %s", what, err)
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	fmt.Fprintf(w, "Can't compute %s of usersave: %s", what, err)
+}
+
+// summaryHandler generates an authenticatedRequestHandler summarising the
+// user's budget, per the cycle query parameter or the save's cycle
+func summaryHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to summarise usersave")
+
+		cycle, ok := queryCycle(w, req)
+		if !ok {
+			return
+		}
+		userSave := fetchUserSave(w, req, storer)
+		if userSave == nil {
+			return
+		}
+
+		summary, err := usersave.Summarize(userSave, cycle)
+		if err != nil {
+			writeComputeError(w, req, "summary", err)
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, summary)
+		LogWithID(req.req.Context(), "sent usersave summary")
+	}
+}
+
+// projectionsHandler generates an authenticatedRequestHandler forecasting each
+// of the user's savings goals
+func projectionsHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to project savings")
+
+		userSave := fetchUserSave(w, req, storer)
+		if userSave == nil {
+			return
+		}
+
+		projections, err := usersave.ProjectSavings(userSave, time.Now().UTC())
+		if err != nil {
+			writeComputeError(w, req, "projections", err)
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, projections)
+		LogWithID(req.req.Context(), "sent %d savings projections", len(projections))
+	}
+}
+
+// parseStrategy parses the named allocation strategy, empty if none is named,
+// writing an error response and returning false if it is unknown
+func parseStrategy(w http.ResponseWriter, req *authenticatedRequest, strategyName string) (usersave.AllocationStrategy, bool) {
+	strategy := usersave.AllocationStrategy(strategyName)
+	if len(strategy) > 0 && !strategy.Valid() {
+		LogWithID(req.req.Context(), "invalid allocation strategy %q", strategy)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown allocation strategy")
+		return "", false
+	}
+	return strategy, true
+}
+
+// allocate splits the save's SavingsAmount using the strategy, or the save's
+// strategy if none is given, writing an error response and returning false if
+// it can't
+func allocate(w http.ResponseWriter, req *authenticatedRequest, userSave *usersave.JSONUserSave, strategy usersave.AllocationStrategy) (usersave.Allocation, bool) {
+	if len(strategy) < 1 {
+		strategy = userSave.AllocationStrategy
+	}
+	if len(strategy) < 1 {
+		LogWithID(req.req.Context(), "no allocation strategy given or saved")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "No allocation strategy given, and none saved")
+		return usersave.Allocation{}, false
+	}
+
+	allocation, err := usersave.Allocate(userSave, strategy, time.Now().UTC())
+	if err != nil {
+		writeComputeError(w, req, "allocation", err)
+		return usersave.Allocation{}, false
+	}
+	return allocation, true
+}
+
+// previewAllocationHandler generates an authenticatedRequestHandler splitting
+// the user's SavingsAmount between their goals, per the strategy query
+// parameter or the save's strategy, without changing anything
+func previewAllocationHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to preview allocation")
+
+		strategy, ok := parseStrategy(w, req, req.req.URL.Query().Get("strategy"))
+		if !ok {
+			return
+		}
+		userSave := fetchUserSave(w, req, storer)
+		if userSave == nil {
+			return
+		}
+		allocation, ok := allocate(w, req, userSave, strategy)
+		if !ok {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, allocation)
+		LogWithID(req.req.Context(), "sent %s allocation", allocation.Strategy)
+	}
+}
+
+type applyAllocationRequest struct {
+	Strategy string `json:"strategy"`
+}
+
+// applyAllocationHandler generates an authenticatedRequestHandler splitting
+// the user's SavingsAmount between their goals, saving the strategy and each
+// goal's contribution
+func applyAllocationHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to apply allocation")
+
+		body := applyAllocationRequest{}
+		if err := json.NewDecoder(req.req.Body).Decode(&body); err != nil {
+			LogWithID(req.req.Context(), "failed to decode allocation request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Failed to decode allocation request")
+			return
+		}
+
+		strategy, ok := parseStrategy(w, req, body.Strategy)
+		if !ok {
+			return
+		}
+		// the allocation is made again if the save changes before it's stored
+		allocation := usersave.Allocation{}
+		_, ok = updateUserSave(w, req, storer, func(userSave *usersave.JSONUserSave) bool {
+			allocation, ok = allocate(w, req, userSave, strategy)
+			if ok {
+				usersave.ApplyAllocation(userSave, allocation)
+			}
+			return ok
+		})
+		if !ok {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, allocation)
+		LogWithID(req.req.Context(), "applied %s allocation", allocation.Strategy)
+	}
+}
+
+// paydaysHandler generates an authenticatedRequestHandler listing the user's
+// next paydays, as many as the count query parameter asks
+func paydaysHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to list paydays")
+
+		count, ok := queryCount(w, req, "count", defaultPaydays, maxPaydays)
+		if !ok {
+			return
+		}
+		userSave := fetchUserSave(w, req, storer)
+		if userSave == nil {
+			return
+		}
+
+		paydays, err := usersave.Paydays(userSave, time.Now(), count)
+		if err != nil {
+			writeComputeError(w, req, "paydays", err)
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, paydays)
+		LogWithID(req.req.Context(), "sent %d paydays", len(paydays))
+	}
+}
+
+// forecastHandler generates an authenticatedRequestHandler simulating the
+// user's cash flow over as many cycles as the cycles query parameter asks, as
+// JSON or CSV
+func forecastHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to forecast usersave")
+
+		cycles, ok := queryCount(w, req, "cycles", defaultForecastCycles, maxForecastCycles)
+		if !ok {
+			return
+		}
+		format, ok := negotiateFormat(w, req, "json", "csv")
+		if !ok {
+			return
+		}
+		userSave := fetchUserSave(w, req, storer)
+		if userSave == nil {
+			return
+		}
+
+		forecast, err := usersave.ForecastCashFlow(userSave, time.Now(), cycles)
+		if err != nil {
+			writeComputeError(w, req, "forecast", err)
+			return
+		}
+
+		if format == "csv" {
+			if !writeFile(w, req, format, "forecast.csv", func(file io.Writer) error {
+				return usersave.WriteForecastCSV(forecast, file)
+			}) {
+				return
+			}
+		} else {
+			writeJSON(req.req.Context(), w, http.StatusOK, forecast)
+		}
+		LogWithID(req.req.Context(), "sent %d cycle forecast as %s", cycles, format)
+	}
+}
+
+type simulateRequest struct {
+	// Save is a hypothetical save to simulate instead of the current one
+	Save        json.RawMessage       `json:"save"`
+	Adjustments []usersave.Adjustment `json:"adjustments"`
+	Cycles      int                   `json:"cycles"`
+}
+
+// simulateHandler generates an authenticatedRequestHandler comparing the
+// outcome of the user's save to a hypothetical one, the user's save with
+// adjustments or a whole other save, without storing anything
+func simulateHandler(storer UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to simulate usersave")
+
+		body := simulateRequest{Cycles: defaultForecastCycles}
+		if err := json.NewDecoder(req.req.Body).Decode(&body); err != nil {
+			LogWithID(req.req.Context(), "failed to decode simulate request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Failed to decode simulate request")
+			return
+		}
+		if body.Cycles < 1 || body.Cycles > maxForecastCycles {
+			LogWithID(req.req.Context(), "invalid cycles %d", body.Cycles)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Cycles must be between 1 and %d", maxForecastCycles)
+			return
+		}
+
+		current := fetchUserSave(w, req, storer)
+		if current == nil {
+			return
+		}
+		hypothetical := current
+		if len(body.Save) > 0 && string(body.Save) != "null" {
+			var err error
+			if hypothetical, err = usersave.DecodeUserSave(bytes.NewReader(body.Save)); err != nil {
+				LogWithID(req.req.Context(), "failed to decode hypothetical usersave: %s", err)
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Failed to decode hypothetical usersave: %s", err)
+				return
+			}
+		}
+		simulated, err := usersave.Adjust(hypothetical, body.Adjustments)
+		if err == nil {
+			err = simulated.Validate()
+		}
+		if err != nil {
+			LogWithID(req.req.Context(), "invalid simulated usersave: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid simulated usersave: %s", err)
+			return
+		}
+
+		simulation, err := usersave.Simulate(current, simulated, time.Now(), body.Cycles)
+		if err != nil {
+			writeComputeError(w, req, "simulation", err)
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, simulation)
+		LogWithID(req.req.Context(), "sent %d cycle simulation", body.Cycles)
+	}
+}
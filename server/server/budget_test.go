@@ -0,0 +1,288 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"py-server/usersave"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryHandler(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Monthly", "income": 300000, "savingsAmount": 100000, "expenses": [{"amount": 50000}]}`),
+	}}
+
+	rr := httptest.NewRecorder()
+	summaryHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/summary?cycle=yearly", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	summary := usersave.Summary{}
+	if err := json.NewDecoder(rr.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Cycle != usersave.CycleYearly || summary.Remaining.Minor != 1800000 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	tests := []struct {
+		save   string
+		path   string
+		expect int
+	}{
+		{`{"income": 1}`, "/v1/usersave/summary", http.StatusUnprocessableEntity},
+		{`{"cycle": "Weekly"}`, "/v1/usersave/summary?cycle=daily", http.StatusBadRequest},
+		{`{"schemaVersion": 999}`, "/v1/usersave/summary", http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		storer.saves["some user id"] = []byte(test.save)
+		rr := httptest.NewRecorder()
+		summaryHandler(storer)(rr, makeAuthedRequest(t, "GET", test.path, ""))
+		if rr.Code != test.expect {
+			t.Errorf("%s %s: expected status code %d, got %d", test.save, test.path, test.expect, rr.Code)
+		}
+	}
+
+	delete(storer.saves, "some user id")
+	rr = httptest.NewRecorder()
+	summaryHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/summary", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d without a save, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestProjectionsHandler(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "savings": [{"name": "Car", "goal": 100000, "amount": 10000, "deadline": 1609459200}]}`),
+	}}
+
+	rr := httptest.NewRecorder()
+	projectionsHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/projections", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	projections := []usersave.Projection{}
+	if err := json.NewDecoder(rr.Body).Decode(&projections); err != nil {
+		t.Fatal(err)
+	}
+	if len(projections) != 1 || projections[0].Name != "Car" || projections[0].Status != usersave.GoalAtRisk ||
+		projections[0].Deadline.String() != "2021-01-01" {
+		t.Errorf("expected car goal to be at risk of its stored deadline, got %+v", projections)
+	}
+}
+
+func TestAllocationHandlers(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "savingsAmount": 100, "savings": [{"name": "A", "goal": 1000}, {"name": "B", "goal": 1000}]}`),
+	}}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		expect int
+	}{
+		{"GET", "/v1/usersave/allocation", "", http.StatusBadRequest},
+		{"GET", "/v1/usersave/allocation?strategy=random", "", http.StatusBadRequest},
+		{"GET", "/v1/usersave/allocation?strategy=equal", "", http.StatusOK},
+		{"POST", "/v1/usersave/allocation", `nope`, http.StatusBadRequest},
+		{"POST", "/v1/usersave/allocation", `{"strategy": "random"}`, http.StatusBadRequest},
+		{"POST", "/v1/usersave/allocation", `{"strategy": "priority"}`, http.StatusOK},
+		// the saved strategy is used when none is given
+		{"GET", "/v1/usersave/allocation", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		req := makeAuthedRequest(t, test.method, test.path, test.body)
+		if test.method == "GET" {
+			previewAllocationHandler(storer)(rr, req)
+		} else {
+			applyAllocationHandler(storer)(rr, req)
+		}
+		if rr.Code != test.expect {
+			t.Errorf("%s %s %s: expected status code %d, got %d", test.method, test.path, test.body, test.expect, rr.Code)
+		}
+	}
+
+	userSave, err := usersave.DecodeUserSave(strings.NewReader(string(storer.saves["some user id"])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSave.AllocationStrategy != usersave.StrategyPriority || userSave.Savings[0].Amount.Minor != 100 || userSave.Savings[1].Amount.Minor != 0 {
+		t.Errorf("expected priority allocation to be saved, got %+v", userSave)
+	}
+}
+
+func TestApplyAllocationKeepsConcurrentChanges(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"savingsAmount": 100, "savings": [{"name": "A", "goal": 1000}, {"name": "B", "goal": 1000}]}`),
+	}}
+	changes := 0
+	storer.beforeVersionedSave = func(userID string) {
+		if changes > 0 {
+			changes--
+			storer.store(userID, []byte(`{"savingsAmount": 300, "savings": [{"name": "A", "goal": 1000}, {"name": "B", "goal": 1000}]}`))
+		}
+	}
+
+	// the allocation is made again from the save changed by another request
+	changes = 1
+	rr := httptest.NewRecorder()
+	applyAllocationHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/allocation", `{"strategy": "equal"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	userSave, err := usersave.DecodeUserSave(strings.NewReader(string(storer.saves["some user id"])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSave.SavingsAmount.Minor != 300 || userSave.Savings[0].Amount.Minor != 150 || userSave.Savings[1].Amount.Minor != 150 {
+		t.Errorf("expected allocation of the changed save, got %+v", userSave)
+	}
+
+	changes = maxUserSaveAttempts
+	rr = httptest.NewRecorder()
+	applyAllocationHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/allocation", `{"strategy": "equal"}`))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected a save which keeps changing to conflict, got %d", rr.Code)
+	}
+}
+
+func TestPaydaysHandler(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "paySchedule": {"anchor": "2021-06-01"}}`),
+	}}
+
+	rr := httptest.NewRecorder()
+	paydaysHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/paydays?count=3", ""))
+	paydays := []usersave.Payday{}
+	if err := json.NewDecoder(rr.Body).Decode(&paydays); err != nil {
+		t.Fatal(err)
+	}
+	if len(paydays) != 3 || paydays[0].Date.In(time.UTC).Weekday() != time.Tuesday {
+		t.Errorf("expected 3 Tuesday paydays, got %+v", paydays)
+	}
+
+	tests := map[string]int{
+		"/v1/usersave/paydays?count=0":   http.StatusBadRequest,
+		"/v1/usersave/paydays?count=101": http.StatusBadRequest,
+	}
+	for path, expect := range tests {
+		rr := httptest.NewRecorder()
+		paydaysHandler(storer)(rr, makeAuthedRequest(t, "GET", path, ""))
+		if rr.Code != expect {
+			t.Errorf("%s: expected status code %d, got %d", path, expect, rr.Code)
+		}
+	}
+
+	storer.saves["some user id"] = []byte(`{"cycle": "Weekly"}`)
+	rr = httptest.NewRecorder()
+	paydaysHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/paydays", ""))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d without a pay schedule, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestForecastHandler(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "income": 1000, "savings": [{"name": "Car", "goal": 500, "amount": 100}]}`),
+	}}
+
+	rr := httptest.NewRecorder()
+	forecastHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/forecast?cycles=5", ""))
+	forecast := usersave.Forecast{}
+	if err := json.NewDecoder(rr.Body).Decode(&forecast); err != nil {
+		t.Fatal(err)
+	}
+	if len(forecast.Cycles) != 5 || len(forecast.Completions) != 1 || forecast.Completions[0].Cycle != 5 {
+		t.Errorf("unexpected forecast %+v", forecast)
+	}
+
+	for _, format := range []struct{ path, accept string }{
+		{"/v1/usersave/forecast?format=csv", ""},
+		{"/v1/usersave/forecast", "application/xml;q=0.9, text/csv"},
+	} {
+		rr := httptest.NewRecorder()
+		req := makeAuthedRequest(t, "GET", format.path, "")
+		req.req.Header.Set("Accept", format.accept)
+		forecastHandler(storer)(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" {
+			t.Errorf("%s %s: expected CSV, got %d %s", format.path, format.accept, rr.Code, rr.Header().Get("Content-Type"))
+		}
+		if rows := strings.Count(rr.Body.String(), "\n"); rows != defaultForecastCycles+1 {
+			t.Errorf("expected a header and %d rows, got %d lines", defaultForecastCycles, rows)
+		}
+	}
+
+	tests := map[string]int{
+		"/v1/usersave/forecast?cycles=0":    http.StatusBadRequest,
+		"/v1/usersave/forecast?cycles=ever": http.StatusBadRequest,
+		"/v1/usersave/forecast?format=xml":  http.StatusBadRequest,
+		"/v1/usersave/forecast?cycles=520":  http.StatusOK,
+		"/v1/usersave/forecast?format=json": http.StatusOK,
+	}
+	for path, expect := range tests {
+		rr := httptest.NewRecorder()
+		forecastHandler(storer)(rr, makeAuthedRequest(t, "GET", path, ""))
+		if rr.Code != expect {
+			t.Errorf("%s: expected status code %d, got %d", path, expect, rr.Code)
+		}
+	}
+
+	storer.saves["some user id"] = []byte(`{"income": 1000}`)
+	rr = httptest.NewRecorder()
+	forecastHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave/forecast", ""))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d without a cycle, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestSimulateHandler(t *testing.T) {
+	save := `{"cycle": "Weekly", "income": 1000, "savingsAmount": 100, "savings": [{"name": "Car", "goal": 400, "amount": 100}]}`
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{"some user id": []byte(save)}}
+
+	body := `{"cycles": 4, "adjustments": [{"target": "savings/Car/amount", "percent": "100"}]}`
+	rr := httptest.NewRecorder()
+	simulateHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/simulate", body))
+	simulation := usersave.Simulation{}
+	if err := json.NewDecoder(rr.Body).Decode(&simulation); err != nil {
+		t.Fatal(err)
+	}
+	goals := simulation.Difference.Goals
+	if len(simulation.Simulated.Forecast.Cycles) != 4 || len(goals) != 1 || *goals[0].CurrentCycle != 4 || *goals[0].SimulatedCycle != 2 {
+		t.Errorf("unexpected simulation %+v", simulation)
+	}
+	if string(storer.saves["some user id"]) != save {
+		t.Error("expected simulating not to store anything")
+	}
+
+	rr = httptest.NewRecorder()
+	simulateHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/simulate", `{"save": {"cycle": "Weekly", "income": 2000}}`))
+	if err := json.NewDecoder(rr.Body).Decode(&simulation); err != nil {
+		t.Fatal(err)
+	}
+	if simulation.Difference.Income.Minor != 1000 || len(simulation.Simulated.Forecast.Cycles) != defaultForecastCycles {
+		t.Errorf("expected hypothetical save to be simulated, got %+v", simulation.Difference)
+	}
+
+	tests := map[string]int{
+		`nonsense`:                             http.StatusBadRequest,
+		`{"cycles": 521}`:                      http.StatusBadRequest,
+		`{"save": {"schemaVersion": 999}}`:     http.StatusBadRequest,
+		`{"save": {"cycle": "Daily"}}`:         http.StatusBadRequest,
+		`{"adjustments": [{"target": "tax"}]}`: http.StatusBadRequest,
+		`{"save": {"income": 1}}`:              http.StatusUnprocessableEntity,
+		`{"save": {"cycle": "Monthly"}}`:       http.StatusOK,
+		`{}`:                                   http.StatusOK,
+	}
+	for body, expect := range tests {
+		rr := httptest.NewRecorder()
+		simulateHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/simulate", body))
+		if rr.Code != expect {
+			t.Errorf("%s: expected status code %d, got %d", body, expect, rr.Code)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"py-server/usersave"
+	"time"
 )
 
 var ErrNoUserSave = errors.New("no such user save")
@@ -17,6 +21,47 @@ type TokenChecker interface {
 	TokenIsValid(ctx context.Context, token string) (string, bool)
 }
 
+// Identity describes the user a token was issued to
+type Identity struct {
+	UserID string
+	// ReadOnly is set for tokens which may only be used to read data
+	ReadOnly bool
+	// AccessTokenID is set if the token is a personal access token
+	AccessTokenID string
+	// Expires is when the token stops being valid, zero if unknown
+	Expires time.Time
+	// Email is the user's email address, if known
+	Email         string
+	EmailVerified bool
+	// HostedDomain is the user's Google Workspace domain, if any
+	HostedDomain string
+	// Claims are any other claims carried by the token
+	Claims map[string]interface{}
+}
+
+// IdentityChecker may be implemented by a TokenChecker which knows more about
+// a token than just the associated ID
+type IdentityChecker interface {
+	TokenIdentity(ctx context.Context, token string) (Identity, bool)
+}
+
+// CheckTokenIdentity validates the token with the checker, returning the
+// full Identity if the checker provides one.
+func CheckTokenIdentity(ctx context.Context, tokenChecker TokenChecker, token string) (Identity, bool) {
+	if identityChecker, ok := tokenChecker.(IdentityChecker); ok {
+		return identityChecker.TokenIdentity(ctx, token)
+	}
+	userID, ok := tokenChecker.TokenIsValid(ctx, token)
+	return Identity{UserID: userID}, ok
+}
+
+// Authorizer decides whether an authenticated Identity may use the server
+type Authorizer interface {
+	// Authorize returns an error giving the reason the identity may not use
+	// the server, or nil if it may
+	Authorize(ctx context.Context, identity Identity) error
+}
+
 // UserSaveStorer defines methods for fetching, deleting and saving
 // UserSave data
 type UserSaveStorer interface {
@@ -31,8 +76,13 @@ type UserSaveStorer interface {
 
 // authenticatedRequest wraps an HTTP request with a UserID
 type authenticatedRequest struct {
-	req    *http.Request
-	userID string
+	req      *http.Request
+	userID   string
+	identity Identity
+	// roles are only resolved for routes requiring them
+	roles []Role
+	// reason is only given for routes requiring one
+	reason string
 }
 
 type authenticatedRequestHandler = func(w http.ResponseWriter, req *authenticatedRequest)
@@ -51,7 +101,8 @@ func authenticateRequest(tokenChecker TokenChecker, next authenticatedRequestHan
 			return
 		}
 
-		userID, ok := tokenChecker.TokenIsValid(req.Context(), token)
+		identity, ok := CheckTokenIdentity(req.Context(), tokenChecker, token)
+		userID := identity.UserID
 		if !ok || len(userID) < 1 {
 			w.WriteHeader(http.StatusUnauthorized)
 			fmt.Fprintf(w, "token invalid")
@@ -63,21 +114,59 @@ func authenticateRequest(tokenChecker TokenChecker, next authenticatedRequestHan
 			LogWithID(req.Context(), "!! token validator returned ok, but user id is blank")
 		}
 
+		if identity.ReadOnly && req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "token is read only")
+			LogWithID(req.Context(), "read only token used for %s", req.Method)
+			return
+		}
+
 		LogWithID(req.Context(), "validated token")
 		// valid token
 		next(w, &authenticatedRequest{
-			userID: userID,
-			req:    req,
+			userID:   userID,
+			identity: identity,
+			req:      req,
 		})
 	}
 }
 
+// authorizeRequest returns an authenticatedRequestHandler which checks the
+// request's identity against the Authorizer, calling next if it is allowed.
+// All identities are allowed without an Authorizer.
+func authorizeRequest(authorizer Authorizer, next authenticatedRequestHandler) authenticatedRequestHandler {
+	if authorizer == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		if err := authorizer.Authorize(req.req.Context(), req.identity); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "access denied: %s", err)
+			LogWithID(req.req.Context(), "access denied: %s", err)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// userSaveFiles write a save in each format fetchHandler can send besides JSON
+var userSaveFiles = map[string]func(*usersave.JSONUserSave, io.Writer) error{
+	"csv":   usersave.WriteBudgetCSV,
+	"xlsx":  usersave.WriteXLSX,
+	"jsonl": usersave.WriteJSONLines,
+}
+
 // fetchHandler generates an AuthenticatedRequestHandler for fetching from the
-// UserSaveStorer
+// UserSaveStorer, as JSON or in the format negotiated
 func fetchHandler(userSaveStorer UserSaveStorer) authenticatedRequestHandler {
 	return func(w http.ResponseWriter, req *authenticatedRequest) {
 		LogWithID(req.req.Context(), "trying to fetch usersave")
 
+		format, ok := negotiateFormat(w, req, "json", "csv", "xlsx", "jsonl")
+		if !ok {
+			return
+		}
+
 		reader, err := userSaveStorer.Fetch(req.userID)
 		if err != nil {
 			if errors.Is(err, ErrNoUserSave) {
@@ -98,19 +187,76 @@ func fetchHandler(userSaveStorer UserSaveStorer) authenticatedRequestHandler {
 			}
 		}()
 
+		stored, err := ioutil.ReadAll(reader)
+		if err != nil {
+			LogWithID(req.req.Context(), "!! failed to read usersave: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch usersave")
+			return
+		}
+
+		userSave, err := usersave.DecodeUserSave(bytes.NewReader(stored))
+		if write, ok := userSaveFiles[format]; ok {
+			if err != nil {
+				LogWithID(req.req.Context(), "!! failed to decode stored usersave: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, "Failed to decode usersave")
+				return
+			}
+			if writeFile(w, req, format, "usersave."+format, func(w io.Writer) error {
+				return write(userSave, w)
+			}) {
+				LogWithID(req.req.Context(), "sent usersave as %s", format)
+			}
+			return
+		}
+
+		// saves are sent upgraded to the current schema, or as stored if they
+		// can't be, so they can still be inspected and repaired
+		body := stored
+		if err != nil {
+			LogWithID(req.req.Context(), "sending usersave as stored, failed to upgrade it: %s", err)
+		} else {
+			upgraded := bytes.Buffer{}
+			if err := usersave.EncodeUserSave(userSave, &upgraded); err != nil {
+				LogWithID(req.req.Context(), "!! failed to encode upgraded usersave: %s", err)
+			} else {
+				body = upgraded.Bytes()
+			}
+		}
+
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		_, err = io.Copy(w, reader)
+		_, err = w.Write(body)
 		if err != nil {
 			LogWithID(req.req.Context(), "!! failed to send usersave: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Failed to send usersave")
 			return
 		}
 		LogWithID(req.req.Context(), "sent usersave")
 	}
 }
 
+// fetchPreviousUserSave returns the user's stored save to validate changes
+// against, nil if there is none or it can't be decoded, so a broken save can
+// still be replaced
+func fetchPreviousUserSave(ctx context.Context, userSaveStorer UserSaveStorer, userID string) (*usersave.JSONUserSave, error) {
+	reader, err := userSaveStorer.Fetch(userID)
+	if err != nil {
+		if errors.Is(err, ErrNoUserSave) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer reader.Close()
+
+	previous, err := usersave.DecodeUserSave(reader)
+	if err != nil {
+		LogWithID(ctx, "stored usersave can't be decoded, checking changes as if there were none: %s", err)
+		return nil, nil
+	}
+	return previous, nil
+}
+
 // fetchHandler generates an AuthenticatedRequestHandler for saving with a
 // UserSaveStorer
 func saveHandler(userSaveStorer UserSaveStorer) authenticatedRequestHandler {
@@ -126,37 +272,45 @@ func saveHandler(userSaveStorer UserSaveStorer) authenticatedRequestHandler {
 
 		// usersave is decoded from request body to validate correct schema
 		userSave, err := usersave.DecodeUserSave(req.req.Body)
+		if errors.Is(err, usersave.ErrNewerSchema) {
+			LogWithID(req.req.Context(), "refusing usersave: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Usersave is from a newer schema version than the server understands")
+			return
+		}
 		if err != nil {
 			LogWithID(req.req.Context(), "failed to decode incoming usersave: %s", err)
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprint(w, "Failed to decode usersave")
 			return
 		}
-
-		// usersave is re-encoded into the UserSaveStorer
-		writer, err := userSaveStorer.Save(req.req.Context(), req.userID)
+		if err := userSave.Validate(); err != nil {
+			LogWithID(req.req.Context(), "invalid incoming usersave: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid usersave: %s", err)
+			return
+		}
+		previous, err := fetchPreviousUserSave(req.req.Context(), userSaveStorer, req.userID)
 		if err != nil {
-			LogWithID(req.req.Context(), "!! failed to get usersave writer: %s", err)
+			LogWithID(req.req.Context(), "!! failed to fetch previous usersave: %s", err)
 			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Failed to send usersave")
+			fmt.Fprint(w, "Failed to fetch previous usersave")
+			return
+		}
+		if err := userSave.ValidateChanges(previous, time.Now()); err != nil {
+			LogWithID(req.req.Context(), "invalid change to usersave: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid usersave: %s", err)
 			return
 		}
-		defer func() {
-			err := writer.Close()
-			if err != nil {
-				LogWithID(req.req.Context(), "!! failed to close usersave writer: %s", err)
-			}
-		}()
 
-		w.Header().Add("Content-Type", "application/json")
-		err = usersave.EncodeUserSave(userSave, writer)
-		if err != nil {
-			LogWithID(req.req.Context(), "!! failed to encode outgoing usersave: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Failed to send usersave")
+		// usersave is re-encoded into the UserSaveStorer, and only reported
+		// saved once the writer has closed
+		if !storeUserSave(w, req, userSaveStorer, userSave) {
 			return
 		}
 		LogWithID(req.req.Context(), "saved usersave")
+		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "Saved usersave")
 	}
@@ -184,3 +338,12 @@ func RemoveHandler(UserSaveStorer UserSaveStorer) authenticatedRequestHandler {
 		fmt.Fprint(w, "Remove usersave")
 	}
 }
+
+// writeJSON encodes v as the JSON response body with the given status
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		LogWithID(ctx, "!! failed to encode response: %s", err)
+	}
+}
@@ -2,9 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"py-server/usersave"
+	"strings"
 	"testing"
 )
 
@@ -43,7 +47,7 @@ func TestCheckRequestToken(t *testing.T) {
 
 	req, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
-		t.Errorf("failed to create test request: %w", err)
+		t.Errorf("failed to create test request: %s", err)
 	}
 	t.Run("no token", makeCheckTokenTest(http.StatusForbidden, req, tokenChecker))
 
@@ -54,6 +58,83 @@ func TestCheckRequestToken(t *testing.T) {
 	t.Run("matching token", makeCheckTokenTest(http.StatusTeapot, req, tokenChecker))
 }
 
+// test that read only identities may only be used with read methods
+func TestCheckRequestTokenReadOnly(t *testing.T) {
+	tokenChecker := testIdentityChecker{
+		identity: Identity{UserID: "someID", ReadOnly: true},
+	}
+
+	methods := map[string]int{
+		http.MethodGet:    http.StatusTeapot,
+		http.MethodPost:   http.StatusForbidden,
+		http.MethodDelete: http.StatusForbidden,
+	}
+	for method, expectedCode := range methods {
+		req, err := http.NewRequest(method, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Token", "abc")
+		t.Run(method, makeCheckTokenTest(expectedCode, req, tokenChecker))
+	}
+}
+
+type testAuthorizer struct {
+	allowedUserID string
+}
+
+func (a testAuthorizer) Authorize(ctx context.Context, identity Identity) error {
+	if identity.UserID != a.allowedUserID {
+		return errors.New("not allowed")
+	}
+	return nil
+}
+
+// test that authorizeRequest only calls next for identities the Authorizer allows
+func TestAuthorizeRequest(t *testing.T) {
+	teapot := func(w http.ResponseWriter, req *authenticatedRequest) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		authorizer Authorizer
+		expect     int
+	}{
+		"no authorizer": {nil, http.StatusTeapot},
+		"allowed":       {testAuthorizer{"someID"}, http.StatusTeapot},
+		"denied":        {testAuthorizer{"otherID"}, http.StatusForbidden},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			authorizeRequest(test.authorizer, teapot)(rr, &authenticatedRequest{
+				req:      req,
+				userID:   "someID",
+				identity: Identity{UserID: "someID"},
+			})
+			if code := rr.Code; code != test.expect {
+				t.Errorf("expected status code %d, got %d", test.expect, code)
+			}
+		})
+	}
+}
+
+type testIdentityChecker struct {
+	identity Identity
+}
+
+func (t testIdentityChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	return t.identity.UserID, true
+}
+
+func (t testIdentityChecker) TokenIdentity(ctx context.Context, token string) (Identity, bool) {
+	return t.identity, true
+}
+
 // fake io.readcloser
 type testReadCloser struct{}
 
@@ -123,3 +204,119 @@ func TestHandleSave(t *testing.T) {
 		t.Errorf("expected code %d, got %d", http.StatusBadRequest, code)
 	}
 }
+
+func TestFetchUpgradesUserSave(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"income": 1}`),
+	}}
+
+	rr := httptest.NewRecorder()
+	fetchHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave", ""))
+	if !strings.Contains(rr.Body.String(), fmt.Sprintf(`"schemaVersion":%d`, usersave.CurrentSchemaVersion)) {
+		t.Errorf("expected usersave upgraded to the current schema, got %s", rr.Body.String())
+	}
+
+	storer.saves["some user id"] = []byte(`{"schemaVersion": 999}`)
+	rr = httptest.NewRecorder()
+	fetchHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave", ""))
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"schemaVersion": 999}` {
+		t.Errorf("expected usersave which can't be upgraded to be sent as stored, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	saveHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave", `{"schemaVersion": 999}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected newer usersave to be refused, got %d", rr.Code)
+	}
+}
+
+func TestFetchFormats(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "expenses": [{"name": "Rent", "amount": 50000}]}`),
+	}}
+	tests := []struct {
+		path        string
+		accept      string
+		contentType string
+	}{
+		{"/v1/usersave", "", "application/json"},
+		{"/v1/usersave", "text/html, */*", "application/json"},
+		{"/v1/usersave", "text/csv", "text/csv"},
+		{"/v1/usersave", "text/csv;q=0.1, application/json", "application/json"},
+		{"/v1/usersave", "application/json;q=0.5, text/*", "text/csv"},
+		{"/v1/usersave", "*/*;q=0.2, application/json;q=0", "text/csv"},
+		{"/v1/usersave?format=xlsx", "text/csv", formatMediaTypes["xlsx"]},
+		{"/v1/usersave?format=jsonl", "", "application/jsonl"},
+	}
+	for _, test := range tests {
+		req := makeAuthedRequest(t, "GET", test.path, "")
+		req.req.Header.Set("Accept", test.accept)
+		rr := httptest.NewRecorder()
+		fetchHandler(storer)(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s accepting %q: expected %s, got %d %s", test.path, test.accept, test.contentType,
+				rr.Code, rr.Header().Get("Content-Type"))
+		}
+		if rr.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s accepting %q: expected response to vary by Accept", test.path, test.accept)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	fetchHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave?format=csv", ""))
+	if !strings.Contains(rr.Body.String(), "expense,Rent,500.00,NZD") {
+		t.Errorf("expected CSV of expenses, got %s", rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	fetchHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave?format=pdf", ""))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown format to be refused, got %d", rr.Code)
+	}
+
+	storer.saves["some user id"] = []byte(`{"schemaVersion": 999}`)
+	rr = httptest.NewRecorder()
+	fetchHandler(storer)(rr, makeAuthedRequest(t, "GET", "/v1/usersave?format=csv", ""))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected usersave which can't be upgraded not to be converted, got %d", rr.Code)
+	}
+}
+
+func TestSaveValidatesUserSave(t *testing.T) {
+	storer := &memoryUserSaveStorer{saves: map[string][]byte{}}
+
+	rr := httptest.NewRecorder()
+	saveHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave", `{"cycle": "daily"}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown cycle to be refused, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	saveHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave", `{"schemaVersion": 3, "savings": [{"deadline": "2000-01-01"}]}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected past deadline to be refused, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	saveHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave", `{"cycle": "four weekly"}`))
+	if rr.Code != http.StatusOK || !strings.Contains(string(storer.saves["some user id"]), `"cycle":"Four-weekly"`) {
+		t.Errorf("expected cycle to be normalised, got %d %s", rr.Code, storer.saves["some user id"])
+	}
+
+	// a stored save that can't be decoded can still be replaced
+	storer.saves["some user id"] = []byte(`garbage`)
+	rr = httptest.NewRecorder()
+	saveHandler(storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave", `{"cycle": "Weekly"}`))
+	if rr.Code != http.StatusOK || !strings.Contains(string(storer.saves["some user id"]), `"cycle":"Weekly"`) {
+		t.Errorf("expected undecodable stored save to be replaced, got %d %s", rr.Code, storer.saves["some user id"])
+	}
+
+	// a failed upload is neither reported nor audited as saved
+	storer.saveErr = errors.New("upload failed")
+	sink := &memoryAuditSink{}
+	rr = httptest.NewRecorder()
+	audited(sink, ActionSaveUserSave, storer, saveHandler(storer))(rr,
+		makeAuthedRequest(t, "POST", "/v1/usersave", `{"cycle": "Monthly"}`))
+	if rr.Code != http.StatusInternalServerError || len(sink.entries) != 0 {
+		t.Errorf("expected failed upload to fail unaudited, got %d with %d entries", rr.Code, len(sink.entries))
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"py-server/ledger"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	ledgerImportPath        = "/v1/ledger/import"
+	ledgerImportPreviewPath = ledgerImportPath + "/preview"
+	maxStatementSize        = 5 << 20
+	maxStatementLength      = 10000
+)
+
+// ActionImportTransactions is the audited action of importing a statement
+const ActionImportTransactions = "ledger.import"
+
+type importResponse struct {
+	Transactions []ledger.ImportedTransaction `json:"transactions"`
+	// New counts the transactions the ledger doesn't have yet
+	New        int `json:"new"`
+	Duplicates int `json:"duplicates"`
+	// Skipped are the statement's entries for nothing, which aren't imported
+	Skipped []ledger.SkippedTransaction `json:"skipped"`
+}
+
+func newImportResponse(imported []ledger.ImportedTransaction, skipped []ledger.SkippedTransaction) importResponse {
+	response := importResponse{Transactions: imported, Skipped: skipped}
+	for _, transaction := range imported {
+		if transaction.Duplicate {
+			response.Duplicates++
+		} else {
+			response.New++
+		}
+	}
+	return response
+}
+
+// statementOptions reads how to parse the statement from the query
+// parameters, the currency defaulting to the user's save's
+func statementOptions(w http.ResponseWriter, req *authenticatedRequest, saves UserSaveStorer) (ledger.StatementFormat, ledger.StatementOptions, bool) {
+	ctx := req.req.Context()
+	params := req.req.URL.Query()
+	format := ledger.StatementFormat(strings.ToLower(params.Get("format")))
+	if !format.Valid() {
+		LogWithID(ctx, "unknown statement format %q", format)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Query parameter format must be one of csv, ofx or qif")
+		return "", ledger.StatementOptions{}, false
+	}
+
+	options := ledger.StatementOptions{
+		Currency:   strings.ToUpper(params.Get("currency")),
+		Account:    strings.TrimSpace(params.Get("account")),
+		DateFormat: params.Get("dateFormat"),
+		Columns: ledger.CSVColumns{
+			Date:           params.Get("dateColumn"),
+			Description:    params.Get("descriptionColumn"),
+			Amount:         params.Get("amountColumn"),
+			Debit:          params.Get("debitColumn"),
+			Credit:         params.Get("creditColumn"),
+			Tag:            params.Get("tagColumn"),
+			Account:        params.Get("accountColumn"),
+			DebitsPositive: params.Get("debitsPositive") == "true",
+		},
+	}
+	if len(options.Currency) > 0 {
+		return format, options, true
+	}
+
+	userSave, err := fetchPreviousUserSave(ctx, saves, req.userID)
+	if err != nil {
+		LogWithID(ctx, "!! failed to fetch usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to fetch usersave")
+		return "", ledger.StatementOptions{}, false
+	}
+	if userSave != nil {
+		options.Currency = userSave.Currency()
+	}
+	// OFX statements name their currency
+	if len(options.Currency) < 1 && format != ledger.FormatOFX {
+		LogWithID(ctx, "no currency for statement")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Query parameter currency is required as the usersave has no currency")
+		return "", ledger.StatementOptions{}, false
+	}
+	return format, options, true
+}
+
+// readStatement parses the transactions of the statement in the request body,
+// and the entries skipped. It writes an error response and returns false if it
+// can't.
+func readStatement(w http.ResponseWriter, req *authenticatedRequest, saves UserSaveStorer) ([]ledger.Transaction, []ledger.SkippedTransaction, bool) {
+	ctx := req.req.Context()
+	format, options, ok := statementOptions(w, req, saves)
+	if !ok {
+		return nil, nil, false
+	}
+	if req.req.Body == nil {
+		LogWithID(ctx, "no statement")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "No statement in request body")
+		return nil, nil, false
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(req.req.Body, maxStatementSize+1))
+	if err != nil {
+		LogWithID(ctx, "failed to read statement: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Failed to read statement")
+		return nil, nil, false
+	}
+	if len(content) > maxStatementSize {
+		LogWithID(ctx, "statement too large")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Statement must be at most %d bytes", maxStatementSize)
+		return nil, nil, false
+	}
+
+	transactions, skipped, err := ledger.ParseStatement(bytes.NewReader(content), format, options)
+	if err != nil {
+		LogWithID(ctx, "failed to parse %s statement: %s", format, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to parse statement: %s", err)
+		return nil, nil, false
+	}
+	if len(transactions) > maxStatementLength {
+		LogWithID(ctx, "statement has %d transactions", len(transactions))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Statement must have at most %d transactions", maxStatementLength)
+		return nil, nil, false
+	}
+	return transactions, skipped, true
+}
+
+// previewImportHandler generates an authenticatedRequestHandler sending the
+// transactions of the statement in the request body, marking those already in
+// the user's ledger and applying their rules, without changing the ledger
+func previewImportHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to preview statement import")
+
+		transactions, skipped, ok := readStatement(w, req, saves)
+		if !ok {
+			return
+		}
+		userLedger, _, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return
+		}
+
+		imported, err := userLedger.PrepareImport(transactions)
+		if err != nil {
+			LogWithID(ctx, "!! failed to apply rules: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to apply rules")
+			return
+		}
+
+		response := newImportResponse(imported, skipped)
+		writeJSON(ctx, w, http.StatusOK, response)
+		LogWithID(ctx, "previewed %d new and %d duplicate transactions", response.New, response.Duplicates)
+	}
+}
+
+// importHandler generates an authenticatedRequestHandler recording the
+// transactions of the statement in the request body into the user's ledger,
+// skipping those it already has and categorising the rest by their rules
+func importHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to import statement")
+
+		transactions, skipped, ok := readStatement(w, req, saves)
+		if !ok {
+			return
+		}
+		var imported []ledger.ImportedTransaction
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			var err error
+			if imported, err = userLedger.PrepareImport(transactions); err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			for i := range imported {
+				if imported[i].Duplicate {
+					continue
+				}
+				imported[i].ID = xid.New().String()
+				imported[i].CreatedAt = now
+				imported[i].UpdatedAt = now
+				userLedger.Transactions = append(userLedger.Transactions, imported[i].Transaction)
+			}
+			return nil
+		}) {
+			return
+		}
+
+		response := newImportResponse(imported, skipped)
+		writeJSON(ctx, w, http.StatusOK, response)
+		LogWithID(ctx, "imported %d transactions, skipped %d duplicates", response.New, response.Duplicates)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImportHandlers(t *testing.T) {
+	storer := makeMemoryLedgerStorer()
+	saves := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "expenses": [{"name": "Rent", "amount": 50000}]}`),
+	}}
+	createTestTransaction(t, storer, saves,
+		`{"date": "2021-06-01", "amount": {"amount": "4.50", "currency": "NZD"}, "description": "Coffee"}`)
+	statement := "Date,Description,Amount\n2021-06-01,COFFEE,-4.50\n2021-06-02,Books,-20.00\n2021-06-03,Pending,0.00\n"
+	decode := func(rr *httptest.ResponseRecorder) importResponse {
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+		response := importResponse{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	rr := httptest.NewRecorder()
+	previewImportHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerImportPreviewPath+"?format=csv", statement))
+	preview := decode(rr)
+	if preview.New != 1 || preview.Duplicates != 1 || !preview.Transactions[0].Duplicate {
+		t.Errorf("expected the coffee to be a duplicate, got %+v", preview)
+	}
+	if len(preview.Skipped) != 1 || preview.Skipped[0].Index != 3 {
+		t.Errorf("expected the pending entry to be skipped, got %+v", preview.Skipped)
+	}
+	userLedger, _, _ := fetchLedger(context.Background(), storer, "some user id")
+	if len(userLedger.Transactions) != 1 {
+		t.Errorf("expected preview not to change the ledger, got %d transactions", len(userLedger.Transactions))
+	}
+
+	rr = httptest.NewRecorder()
+	importHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerImportPath+"?format=csv&account=Cheque", statement))
+	imported := decode(rr)
+	if imported.New != 1 || len(imported.Transactions[1].ID) < 1 || imported.Transactions[1].Account != "Cheque" {
+		t.Errorf("expected the books to be imported, got %+v", imported)
+	}
+	userLedger, _, _ = fetchLedger(context.Background(), storer, "some user id")
+	if len(userLedger.Transactions) != 2 || userLedger.Transactions[1].Amount.Minor != 2000 {
+		t.Errorf("expected the books in the ledger, got %+v", userLedger.Transactions)
+	}
+
+	rr = httptest.NewRecorder()
+	importHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerImportPath+"?format=csv", statement))
+	if again := decode(rr); again.New != 0 || again.Duplicates != 2 {
+		t.Errorf("expected importing again to import nothing, got %+v", again)
+	}
+
+	tests := []struct {
+		path   string
+		body   string
+		expect int
+	}{
+		{ledgerImportPath, statement, http.StatusBadRequest},
+		{ledgerImportPath + "?format=xls", statement, http.StatusBadRequest},
+		{ledgerImportPath + "?format=csv", "Date,Amount\n", http.StatusBadRequest},
+		{ledgerImportPath + "?format=csv&dateFormat=DD/MM/YYYY", statement, http.StatusBadRequest},
+		{ledgerImportPath + "?format=csv", strings.Repeat(" ", maxStatementSize+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		importHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", test.path, test.body))
+		if rr.Code != test.expect {
+			t.Errorf("%s: expected status code %d, got %d", test.path, test.expect, rr.Code)
+		}
+	}
+
+	saves.saves["some user id"] = []byte(`{}`)
+	rr = httptest.NewRecorder()
+	importHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerImportPath+"?format=csv", statement))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d without a currency, got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	importHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerImportPath+"?format=csv&currency=nzd", statement))
+	decode(rr)
+}
@@ -0,0 +1,459 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"py-server/ledger"
+	"py-server/usersave"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+var (
+	ErrNoLedger      = errors.New("no ledger for user")
+	ErrLedgerChanged = errors.New("ledger changed since it was fetched")
+)
+
+const (
+	ledgerTransactionsPath = "/v1/ledger/transactions"
+	ledgerTransactionPath  = ledgerTransactionsPath + "/"
+	defaultReportCycles    = 6
+	maxReportCycles        = 52
+	// maxLedgerAttempts bounds how many times a change is retried when the
+	// ledger is changed by another request at the same time
+	maxLedgerAttempts = 3
+)
+
+// Audited ledger actions
+const (
+	ActionCreateTransaction = "ledger.create"
+	ActionUpdateTransaction = "ledger.update"
+	ActionRemoveTransaction = "ledger.remove"
+)
+
+// LedgerStorer defines methods for storing each user's ledger of
+// transactions. Ledgers are versioned so concurrent changes aren't lost.
+type LedgerStorer interface {
+	// FetchLedger returns the user's ledger and its version, and should
+	// return ErrNoLedger if the user has none
+	FetchLedger(ctx context.Context, userID string) (*ledger.Ledger, int64, error)
+	// SaveLedger stores the user's ledger if the stored ledger is still at
+	// the version, 0 meaning none is stored, and should return
+	// ErrLedgerChanged if it isn't
+	SaveLedger(ctx context.Context, userID string, userLedger *ledger.Ledger, version int64) error
+	// EraseLedger deletes the user's ledger, if any
+	EraseLedger(ctx context.Context, userID string) error
+}
+
+// fetchLedger returns the user's ledger and its version, an empty ledger at
+// version 0 if they have none
+func fetchLedger(ctx context.Context, storer LedgerStorer, userID string) (*ledger.Ledger, int64, error) {
+	userLedger, version, err := storer.FetchLedger(ctx, userID)
+	if errors.Is(err, ErrNoLedger) {
+		return &ledger.Ledger{Transactions: []ledger.Transaction{}}, 0, nil
+	}
+	return userLedger, version, err
+}
+
+// updateLedger makes the change to the user's ledger and stores it, trying
+// again if another request changes the ledger in the meantime. It writes an
+// error response and returns false if it can't.
+func updateLedger(w http.ResponseWriter, req *authenticatedRequest, storer LedgerStorer, change func(*ledger.Ledger) error) bool {
+	ctx := req.req.Context()
+	for attempt := 1; ; attempt++ {
+		userLedger, version, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return false
+		}
+		if err := change(userLedger); err != nil {
+			if errors.Is(err, ledger.ErrNoTransaction) {
+				LogWithID(ctx, "no such transaction")
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "No such transaction")
+				return false
+			}
+			if errors.Is(err, ledger.ErrNoRule) {
+				LogWithID(ctx, "no such rule")
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "No such rule")
+				return false
+			}
+			LogWithID(ctx, "can't change ledger: %s", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, "Can't change ledger: %s", err)
+			return false
+		}
+
+		err = storer.SaveLedger(ctx, req.userID, userLedger, version)
+		if err == nil {
+			return true
+		}
+		if !errors.Is(err, ErrLedgerChanged) {
+			LogWithID(ctx, "!! failed to store ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to store ledger")
+			return false
+		}
+		if attempt >= maxLedgerAttempts {
+			LogWithID(ctx, "ledger kept changing, giving up after %d attempts", attempt)
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "Ledger is being changed by another request, try again")
+			return false
+		}
+		LogWithID(ctx, "ledger changed, trying again")
+	}
+}
+
+// transactionRequest is what a user may set of a transaction
+type transactionRequest struct {
+	Date        usersave.Date  `json:"date"`
+	Amount      usersave.Money `json:"amount"`
+	Tag         usersave.Tag   `json:"tag"`
+	Note        string         `json:"note"`
+	Description string         `json:"description"`
+	Account     string         `json:"account"`
+	Expense     string         `json:"expense"`
+}
+
+// transaction returns what the request sets of a transaction
+func (r transactionRequest) transaction() ledger.Transaction {
+	return ledger.Transaction{
+		Date:        r.Date,
+		Amount:      r.Amount,
+		Tag:         r.Tag,
+		Note:        r.Note,
+		Description: r.Description,
+		Account:     r.Account,
+		Expense:     r.Expense,
+	}
+}
+
+// decodeTransaction decodes and validates the transaction in the request
+// body, checking its tag is catalogued and any planned expense it links to
+// exists. It writes an error response and returns false if it can't.
+func decodeTransaction(w http.ResponseWriter, req *authenticatedRequest, saves UserSaveStorer) (transactionRequest, bool) {
+	ctx := req.req.Context()
+	body := transactionRequest{}
+	if err := json.NewDecoder(req.req.Body).Decode(&body); err != nil {
+		LogWithID(ctx, "failed to decode transaction: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Failed to decode transaction")
+		return body, false
+	}
+	body.Tag = strings.TrimSpace(body.Tag)
+	if err := body.transaction().Validate(); err != nil {
+		LogWithID(ctx, "invalid transaction: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid transaction: %s", err)
+		return body, false
+	}
+	return body, checkLinks(w, req, saves, body.Tag, body.Expense)
+}
+
+// checkLinks checks the user's save catalogues the tag and has a planned
+// expense with the name, where either is given. It writes an error response and
+// returns false if it doesn't.
+func checkLinks(w http.ResponseWriter, req *authenticatedRequest, saves UserSaveStorer, tag usersave.Tag, expense string) bool {
+	ctx := req.req.Context()
+	if len(tag) < 1 && len(expense) < 1 {
+		return true
+	}
+
+	userSave, err := fetchPreviousUserSave(ctx, saves, req.userID)
+	if err != nil {
+		LogWithID(ctx, "!! failed to fetch usersave: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Failed to fetch usersave")
+		return false
+	}
+	if userSave == nil {
+		userSave = &usersave.JSONUserSave{}
+	}
+	if len(tag) > 0 && !hasTag(userSave, tag) {
+		LogWithID(ctx, "tag %q isn't catalogued", tag)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "Tag %q isn't catalogued", tag)
+		return false
+	}
+	if len(expense) > 0 && !hasExpense(userSave, expense) {
+		LogWithID(ctx, "no planned expense %q", expense)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "No planned expense named %q", expense)
+		return false
+	}
+	return true
+}
+
+// hasTag returns whether the save catalogues a tag with the name
+func hasTag(userSave *usersave.JSONUserSave, name usersave.Tag) bool {
+	for _, tag := range userSave.Tags {
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExpense returns whether the save has a planned expense with the name
+func hasExpense(userSave *usersave.JSONUserSave, name string) bool {
+	for _, expense := range userSave.Expenses {
+		if expense.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type listTransactionsResponse struct {
+	Transactions  []ledger.Transaction `json:"transactions"`
+	NextPageToken string               `json:"nextPageToken,omitempty"`
+}
+
+// queryDate parses the named query parameter as a date, nil if not given,
+// writing an error response and returning false if it is invalid
+func queryDate(w http.ResponseWriter, req *authenticatedRequest, name string) (*usersave.Date, bool) {
+	value := req.req.URL.Query().Get(name)
+	if len(value) < 1 {
+		return nil, true
+	}
+	date, err := usersave.ParseDate(value)
+	if err != nil {
+		LogWithID(req.req.Context(), "invalid %s date %q", name, value)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Query parameter %s must be a YYYY-MM-DD date", name)
+		return nil, false
+	}
+	return &date, true
+}
+
+// listTransactionsHandler generates an authenticatedRequestHandler listing a
+// page of the user's transactions, newest first, from the from, to, tag,
+// pageToken and pageSize query parameters
+func listTransactionsHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to list transactions")
+
+		params := req.req.URL.Query()
+		query := ledger.Query{Tag: params.Get("tag"), PageToken: params.Get("pageToken")}
+		var ok bool
+		if query.PageSize, ok = queryCount(w, req, "pageSize", defaultPageSize, maxPageSize); !ok {
+			return
+		}
+		if query.From, ok = queryDate(w, req, "from"); !ok {
+			return
+		}
+		if query.To, ok = queryDate(w, req, "to"); !ok {
+			return
+		}
+
+		userLedger, _, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return
+		}
+		transactions, next, err := userLedger.Page(query)
+		if err != nil {
+			LogWithID(ctx, "invalid page token %q", query.PageToken)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid page token")
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, listTransactionsResponse{
+			Transactions:  transactions,
+			NextPageToken: next,
+		})
+		LogWithID(ctx, "sent %d transactions", len(transactions))
+	}
+}
+
+// createTransactionHandler generates an authenticatedRequestHandler recording
+// a transaction in the user's ledger
+func createTransactionHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to create transaction")
+
+		body, ok := decodeTransaction(w, req, saves)
+		if !ok {
+			return
+		}
+		transaction := body.transaction()
+		transaction.ID = xid.New().String()
+		transaction.CreatedAt = time.Now().UTC()
+		transaction.UpdatedAt = transaction.CreatedAt
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			userLedger.Transactions = append(userLedger.Transactions, transaction)
+			return nil
+		}) {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusCreated, transaction)
+		LogWithID(req.req.Context(), "created transaction %s", transaction.ID)
+	}
+}
+
+// pathTransactionID returns the transaction ID from the last element of the
+// path, writing an error response and returning false if there is none
+func pathTransactionID(w http.ResponseWriter, req *authenticatedRequest) (string, bool) {
+	id := strings.TrimPrefix(req.req.URL.Path, ledgerTransactionPath)
+	if len(id) < 1 || strings.Contains(id, "/") {
+		LogWithID(req.req.Context(), "invalid transaction id")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "No such transaction")
+		return "", false
+	}
+	return id, true
+}
+
+// fetchTransactionHandler generates an authenticatedRequestHandler sending the
+// transaction whose ID is the last element of the path
+func fetchTransactionHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to fetch transaction")
+
+		id, ok := pathTransactionID(w, req)
+		if !ok {
+			return
+		}
+		userLedger, _, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return
+		}
+		i, err := userLedger.Find(id)
+		if err != nil {
+			LogWithID(ctx, "no transaction %s", id)
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "No such transaction")
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, userLedger.Transactions[i])
+		LogWithID(ctx, "sent transaction %s", id)
+	}
+}
+
+// updateTransactionHandler generates an authenticatedRequestHandler replacing
+// what the user may set of the transaction whose ID is the last element of
+// the path
+func updateTransactionHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to update transaction")
+
+		id, ok := pathTransactionID(w, req)
+		if !ok {
+			return
+		}
+		body, ok := decodeTransaction(w, req, saves)
+		if !ok {
+			return
+		}
+		var updated ledger.Transaction
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			i, err := userLedger.Find(id)
+			if err != nil {
+				return err
+			}
+			updated = body.transaction()
+			updated.ID = id
+			updated.CreatedAt = userLedger.Transactions[i].CreatedAt
+			updated.UpdatedAt = time.Now().UTC()
+			userLedger.Transactions[i] = updated
+			return nil
+		}) {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, updated)
+		LogWithID(req.req.Context(), "updated transaction %s", id)
+	}
+}
+
+// removeTransactionHandler generates an authenticatedRequestHandler removing
+// the transaction whose ID is the last element of the path
+func removeTransactionHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to remove transaction")
+
+		id, ok := pathTransactionID(w, req)
+		if !ok {
+			return
+		}
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			return userLedger.Remove(id)
+		}) {
+			return
+		}
+
+		LogWithID(req.req.Context(), "removed transaction %s", id)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Removed transaction")
+	}
+}
+
+// ledgerReportHandler generates an authenticatedRequestHandler comparing the
+// user's planned expenses to their transactions over as many recent cycles as
+// the cycles query parameter asks
+func ledgerReportHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to report on ledger")
+
+		cycles, ok := queryCount(w, req, "cycles", defaultReportCycles, maxReportCycles)
+		if !ok {
+			return
+		}
+		userSave := fetchUserSave(w, req, saves)
+		if userSave == nil {
+			return
+		}
+		userLedger, _, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return
+		}
+
+		report, err := ledger.BuildReport(userSave, userLedger.Transactions, time.Now(), cycles)
+		if err != nil {
+			writeComputeError(w, req, "ledger report", err)
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, report)
+		LogWithID(ctx, "sent %d cycle ledger report", cycles)
+	}
+}
+
+// ledgerExport exports the user's ledger
+func ledgerExport(storer LedgerStorer) exportPart {
+	return exportPart{"ledger", func(ctx context.Context, userID string, archive *zip.Writer) error {
+		userLedger, _, err := fetchLedger(ctx, storer, userID)
+		if err != nil {
+			return err
+		}
+		return writeJSONFile(archive, "ledger.json", userLedger)
+	}}
+}
+
+// ledgerErasure erases the user's ledger
+func ledgerErasure(storer LedgerStorer) erasureStep {
+	return erasureStep{"ledger", storer.EraseLedger}
+}
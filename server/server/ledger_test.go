@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"py-server/ledger"
+	"testing"
+)
+
+// in memory LedgerStorer, whose ledgers change underneath the next conflicts
+// saves
+type memoryLedgerStorer struct {
+	ledgers   map[string][]byte
+	versions  map[string]int64
+	conflicts int
+}
+
+func makeMemoryLedgerStorer() *memoryLedgerStorer {
+	return &memoryLedgerStorer{ledgers: map[string][]byte{}, versions: map[string]int64{}}
+}
+
+func (m *memoryLedgerStorer) FetchLedger(ctx context.Context, userID string) (*ledger.Ledger, int64, error) {
+	stored, ok := m.ledgers[userID]
+	if !ok {
+		return nil, 0, ErrNoLedger
+	}
+	userLedger := &ledger.Ledger{}
+	err := json.Unmarshal(stored, userLedger)
+	return userLedger, m.versions[userID], err
+}
+
+func (m *memoryLedgerStorer) SaveLedger(ctx context.Context, userID string, userLedger *ledger.Ledger, version int64) error {
+	if m.conflicts > 0 {
+		m.conflicts--
+		m.versions[userID]++
+	}
+	if m.versions[userID] != version {
+		return ErrLedgerChanged
+	}
+	stored, err := json.Marshal(userLedger)
+	if err != nil {
+		return err
+	}
+	m.ledgers[userID] = stored
+	m.versions[userID]++
+	return nil
+}
+
+func (m *memoryLedgerStorer) EraseLedger(ctx context.Context, userID string) error {
+	delete(m.ledgers, userID)
+	delete(m.versions, userID)
+	return nil
+}
+
+func createTestTransaction(t *testing.T, storer LedgerStorer, saves UserSaveStorer, body string) ledger.Transaction {
+	rr := httptest.NewRecorder()
+	createTransactionHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerTransactionsPath, body))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d creating %s, got %d: %s", http.StatusCreated, body, rr.Code, rr.Body)
+	}
+	transaction := ledger.Transaction{}
+	if err := json.NewDecoder(rr.Body).Decode(&transaction); err != nil {
+		t.Fatal(err)
+	}
+	return transaction
+}
+
+func TestTransactionHandlers(t *testing.T) {
+	storer := makeMemoryLedgerStorer()
+	saves := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"schemaVersion": 4, "cycle": "Weekly",
+			"tags": [{"id": "home", "name": "Home"}, {"id": "food", "name": "Food"}],
+			"expenses": [{"name": "Rent", "amount": {"amount": "500.00", "currency": "NZD"}, "tag": "Home"}]}`),
+	}}
+
+	rent := createTestTransaction(t, storer, saves,
+		`{"date": "2021-06-01", "amount": {"amount": "500.00", "currency": "NZD"}, "expense": "Rent"}`)
+	storer.conflicts = 2
+	coffee := createTestTransaction(t, storer, saves,
+		`{"date": "2021-06-02", "amount": {"amount": "4.50", "currency": "NZD"}, "tag": " Food ", "note": "flat white"}`)
+	if len(rent.ID) < 1 || coffee.Tag != "Food" || coffee.CreatedAt.IsZero() {
+		t.Errorf("unexpected transaction %+v", coffee)
+	}
+
+	invalid := map[string]int{
+		`nonsense`: http.StatusBadRequest,
+		`{"amount": {"amount": "1.00", "currency": "NZD"}}`: http.StatusBadRequest,
+		`{"date": "2021-06-01"}`:                            http.StatusBadRequest,
+		`{"date": "2021-06-01", "amount": {"amount": "1.00", "currency": "NZD"}, "expense": "Boat"}`: http.StatusUnprocessableEntity,
+		`{"date": "2021-06-01", "amount": {"amount": "1.00", "currency": "NZD"}, "tag": "Boats"}`:    http.StatusUnprocessableEntity,
+	}
+	for body, expect := range invalid {
+		rr := httptest.NewRecorder()
+		createTransactionHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerTransactionsPath, body))
+		if rr.Code != expect {
+			t.Errorf("%s: expected status code %d, got %d", body, expect, rr.Code)
+		}
+	}
+
+	storer.conflicts = maxLedgerAttempts
+	rr := httptest.NewRecorder()
+	createTransactionHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerTransactionsPath,
+		`{"date": "2021-06-03", "amount": {"amount": "1.00", "currency": "NZD"}}`))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status code %d when the ledger keeps changing, got %d", http.StatusConflict, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	listTransactionsHandler(storer)(rr, makeAuthedRequest(t, "GET", ledgerTransactionsPath+"?pageSize=1", ""))
+	page := listTransactionsResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != coffee.ID || len(page.NextPageToken) < 1 {
+		t.Fatalf("expected newest transaction first, got %+v", page)
+	}
+	rr = httptest.NewRecorder()
+	listTransactionsHandler(storer)(rr, makeAuthedRequest(t, "GET", ledgerTransactionsPath+"?pageSize=1&pageToken="+page.NextPageToken, ""))
+	page = listTransactionsResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != rent.ID || len(page.NextPageToken) > 0 {
+		t.Errorf("expected the last page to hold the oldest transaction, got %+v", page)
+	}
+
+	rr = httptest.NewRecorder()
+	updateTransactionHandler(storer, saves)(rr, makeAuthedRequest(t, "PUT", ledgerTransactionPath+coffee.ID,
+		`{"date": "2021-06-02", "amount": {"amount": "5.00", "currency": "NZD"}, "tag": "Food"}`))
+	updated := ledger.Transaction{}
+	if err := json.NewDecoder(rr.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Amount.Minor != 500 || updated.Note != "" || !updated.CreatedAt.Equal(coffee.CreatedAt) {
+		t.Errorf("expected transaction to be replaced, got %+v", updated)
+	}
+
+	rr = httptest.NewRecorder()
+	fetchTransactionHandler(storer)(rr, makeAuthedRequest(t, "GET", ledgerTransactionPath+coffee.ID, ""))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d fetching transaction, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	removeTransactionHandler(storer)(rr, makeAuthedRequest(t, "DELETE", ledgerTransactionPath+coffee.ID, ""))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d removing transaction, got %d", http.StatusOK, rr.Code)
+	}
+	missing := []authenticatedRequestHandler{
+		fetchTransactionHandler(storer),
+		removeTransactionHandler(storer),
+	}
+	for _, handler := range missing {
+		rr = httptest.NewRecorder()
+		handler(rr, makeAuthedRequest(t, "GET", ledgerTransactionPath+coffee.ID, ""))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d for removed transaction, got %d", http.StatusNotFound, rr.Code)
+		}
+	}
+}
+
+func TestLedgerReportHandler(t *testing.T) {
+	storer := makeMemoryLedgerStorer()
+	saves := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "expenses": [{"name": "Rent", "amount": 50000, "tag": "Home"}]}`),
+	}}
+
+	rr := httptest.NewRecorder()
+	ledgerReportHandler(storer, saves)(rr, makeAuthedRequest(t, "GET", "/v1/ledger/report?cycles=2", ""))
+	report := ledger.Report{}
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Cycles) != 2 || report.Cycles[1].Difference.Minor != -50000 {
+		t.Errorf("expected nothing spent against the plan, got %+v", report)
+	}
+
+	tests := map[string]int{
+		"/v1/ledger/report?cycles=0":  http.StatusBadRequest,
+		"/v1/ledger/report?cycles=53": http.StatusBadRequest,
+	}
+	for path, expect := range tests {
+		rr := httptest.NewRecorder()
+		ledgerReportHandler(storer, saves)(rr, makeAuthedRequest(t, "GET", path, ""))
+		if rr.Code != expect {
+			t.Errorf("%s: expected status code %d, got %d", path, expect, rr.Code)
+		}
+	}
+
+	saves.saves["some user id"] = []byte(`{}`)
+	rr = httptest.NewRecorder()
+	ledgerReportHandler(storer, saves)(rr, makeAuthedRequest(t, "GET", "/v1/ledger/report", ""))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d without a cycle, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role grants privileges beyond a user's own data
+type Role string
+
+const (
+	// RoleAdmin may read and repair any user's data
+	RoleAdmin Role = "admin"
+	// RoleSupport may read any user's data
+	RoleSupport Role = "support"
+)
+
+// RoleResolver determines the roles held by an Identity
+type RoleResolver interface {
+	Roles(ctx context.Context, identity Identity) []Role
+}
+
+func resolveRoles(ctx context.Context, resolver RoleResolver, identity Identity) []Role {
+	if resolver == nil {
+		return nil
+	}
+	return resolver.Roles(ctx, identity)
+}
+
+// hasRole returns true if the request's identity holds any of the roles
+func (r *authenticatedRequest) hasRole(roles ...Role) bool {
+	for _, held := range r.roles {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireRoles returns an authenticatedRequestHandler which calls next only if
+// the request's identity holds one of the roles, rejecting it otherwise.
+func requireRoles(resolver RoleResolver, roles []Role, next authenticatedRequestHandler) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		req.roles = resolveRoles(req.req.Context(), resolver, req.identity)
+		if !req.hasRole(roles...) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "missing required role")
+			LogWithID(req.req.Context(), "user %s missing required role", req.userID)
+			return
+		}
+
+		held := make([]string, 0, len(req.roles))
+		for _, role := range req.roles {
+			held = append(held, string(role))
+		}
+		LogWithID(req.req.Context(), "user %s acting with roles %s", req.userID, strings.Join(held, ","))
+		next(w, req)
+	}
+}
+
+type meResponse struct {
+	UserID   string `json:"userId"`
+	Email    string `json:"email,omitempty"`
+	ReadOnly bool   `json:"readOnly"`
+	Roles    []Role `json:"roles"`
+}
+
+// meHandler generates an authenticatedRequestHandler describing the user
+// making the request, including their roles
+func meHandler(resolver RoleResolver) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		roles := resolveRoles(req.req.Context(), resolver, req.identity)
+		if roles == nil {
+			roles = []Role{}
+		}
+		writeJSON(req.req.Context(), w, http.StatusOK, meResponse{
+			UserID:   req.userID,
+			Email:    req.identity.Email,
+			ReadOnly: req.identity.ReadOnly,
+			Roles:    roles,
+		})
+		LogWithID(req.req.Context(), "sent user description")
+	}
+}
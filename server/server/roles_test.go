@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// grants each user ID the role of the same name
+type testRoleResolver struct{}
+
+func (r testRoleResolver) Roles(ctx context.Context, identity Identity) []Role {
+	return []Role{Role(identity.UserID)}
+}
+
+func TestRequireRoles(t *testing.T) {
+	teapot := func(w http.ResponseWriter, req *authenticatedRequest) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	tests := map[string]struct {
+		resolver RoleResolver
+		userID   string
+		expect   int
+	}{
+		"admin":       {testRoleResolver{}, "admin", http.StatusTeapot},
+		"support":     {testRoleResolver{}, "support", http.StatusTeapot},
+		"other":       {testRoleResolver{}, "user", http.StatusForbidden},
+		"no resolver": {nil, "admin", http.StatusForbidden},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := makeAuthedRequest(t, "GET", "/", "")
+			req.userID = test.userID
+			req.identity.UserID = test.userID
+
+			rr := httptest.NewRecorder()
+			requireRoles(test.resolver, []Role{RoleAdmin, RoleSupport}, teapot)(rr, req)
+			if code := rr.Code; code != test.expect {
+				t.Errorf("expected status code %d, got %d", test.expect, code)
+			}
+		})
+	}
+}
+
+func TestMeHandler(t *testing.T) {
+	req := makeAuthedRequest(t, "GET", "/v1/me", "")
+	req.userID = "admin"
+	req.identity.UserID = "admin"
+
+	rr := httptest.NewRecorder()
+	meHandler(testRoleResolver{})(rr, req)
+	if code := rr.Code; code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, code)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `"roles":["admin"]`) {
+		t.Errorf("expected admin role in response, got %s", body)
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"py-server/ledger"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	ledgerRulesPath             = "/v1/ledger/rules"
+	ledgerRulePath              = ledgerRulesPath + "/"
+	ledgerRulesApplyPath        = ledgerRulesPath + "/apply"
+	ledgerRulesApplyPreviewPath = ledgerRulesApplyPath + "/preview"
+)
+
+// Audited rule actions
+const (
+	ActionCreateRule = "ledger.rules.create"
+	ActionUpdateRule = "ledger.rules.update"
+	ActionRemoveRule = "ledger.rules.remove"
+	ActionApplyRules = "ledger.rules.apply"
+)
+
+// decodeRule decodes and validates the rule in the request body, checking its
+// tag is catalogued and any planned expense it links to exists. It writes an
+// error response and returns false if it can't.
+func decodeRule(w http.ResponseWriter, req *authenticatedRequest, saves UserSaveStorer) (ledger.Rule, bool) {
+	ctx := req.req.Context()
+	rule := ledger.Rule{}
+	if err := json.NewDecoder(req.req.Body).Decode(&rule); err != nil {
+		LogWithID(ctx, "failed to decode rule: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Failed to decode rule")
+		return rule, false
+	}
+	rule.Tag = strings.TrimSpace(rule.Tag)
+	if err := rule.Validate(); err != nil {
+		LogWithID(ctx, "invalid rule: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid rule: %s", err)
+		return rule, false
+	}
+	return rule, checkLinks(w, req, saves, rule.Tag, rule.Expense)
+}
+
+type listRulesResponse struct {
+	Rules []ledger.Rule `json:"rules"`
+}
+
+// listRulesHandler generates an authenticatedRequestHandler listing the user's
+// rules in priority order
+func listRulesHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to list rules")
+
+		userLedger, _, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return
+		}
+		rules := userLedger.Rules
+		if rules == nil {
+			rules = []ledger.Rule{}
+		}
+
+		writeJSON(ctx, w, http.StatusOK, listRulesResponse{rules})
+		LogWithID(ctx, "sent %d rules", len(rules))
+	}
+}
+
+// createRuleHandler generates an authenticatedRequestHandler adding a rule to
+// the user's ledger
+func createRuleHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to create rule")
+
+		rule, ok := decodeRule(w, req, saves)
+		if !ok {
+			return
+		}
+		rule.ID = xid.New().String()
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			return userLedger.SetRule(rule)
+		}) {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusCreated, rule)
+		LogWithID(req.req.Context(), "created rule %s", rule.ID)
+	}
+}
+
+// pathRuleID returns the rule ID from the last element of the path, writing
+// an error response and returning false if there is none
+func pathRuleID(w http.ResponseWriter, req *authenticatedRequest) (string, bool) {
+	id := strings.TrimPrefix(req.req.URL.Path, ledgerRulePath)
+	if len(id) < 1 || strings.Contains(id, "/") {
+		LogWithID(req.req.Context(), "invalid rule id")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "No such rule")
+		return "", false
+	}
+	return id, true
+}
+
+// updateRuleHandler generates an authenticatedRequestHandler replacing the
+// rule whose ID is the last element of the path
+func updateRuleHandler(storer LedgerStorer, saves UserSaveStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to update rule")
+
+		id, ok := pathRuleID(w, req)
+		if !ok {
+			return
+		}
+		rule, ok := decodeRule(w, req, saves)
+		if !ok {
+			return
+		}
+		rule.ID = id
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			if _, err := userLedger.FindRule(id); err != nil {
+				return err
+			}
+			return userLedger.SetRule(rule)
+		}) {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, rule)
+		LogWithID(req.req.Context(), "updated rule %s", id)
+	}
+}
+
+// removeRuleHandler generates an authenticatedRequestHandler removing the rule
+// whose ID is the last element of the path
+func removeRuleHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to remove rule")
+
+		id, ok := pathRuleID(w, req)
+		if !ok {
+			return
+		}
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			return userLedger.RemoveRule(id)
+		}) {
+			return
+		}
+
+		LogWithID(req.req.Context(), "removed rule %s", id)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Removed rule")
+	}
+}
+
+type applyRulesResponse struct {
+	Changes []ledger.RuleChange `json:"changes"`
+}
+
+// previewRulesHandler generates an authenticatedRequestHandler sending the
+// changes the user's rules would make to their transactions, without changing
+// them. Tags and expenses already set are only replaced if the overwrite query
+// parameter is true.
+func previewRulesHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to preview applying rules")
+
+		userLedger, _, err := fetchLedger(ctx, storer, req.userID)
+		if err != nil {
+			LogWithID(ctx, "!! failed to fetch ledger: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to fetch ledger")
+			return
+		}
+		overwrite := req.req.URL.Query().Get("overwrite") == "true"
+		changes, err := userLedger.ApplyRules(time.Now().UTC(), overwrite)
+		if err != nil {
+			LogWithID(ctx, "!! failed to apply rules: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Failed to apply rules")
+			return
+		}
+
+		writeJSON(ctx, w, http.StatusOK, applyRulesResponse{changes})
+		LogWithID(ctx, "rules would change %d transactions", len(changes))
+	}
+}
+
+// applyRulesHandler generates an authenticatedRequestHandler categorising the
+// user's transactions by their rules, as previewRulesHandler previews
+func applyRulesHandler(storer LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		LogWithID(req.req.Context(), "trying to apply rules")
+
+		overwrite := req.req.URL.Query().Get("overwrite") == "true"
+		var changes []ledger.RuleChange
+		if !updateLedger(w, req, storer, func(userLedger *ledger.Ledger) error {
+			var err error
+			changes, err = userLedger.ApplyRules(time.Now().UTC(), overwrite)
+			return err
+		}) {
+			return
+		}
+
+		writeJSON(req.req.Context(), w, http.StatusOK, applyRulesResponse{changes})
+		LogWithID(req.req.Context(), "rules changed %d transactions", len(changes))
+	}
+}
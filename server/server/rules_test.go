@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"py-server/ledger"
+	"testing"
+)
+
+func TestRuleHandlers(t *testing.T) {
+	storer := makeMemoryLedgerStorer()
+	saves := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"schemaVersion": 4, "cycle": "Weekly",
+			"tags": [{"id": "food", "name": "Food"}, {"id": "treats", "name": "Treats", "parent": "food"}],
+			"expenses": [{"name": "Groceries", "amount": {"amount": "150.00", "currency": "NZD"}, "tag": "Food"}]}`),
+	}}
+	coffee := createTestTransaction(t, storer, saves,
+		`{"date": "2021-06-01", "amount": {"amount": "4.50", "currency": "NZD"}, "description": "Coffee Co"}`)
+
+	rr := httptest.NewRecorder()
+	createRuleHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerRulesPath,
+		`{"priority": 1, "description": "coffee", "tag": " Treats "}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+	rule := ledger.Rule{}
+	if err := json.NewDecoder(rr.Body).Decode(&rule); err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.ID) < 1 || rule.Tag != "Treats" {
+		t.Errorf("unexpected rule %+v", rule)
+	}
+
+	tests := []struct {
+		body   string
+		expect int
+	}{
+		{`{`, http.StatusBadRequest},
+		{`{"tag": "Food"}`, http.StatusBadRequest},
+		{`{"pattern": "(", "tag": "Food"}`, http.StatusBadRequest},
+		{`{"description": "coffee", "expense": "Rent"}`, http.StatusUnprocessableEntity},
+		{`{"description": "coffee", "tag": "Snacks"}`, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		createRuleHandler(storer, saves)(rr, makeAuthedRequest(t, "POST", ledgerRulesPath, test.body))
+		if rr.Code != test.expect {
+			t.Errorf("%s: expected status code %d, got %d", test.body, test.expect, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	previewRulesHandler(storer)(rr, makeAuthedRequest(t, "POST", ledgerRulesApplyPreviewPath, ""))
+	preview := applyRulesResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Changes) != 1 || preview.Changes[0].Transaction.ID != coffee.ID || preview.Changes[0].Rule != rule.ID {
+		t.Errorf("expected the coffee to be tagged, got %+v", preview)
+	}
+	userLedger, _, _ := fetchLedger(context.Background(), storer, "some user id")
+	if userLedger.Transactions[0].Tag != "" {
+		t.Errorf("expected preview not to change the ledger, got %+v", userLedger.Transactions[0])
+	}
+
+	rr = httptest.NewRecorder()
+	updateRuleHandler(storer, saves)(rr, makeAuthedRequest(t, "PUT", ledgerRulePath+rule.ID,
+		`{"description": "coffee", "expense": "Groceries"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d updating rule, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	rr = httptest.NewRecorder()
+	applyRulesHandler(storer)(rr, makeAuthedRequest(t, "POST", ledgerRulesApplyPath, ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d applying rules, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	userLedger, _, _ = fetchLedger(context.Background(), storer, "some user id")
+	if userLedger.Transactions[0].Expense != "Groceries" {
+		t.Errorf("expected the coffee to pay for groceries, got %+v", userLedger.Transactions[0])
+	}
+
+	rr = httptest.NewRecorder()
+	listRulesHandler(storer)(rr, makeAuthedRequest(t, "GET", ledgerRulesPath, ""))
+	list := listRulesResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Rules) != 1 || list.Rules[0].Expense != "Groceries" {
+		t.Errorf("expected the updated rule, got %+v", list)
+	}
+
+	for _, expect := range []int{http.StatusOK, http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		removeRuleHandler(storer)(rr, makeAuthedRequest(t, "DELETE", ledgerRulePath+rule.ID, ""))
+		if rr.Code != expect {
+			t.Errorf("expected status code %d removing rule, got %d", expect, rr.Code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	updateRuleHandler(storer, saves)(rr, makeAuthedRequest(t, "PUT", ledgerRulePath+rule.ID,
+		`{"description": "coffee", "tag": "Food"}`))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d updating a removed rule, got %d", http.StatusNotFound, rr.Code)
+	}
+}
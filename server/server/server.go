@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/rs/xid"
 )
@@ -27,18 +29,217 @@ func LogWithID(ctx context.Context, format string, args ...interface{}) {
 type AppRouteHandlers struct {
 	TokenChecker   TokenChecker
 	UserSaveStorer UserSaveStorer
+	// Authorizer restricts who may use the server if set
+	Authorizer Authorizer
+	// RoleResolver grants roles such as admin, nobody holds any roles if unset
+	RoleResolver RoleResolver
+	// AuditSink records every change to users' data if set
+	AuditSink AuditSink
+	// TombstoneStorer enables account deletion if set
+	TombstoneStorer TombstoneStorer
+	// Caches are told to forget users whose accounts are deleted
+	Caches []UserForgetter
+	// AccessTokenManager enables the personal access token routes if set
+	AccessTokenManager AccessTokenManager
+	// LedgerStorer enables the transaction ledger routes if set
+	LedgerStorer LedgerStorer
+}
+
+// authenticated wraps next so it is only called for authenticated and
+// authorized requests
+func (h AppRouteHandlers) authenticated(next authenticatedRequestHandler) http.HandlerFunc {
+	return authenticateRequest(h.TokenChecker, authorizeRequest(h.Authorizer, next))
+}
+
+// withRoles wraps next so it is only called for authenticated requests by
+// users holding one of the roles
+func (h AppRouteHandlers) withRoles(next authenticatedRequestHandler, roles ...Role) http.HandlerFunc {
+	return h.authenticated(requireRoles(h.RoleResolver, roles, next))
 }
 
 func (h AppRouteHandlers) GetHandler(w http.ResponseWriter, req *http.Request) {
-	authenticateRequest(h.TokenChecker, fetchHandler(h.UserSaveStorer))(w, req)
+	h.authenticated(fetchHandler(h.UserSaveStorer))(w, req)
 }
 
 func (h AppRouteHandlers) PostHandler(w http.ResponseWriter, req *http.Request) {
-	authenticateRequest(h.TokenChecker, saveHandler(h.UserSaveStorer))(w, req)
+	h.authenticated(h.auditedSave(saveHandler(h.UserSaveStorer), ActionSaveUserSave))(w, req)
 }
 
 func (h AppRouteHandlers) DeleteHandler(w http.ResponseWriter, req *http.Request) {
-	authenticateRequest(h.TokenChecker, RemoveHandler(h.UserSaveStorer))(w, req)
+	h.authenticated(h.auditedSave(RemoveHandler(h.UserSaveStorer), ActionRemoveUserSave))(w, req)
+}
+
+// auditedSave wraps next so the action is audited with the hashes of the
+// user's save before and after
+func (h AppRouteHandlers) auditedSave(next authenticatedRequestHandler, action string) authenticatedRequestHandler {
+	return audited(h.AuditSink, action, h.UserSaveStorer, next)
+}
+
+// Routes returns the routes served beyond /v1/usersave, depending on which
+// dependencies are available
+func (h AppRouteHandlers) Routes() Routes {
+	routes := Routes{
+		"/v1/me": {
+			http.MethodGet: h.authenticated(meHandler(h.RoleResolver)),
+		},
+		"/v1/account/export": {
+			http.MethodGet: h.authenticated(exportHandler(h.exportParts())),
+		},
+		"/v1/usersave/summary": {
+			http.MethodGet: h.authenticated(summaryHandler(h.UserSaveStorer)),
+		},
+		"/v1/usersave/projections": {
+			http.MethodGet: h.authenticated(projectionsHandler(h.UserSaveStorer)),
+		},
+		"/v1/usersave/paydays": {
+			http.MethodGet: h.authenticated(paydaysHandler(h.UserSaveStorer)),
+		},
+		"/v1/usersave/forecast": {
+			http.MethodGet: h.authenticated(forecastHandler(h.UserSaveStorer)),
+		},
+		"/v1/usersave/simulate": {
+			http.MethodPost: h.authenticated(simulateHandler(h.UserSaveStorer)),
+		},
+		"/v1/usersave/allocation": {
+			http.MethodGet:  h.authenticated(previewAllocationHandler(h.UserSaveStorer)),
+			http.MethodPost: h.authenticated(h.auditedSave(applyAllocationHandler(h.UserSaveStorer), ActionAllocateUserSave)),
+		},
+		"/v1/usersave/tags/rename": {
+			http.MethodPost: h.authenticated(h.auditedSave(renameTagHandler(h.UserSaveStorer, h.LedgerStorer), ActionRenameTag)),
+		},
+		adminSavePath: {
+			http.MethodGet: h.withRoles(asPathUser(adminSavePath, fetchHandler(h.UserSaveStorer)),
+				RoleAdmin, RoleSupport),
+			http.MethodPut: h.withRoles(requireReason(asPathUser(adminSavePath,
+				h.auditedSave(saveHandler(h.UserSaveStorer), ActionSaveUserSave))),
+				RoleAdmin),
+			http.MethodDelete: h.withRoles(requireReason(asPathUser(adminSavePath,
+				h.auditedSave(RemoveHandler(h.UserSaveStorer), ActionRemoveUserSave))),
+				RoleAdmin),
+		},
+	}
+	if lister, ok := h.UserSaveStorer.(UserSaveLister); ok {
+		routes[adminSavesPath] = MethodHandlers{
+			http.MethodGet: h.withRoles(listSavesHandler(lister), RoleAdmin, RoleSupport),
+		}
+		routes["/admin/v1/stats"] = MethodHandlers{
+			http.MethodGet: h.withRoles(statsHandler(lister), RoleAdmin, RoleSupport),
+		}
+	}
+	if stater, ok := h.UserSaveStorer.(UserSaveStater); ok {
+		routes[adminMetadataPath] = MethodHandlers{
+			http.MethodGet: h.withRoles(asPathUser(adminMetadataPath, statSaveHandler(stater)),
+				RoleAdmin, RoleSupport),
+		}
+	}
+
+	if h.AccessTokenManager != nil {
+		routes["/v1/tokens"] = MethodHandlers{
+			http.MethodGet: h.authenticated(listAccessTokensHandler(h.AccessTokenManager)),
+			http.MethodPost: h.authenticated(audited(h.AuditSink, ActionCreateAccessToken, nil,
+				createAccessTokenHandler(h.AccessTokenManager))),
+		}
+		routes["/v1/tokens/"] = MethodHandlers{
+			http.MethodDelete: h.authenticated(audited(h.AuditSink, ActionRevokeAccessToken, nil,
+				revokeAccessTokenHandler(h.AccessTokenManager))),
+		}
+	}
+	if h.LedgerStorer != nil {
+		routes[ledgerTransactionsPath] = MethodHandlers{
+			http.MethodGet: h.authenticated(listTransactionsHandler(h.LedgerStorer)),
+			http.MethodPost: h.authenticated(audited(h.AuditSink, ActionCreateTransaction, nil,
+				createTransactionHandler(h.LedgerStorer, h.UserSaveStorer))),
+		}
+		routes[ledgerTransactionPath] = MethodHandlers{
+			http.MethodGet: h.authenticated(fetchTransactionHandler(h.LedgerStorer)),
+			http.MethodPut: h.authenticated(audited(h.AuditSink, ActionUpdateTransaction, nil,
+				updateTransactionHandler(h.LedgerStorer, h.UserSaveStorer))),
+			http.MethodDelete: h.authenticated(audited(h.AuditSink, ActionRemoveTransaction, nil,
+				removeTransactionHandler(h.LedgerStorer))),
+		}
+		routes["/v1/ledger/report"] = MethodHandlers{
+			http.MethodGet: h.authenticated(ledgerReportHandler(h.LedgerStorer, h.UserSaveStorer)),
+		}
+		routes[ledgerImportPreviewPath] = MethodHandlers{
+			http.MethodPost: h.authenticated(previewImportHandler(h.LedgerStorer, h.UserSaveStorer)),
+		}
+		routes[ledgerImportPath] = MethodHandlers{
+			http.MethodPost: h.authenticated(audited(h.AuditSink, ActionImportTransactions, nil,
+				importHandler(h.LedgerStorer, h.UserSaveStorer))),
+		}
+		routes[ledgerRulesPath] = MethodHandlers{
+			http.MethodGet: h.authenticated(listRulesHandler(h.LedgerStorer)),
+			http.MethodPost: h.authenticated(audited(h.AuditSink, ActionCreateRule, nil,
+				createRuleHandler(h.LedgerStorer, h.UserSaveStorer))),
+		}
+		routes[ledgerRulePath] = MethodHandlers{
+			http.MethodPut: h.authenticated(audited(h.AuditSink, ActionUpdateRule, nil,
+				updateRuleHandler(h.LedgerStorer, h.UserSaveStorer))),
+			http.MethodDelete: h.authenticated(audited(h.AuditSink, ActionRemoveRule, nil,
+				removeRuleHandler(h.LedgerStorer))),
+		}
+		routes[ledgerRulesApplyPreviewPath] = MethodHandlers{
+			http.MethodPost: h.authenticated(previewRulesHandler(h.LedgerStorer)),
+		}
+		routes[ledgerRulesApplyPath] = MethodHandlers{
+			http.MethodPost: h.authenticated(audited(h.AuditSink, ActionApplyRules, nil,
+				applyRulesHandler(h.LedgerStorer))),
+		}
+	}
+	if h.TombstoneStorer != nil {
+		routes["/v1/account"] = MethodHandlers{
+			http.MethodDelete: h.authenticated(audited(h.AuditSink, ActionDeleteAccount, nil,
+				deleteAccountHandler(h.TombstoneStorer, h.erasureSteps()))),
+		}
+	}
+	if h.AuditSink != nil {
+		routes["/v1/audit"] = MethodHandlers{
+			http.MethodGet: h.authenticated(auditHandler(h.AuditSink)),
+		}
+		routes[adminAuditPath] = MethodHandlers{
+			http.MethodGet: h.withRoles(asPathUser(adminAuditPath, auditHandler(h.AuditSink)),
+				RoleAdmin, RoleSupport),
+		}
+	}
+	return routes
+}
+
+// exportParts returns the parts of a user's export, depending on which
+// dependencies are available
+func (h AppRouteHandlers) exportParts() []exportPart {
+	parts := []exportPart{userSaveExport(h.UserSaveStorer)}
+	if versioner, ok := h.UserSaveStorer.(UserSaveVersioner); ok {
+		parts = append(parts, versionsExport(versioner))
+	}
+	if h.AuditSink != nil {
+		parts = append(parts, auditExport(h.AuditSink))
+	}
+	if h.AccessTokenManager != nil {
+		parts = append(parts, accessTokensExport(h.AccessTokenManager))
+	}
+	if h.LedgerStorer != nil {
+		parts = append(parts, ledgerExport(h.LedgerStorer))
+	}
+	return parts
+}
+
+// erasureSteps returns the steps erasing a user's account, depending on which
+// dependencies are available. The audit trail is erased last, so it covers
+// everything up to the erasure.
+func (h AppRouteHandlers) erasureSteps() []erasureStep {
+	steps := []erasureStep{}
+	if h.AccessTokenManager != nil {
+		steps = append(steps, accessTokensErasure(h.AccessTokenManager))
+	}
+	steps = append(steps, userSaveErasure(h.UserSaveStorer))
+	if h.LedgerStorer != nil {
+		steps = append(steps, ledgerErasure(h.LedgerStorer))
+	}
+	steps = append(steps, cachesErasure(h.Caches))
+	if eraser, ok := h.AuditSink.(AuditEraser); ok {
+		steps = append(steps, auditErasure(eraser))
+	}
+	return steps
 }
 
 // RouterHandlers are the possible handlers for the Router
@@ -48,11 +249,66 @@ type RouterHandlers interface {
 	DeleteHandler(w http.ResponseWriter, req *http.Request)
 }
 
+// MethodHandlers maps HTTP methods to the handler serving them
+type MethodHandlers map[string]http.HandlerFunc
+
+// allowed returns the comma separated methods for CORS preflight responses
+func (m MethodHandlers) allowed() string {
+	methods := make([]string, 0, len(m))
+	for method := range m {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ",")
+}
+
+// Routes maps request paths to the handlers serving them. Paths ending in a
+// slash match anything beneath them, with the longest such path winning.
+type Routes map[string]MethodHandlers
+
+func (r Routes) match(path string) (MethodHandlers, bool) {
+	if handlers, ok := r[path]; ok && !strings.HasSuffix(path, "/") {
+		return handlers, true
+	}
+	longest := ""
+	for prefix := range r {
+		if !strings.HasSuffix(prefix, "/") || len(prefix) <= len(longest) {
+			continue
+		}
+		if len(path) > len(prefix) && strings.HasPrefix(path, prefix) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return nil, false
+	}
+	return r[longest], true
+}
+
+// RouteProvider may be implemented by RouterHandlers serving routes beyond
+// /v1/usersave
+type RouteProvider interface {
+	Routes() Routes
+}
+
 // Route takes a set of RouteHandlers and routes a request to the appropriate handler
 func Route(handler RouterHandlers, allowedOrigin string) http.HandlerFunc {
+	routes := Routes{
+		"/v1/usersave": {
+			http.MethodGet:    handler.GetHandler,
+			http.MethodPost:   handler.PostHandler,
+			http.MethodDelete: handler.DeleteHandler,
+		},
+	}
+	if provider, ok := handler.(RouteProvider); ok {
+		for path, handlers := range provider.Routes() {
+			routes[path] = handlers
+		}
+	}
+
 	return func(w http.ResponseWriter, req *http.Request) {
-		path := req.URL.Path
-		if path != "/v1/usersave" {
+		handlers, ok := routes.match(req.URL.Path)
+		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprintf(w, "not found")
 			LogWithID(req.Context(), "served 404, not found")
@@ -60,24 +316,23 @@ func Route(handler RouterHandlers, allowedOrigin string) http.HandlerFunc {
 		}
 		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 
-		switch req.Method {
-		case http.MethodOptions:
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,DELETE")
+		if req.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", handlers.allowed())
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Token")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 			w.WriteHeader(http.StatusNoContent)
 			LogWithID(req.Context(), "served CORS options")
-		case http.MethodGet:
-			handler.GetHandler(w, req)
-		case http.MethodPost:
-			handler.PostHandler(w, req)
-		case http.MethodDelete:
-			handler.DeleteHandler(w, req)
-		default:
+			return
+		}
+
+		methodHandler, ok := handlers[req.Method]
+		if !ok {
 			LogWithID(req.Context(), "invalid method used")
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			fmt.Fprintf(w, "invalid method")
+			return
 		}
+		methodHandler(w, req)
 	}
 }
 
@@ -87,6 +87,49 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+// teapot handler which also provides extra routes
+type teapotRouteProvider struct {
+	teapotHandler
+}
+
+func (h teapotRouteProvider) Routes() Routes {
+	return Routes{
+		"/v1/tokens": {
+			http.MethodGet: h.GetHandler,
+		},
+		"/v1/tokens/": {
+			http.MethodDelete: h.DeleteHandler,
+		},
+	}
+}
+
+// test that routes from a RouteProvider are served, matching subtrees by prefix
+func TestRouterProvider(t *testing.T) {
+	router := Route(teapotRouteProvider{}, "*")
+
+	tests := []struct {
+		method string
+		route  string
+		expect int
+	}{
+		{http.MethodGet, "https://example.com/v1/usersave", http.StatusTeapot},
+		{http.MethodGet, "https://example.com/v1/tokens", http.StatusTeapot},
+		{http.MethodPost, "https://example.com/v1/tokens", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "https://example.com/v1/tokens/abc", http.StatusTeapot},
+		{http.MethodDelete, "https://example.com/v1/tokens/", http.StatusNotFound},
+		{http.MethodDelete, "https://example.com/v1/tokensabc", http.StatusNotFound},
+		{http.MethodOptions, "https://example.com/v1/tokens", http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.route, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		t.Run(test.method+" "+test.route, StatusCodeTest(req, test.expect, router))
+	}
+}
+
 // test the server cycles up and down correctly
 func TestServe(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {}
@@ -110,7 +153,7 @@ func TestServe(t *testing.T) {
 			cancel()
 			err := <-shutdown
 			if err != nil {
-				t.Error("got err on graceful shutdown: %w", err)
+				t.Errorf("got err on graceful shutdown: %s", err)
 			}
 		})
 	}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"py-server/ledger"
+	"py-server/usersave"
+	"strings"
+	"time"
+)
+
+type renameTagRequest struct {
+	ID   string       `json:"id"`
+	Name usersave.Tag `json:"name"`
+}
+
+type renameTagResponse struct {
+	Tag          usersave.TagDefinition `json:"tag"`
+	PreviousName usersave.Tag           `json:"previousName"`
+	// Transactions counts the transactions in the ledger renamed
+	Transactions int `json:"transactions"`
+}
+
+// renameTagHandler generates an authenticatedRequestHandler renaming a tag in
+// the user's catalogue, and the expenses, and if there is a ledger the
+// transactions and rules, with it
+func renameTagHandler(storer UserSaveStorer, ledgers LedgerStorer) authenticatedRequestHandler {
+	return func(w http.ResponseWriter, req *authenticatedRequest) {
+		ctx := req.req.Context()
+		LogWithID(ctx, "trying to rename tag")
+
+		body := renameTagRequest{}
+		if err := json.NewDecoder(req.req.Body).Decode(&body); err != nil {
+			LogWithID(ctx, "failed to decode rename request: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Failed to decode rename request")
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+
+		// the ledger is renamed first, so if storing the save fails renaming
+		// again finishes the job. The save is renamed again if another
+		// request changes it meanwhile.
+		response := renameTagResponse{}
+		userSave, ok := updateUserSave(w, req, storer, func(userSave *usersave.JSONUserSave) bool {
+			previous, err := userSave.RenameTag(body.ID, body.Name)
+			if errors.Is(err, usersave.ErrNoTag) {
+				LogWithID(ctx, "no tag %q", body.ID)
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "No such tag")
+				return false
+			}
+			if err != nil {
+				LogWithID(ctx, "can't rename tag: %s", err)
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprintf(w, "Can't rename tag: %s", err)
+				return false
+			}
+
+			response.PreviousName = previous
+			if ledgers == nil || previous == body.Name {
+				return true
+			}
+			return updateLedger(w, req, ledgers, func(userLedger *ledger.Ledger) error {
+				response.Transactions += userLedger.RenameTag(previous, body.Name, time.Now().UTC())
+				return nil
+			})
+		})
+		if !ok {
+			return
+		}
+
+		i, _ := userSave.FindTag(body.ID)
+		response.Tag = userSave.Tags[i]
+		writeJSON(ctx, w, http.StatusOK, response)
+		LogWithID(ctx, "renamed tag %s", body.ID)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"py-server/usersave"
+	"strings"
+	"testing"
+)
+
+func TestRenameTagHandler(t *testing.T) {
+	storer := makeMemoryLedgerStorer()
+	saves := &memoryUserSaveStorer{saves: map[string][]byte{
+		"some user id": []byte(`{"cycle": "Weekly", "expenses": [` +
+			`{"name": "Groceries", "amount": 15000, "tag": "Fod"}, {"name": "Movies", "amount": 2000, "tag": "Fun"}]}`),
+	}}
+	createTestTransaction(t, storer, saves,
+		`{"date": "2021-06-01", "amount": {"amount": "4.50", "currency": "NZD"}, "tag": "Fod"}`)
+	createTestTransaction(t, storer, saves,
+		`{"date": "2021-06-02", "amount": {"amount": "12.00", "currency": "NZD"}, "tag": "Fun"}`)
+
+	rr := httptest.NewRecorder()
+	renameTagHandler(saves, storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/tags/rename",
+		`{"id": "fod", "name": " Food "}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	response := renameTagResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Tag.ID != "fod" || response.Tag.Name != "Food" || response.PreviousName != "Fod" || response.Transactions != 1 {
+		t.Errorf("unexpected rename response %+v", response)
+	}
+	if saved := string(saves.saves["some user id"]); !strings.Contains(saved, `"tag":"Food"`) || strings.Contains(saved, "Fod") {
+		t.Errorf("expected the groceries to be renamed, got %s", saved)
+	}
+	userLedger, _, _ := fetchLedger(context.Background(), storer, "some user id")
+	for _, transaction := range userLedger.Transactions {
+		if transaction.Tag != "Food" && transaction.Tag != "Fun" {
+			t.Errorf("expected the transaction to be renamed, got %+v", transaction)
+		}
+	}
+
+	// an expense added by another request while renaming is kept, and renamed
+	saves.beforeVersionedSave = func(userID string) {
+		saves.beforeVersionedSave = nil
+		userSave, err := usersave.DecodeUserSave(bytes.NewReader(saves.saves[userID]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		userSave.Expenses = append(userSave.Expenses, usersave.JSONExpense{
+			Name: "Arcade", Amount: usersave.Money{Minor: 1000, Currency: "NZD"}, Tag: "Fun",
+		})
+		encoded := bytes.Buffer{}
+		if err := usersave.EncodeUserSave(userSave, &encoded); err != nil {
+			t.Fatal(err)
+		}
+		saves.store(userID, encoded.Bytes())
+	}
+	rr = httptest.NewRecorder()
+	renameTagHandler(saves, storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/tags/rename",
+		`{"id": "fun", "name": "Play"}`))
+	saved := string(saves.saves["some user id"])
+	if rr.Code != http.StatusOK || !strings.Contains(saved, "Arcade") || strings.Contains(saved, `"Fun"`) {
+		t.Errorf("expected the concurrent change to be kept and renamed, got %d %s", rr.Code, saved)
+	}
+
+	tests := []struct {
+		body   string
+		expect int
+	}{
+		{`{`, http.StatusBadRequest},
+		{`{"id": "nope", "name": "Food"}`, http.StatusNotFound},
+		{`{"id": "fod", "name": "Play"}`, http.StatusUnprocessableEntity},
+		{`{"id": "fod", "name": " "}`, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		renameTagHandler(saves, storer)(rr, makeAuthedRequest(t, "POST", "/v1/usersave/tags/rename", test.body))
+		if rr.Code != test.expect {
+			t.Errorf("%s: expected status code %d, got %d", test.body, test.expect, rr.Code)
+		}
+	}
+}
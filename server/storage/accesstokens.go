@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"py-server/server"
+	"py-server/token"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Access token records are stored by hash, with an empty object per token
+// beneath the user's prefix so a user's tokens can be listed.
+const (
+	accessTokenPrefix     = "accesstokens/"
+	userAccessTokenPrefix = "accesstokens-by-user/"
+)
+
+func userAccessTokenObject(userID string, hash string) string {
+	return userAccessTokenPrefix + userID + "/" + hash
+}
+
+func (gs GoogleStorer) SaveAccessToken(ctx context.Context, record token.AccessTokenRecord) error {
+	writer := gs.bucket.Object(accessTokenPrefix + record.Hash).NewWriter(ctx)
+	writer.ObjectAttrs.ContentType = "application/json"
+	if err := json.NewEncoder(writer).Encode(record); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return gs.bucket.Object(userAccessTokenObject(record.UserID, record.Hash)).NewWriter(ctx).Close()
+}
+
+func (gs GoogleStorer) FetchAccessToken(ctx context.Context, hash string) (token.AccessTokenRecord, error) {
+	record := token.AccessTokenRecord{}
+	reader, err := gs.bucket.Object(accessTokenPrefix + hash).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return record, server.ErrNoAccessToken
+		}
+		return record, err
+	}
+	defer reader.Close()
+
+	err = json.NewDecoder(reader).Decode(&record)
+	return record, err
+}
+
+func (gs GoogleStorer) FetchUserAccessTokens(ctx context.Context, userID string) ([]token.AccessTokenRecord, error) {
+	records := []token.AccessTokenRecord{}
+	it := gs.bucket.Objects(ctx, &storage.Query{Prefix: userAccessTokenPrefix + userID + "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := gs.FetchAccessToken(ctx, path.Base(attrs.Name))
+		if err != nil {
+			if errors.Is(err, server.ErrNoAccessToken) {
+				continue
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// deleteGenerations deletes every generation of the object, returning whether
+// it had a live one
+func (gs GoogleStorer) deleteGenerations(ctx context.Context, name string) (bool, error) {
+	live := false
+	it := gs.bucket.Objects(ctx, &storage.Query{Prefix: name, Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return live, nil
+		}
+		if err != nil {
+			return live, err
+		}
+		// the prefix also matches longer names
+		if attrs.Name != name {
+			continue
+		}
+		err = gs.bucket.Object(name).Generation(attrs.Generation).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return live, err
+		}
+		if attrs.Deleted.IsZero() {
+			live = true
+		}
+	}
+}
+
+// RemoveAccessToken deletes every generation of the token's record and its
+// entry beneath the user's prefix, so none are kept as older versions
+func (gs GoogleStorer) RemoveAccessToken(ctx context.Context, record token.AccessTokenRecord) error {
+	live, err := gs.deleteGenerations(ctx, accessTokenPrefix+record.Hash)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return server.ErrNoAccessToken
+	}
+
+	_, err = gs.deleteGenerations(ctx, userAccessTokenObject(record.UserID, record.Hash))
+	return err
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"py-server/server"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Audit entries are stored one object each beneath the user's prefix, named
+// so they list newest first, then by ID.
+const auditPrefix = "audit/"
+
+func userAuditPrefix(userID string) string {
+	return auditPrefix + userID + "/"
+}
+
+// GoogleAuditSink is an AuditSink keeping entries in the storer's bucket
+type GoogleAuditSink struct {
+	bucket *storage.BucketHandle
+}
+
+// AuditSink returns an AuditSink using the storer's bucket
+func (gs GoogleStorer) AuditSink() GoogleAuditSink {
+	return GoogleAuditSink{gs.bucket}
+}
+
+// auditKey inverts the time so later entries sort first
+func auditKey(nanos int64) string {
+	return fmt.Sprintf("%019d", math.MaxInt64-nanos)
+}
+
+// auditName names the entry's object
+func auditName(entry server.AuditEntry) string {
+	return userAuditPrefix(entry.UserID) + auditKey(entry.Time.UnixNano()) + "-" + entry.ID
+}
+
+func (s GoogleAuditSink) Record(ctx context.Context, entry server.AuditEntry) error {
+	name := auditName(entry)
+	writer := s.bucket.Object(name).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	writer.ObjectAttrs.ContentType = "application/json"
+	if err := json.NewEncoder(writer).Encode(entry); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func (s GoogleAuditSink) Query(ctx context.Context, query server.AuditQuery) ([]server.AuditEntry, error) {
+	prefix := userAuditPrefix(query.UserID)
+	bucketQuery := &storage.Query{Prefix: prefix}
+	switch {
+	case !query.Before.IsZero() && len(query.BeforeID) > 0:
+		// just after the name of the entry the page starts after
+		bucketQuery.StartOffset = auditName(server.AuditEntry{
+			ID: query.BeforeID, Time: query.Before, UserID: query.UserID,
+		}) + "\x00"
+	case !query.Before.IsZero():
+		bucketQuery.StartOffset = prefix + auditKey(query.Before.UnixNano()-1)
+	}
+
+	entries := []server.AuditEntry{}
+	it := s.bucket.Objects(ctx, bucketQuery)
+	for query.Limit < 1 || len(entries) < query.Limit {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := s.fetchAuditEntry(ctx, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s GoogleAuditSink) fetchAuditEntry(ctx context.Context, name string) (server.AuditEntry, error) {
+	entry := server.AuditEntry{}
+	reader, err := s.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return entry, err
+	}
+	defer reader.Close()
+
+	err = json.NewDecoder(reader).Decode(&entry)
+	return entry, err
+}
+
+// EraseAudit deletes every generation of every entry beneath the user's prefix
+func (s GoogleAuditSink) EraseAudit(ctx context.Context, userID string) error {
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: userAuditPrefix(userID), Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = s.bucket.Object(attrs.Name).Generation(attrs.Generation).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+}
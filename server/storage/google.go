@@ -2,11 +2,15 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"io"
+	"net/http"
 	"py-server/server"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 )
 
 // GoogleStorer is a UserSaveStorer which uses Google Cloud storage
@@ -33,6 +37,42 @@ func (gs GoogleStorer) Save(ctx context.Context, userID string) (io.WriteCloser,
 	return writer, nil
 }
 
+// FetchVersion returns a reader for the user's save, its generation being its
+// version
+func (gs GoogleStorer) FetchVersion(ctx context.Context, userID string) (io.ReadCloser, int64, error) {
+	reader, err := gs.bucket.Object(userID).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, 0, server.ErrNoUserSave
+		}
+		return nil, 0, err
+	}
+	return reader, reader.Attrs.Generation, nil
+}
+
+// versionWriter reports a failed generation precondition as the save having
+// changed
+type versionWriter struct {
+	*storage.Writer
+}
+
+func (w versionWriter) Close() error {
+	err := w.Writer.Close()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return server.ErrUserSaveChanged
+	}
+	return err
+}
+
+// SaveVersion returns a writer for the user's save on condition its
+// generation still matches the version
+func (gs GoogleStorer) SaveVersion(ctx context.Context, userID string, version int64) (io.WriteCloser, error) {
+	writer := gs.bucket.Object(userID).If(storage.Conditions{GenerationMatch: version}).NewWriter(ctx)
+	writer.ObjectAttrs.ContentType = "application/json"
+	return versionWriter{writer}, nil
+}
+
 func (gs GoogleStorer) Remove(ctx context.Context, userID string) error {
 	err := gs.bucket.Object(userID).Delete(ctx)
 	if err != nil {
@@ -45,6 +85,95 @@ func (gs GoogleStorer) Remove(ctx context.Context, userID string) error {
 	return nil
 }
 
+func userSaveInfo(attrs *storage.ObjectAttrs) server.UserSaveInfo {
+	return server.UserSaveInfo{
+		UserID:      attrs.Name,
+		Size:        attrs.Size,
+		Updated:     attrs.Updated,
+		Generation:  attrs.Generation,
+		ContentHash: base64.StdEncoding.EncodeToString(attrs.MD5),
+	}
+}
+
+// ListUserSaves lists a page of the objects at the top level of the bucket,
+// where the saves are kept. Anything else in the bucket is kept beneath a
+// prefix.
+func (gs GoogleStorer) ListUserSaves(ctx context.Context, pageToken string, pageSize int) ([]server.UserSaveInfo, string, error) {
+	it := gs.bucket.Objects(ctx, &storage.Query{Delimiter: "/"})
+	page := []*storage.ObjectAttrs{}
+	next, err := iterator.NewPager(it, pageSize, pageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	saves := make([]server.UserSaveInfo, 0, len(page))
+	for _, attrs := range page {
+		if len(attrs.Prefix) > 0 {
+			continue
+		}
+		saves = append(saves, userSaveInfo(attrs))
+	}
+	return saves, next, nil
+}
+
+func (gs GoogleStorer) StatUserSave(ctx context.Context, userID string) (server.UserSaveInfo, error) {
+	attrs, err := gs.bucket.Object(userID).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return server.UserSaveInfo{}, server.ErrNoUserSave
+		}
+		return server.UserSaveInfo{}, err
+	}
+	return userSaveInfo(attrs), nil
+}
+
+// ListUserSaveVersions lists every generation of the user's save. Previous
+// generations are only kept if the bucket has object versioning enabled.
+func (gs GoogleStorer) ListUserSaveVersions(ctx context.Context, userID string) ([]server.UserSaveInfo, error) {
+	versions := []server.UserSaveInfo{}
+	it := gs.bucket.Objects(ctx, &storage.Query{Prefix: userID, Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the prefix also matches longer IDs
+		if attrs.Name != userID {
+			continue
+		}
+		versions = append(versions, userSaveInfo(attrs))
+	}
+}
+
+func (gs GoogleStorer) FetchUserSaveVersion(ctx context.Context, userID string, generation int64) (io.ReadCloser, error) {
+	reader, err := gs.bucket.Object(userID).Generation(generation).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, server.ErrNoUserSave
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+// EraseUserSave deletes every generation of the user's save
+func (gs GoogleStorer) EraseUserSave(ctx context.Context, userID string) error {
+	versions, err := gs.ListUserSaveVersions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		err := gs.bucket.Object(userID).Generation(version.Generation).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (gs GoogleStorer) Close() error {
 	return gs.client.Close()
 }
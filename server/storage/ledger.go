@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"py-server/ledger"
+	"py-server/server"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// Ledgers are stored one object each beneath a prefix, named by user ID. The
+// object's generation is the ledger's version.
+const ledgerPrefix = "ledgers/"
+
+func (gs GoogleStorer) FetchLedger(ctx context.Context, userID string) (*ledger.Ledger, int64, error) {
+	reader, err := gs.bucket.Object(ledgerPrefix + userID).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, 0, server.ErrNoLedger
+		}
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	userLedger := &ledger.Ledger{}
+	if err := json.NewDecoder(reader).Decode(userLedger); err != nil {
+		return nil, 0, err
+	}
+	return userLedger, reader.Attrs.Generation, nil
+}
+
+// SaveLedger writes the ledger on condition its generation still matches the
+// version, or it doesn't exist for version 0
+func (gs GoogleStorer) SaveLedger(ctx context.Context, userID string, userLedger *ledger.Ledger, version int64) error {
+	conditions := storage.Conditions{GenerationMatch: version}
+	if version == 0 {
+		conditions = storage.Conditions{DoesNotExist: true}
+	}
+	writer := gs.bucket.Object(ledgerPrefix + userID).If(conditions).NewWriter(ctx)
+	writer.ObjectAttrs.ContentType = "application/json"
+	if err := json.NewEncoder(writer).Encode(userLedger); err != nil {
+		writer.Close()
+		return err
+	}
+
+	err := writer.Close()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return server.ErrLedgerChanged
+	}
+	return err
+}
+
+// EraseLedger deletes every generation of the user's ledger
+func (gs GoogleStorer) EraseLedger(ctx context.Context, userID string) error {
+	name := ledgerPrefix + userID
+	it := gs.bucket.Objects(ctx, &storage.Query{Prefix: name, Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the prefix also matches longer IDs
+		if attrs.Name != name {
+			continue
+		}
+		err = gs.bucket.Object(name).Generation(attrs.Generation).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+}
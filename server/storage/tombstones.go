@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"py-server/server"
+
+	"cloud.google.com/go/storage"
+)
+
+// Tombstones are stored beneath a prefix, named by the user hash
+const tombstonesPrefix = "tombstones/"
+
+func (gs GoogleStorer) FetchTombstone(ctx context.Context, userHash string) (server.Tombstone, error) {
+	tombstone := server.Tombstone{}
+	reader, err := gs.bucket.Object(tombstonesPrefix + userHash).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return tombstone, server.ErrNoTombstone
+		}
+		return tombstone, err
+	}
+	defer reader.Close()
+
+	err = json.NewDecoder(reader).Decode(&tombstone)
+	return tombstone, err
+}
+
+func (gs GoogleStorer) SaveTombstone(ctx context.Context, tombstone server.Tombstone) error {
+	writer := gs.bucket.Object(tombstonesPrefix + tombstone.UserHash).NewWriter(ctx)
+	writer.ObjectAttrs.ContentType = "application/json"
+	if err := json.NewEncoder(writer).Encode(tombstone); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,170 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"py-server/server"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// AccessTokenPrefix begins every personal access token, so they can be told
+// apart from other tokens without a lookup
+const AccessTokenPrefix = "pyt_"
+
+// lastUsedResolution limits how often a token's last used time is written
+const lastUsedResolution = time.Minute
+
+// AccessTokenRecord is a stored personal access token. Only a hash of the
+// token is stored.
+type AccessTokenRecord struct {
+	server.AccessToken
+	UserID string `json:"userId"`
+	Hash   string `json:"hash"`
+	// the owner's claims when the token was created
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"emailVerified,omitempty"`
+	HostedDomain  string `json:"hostedDomain,omitempty"`
+}
+
+// AccessTokenStore defines methods for persisting AccessTokenRecords
+type AccessTokenStore interface {
+	// SaveAccessToken should create or overwrite the record
+	SaveAccessToken(ctx context.Context, record AccessTokenRecord) error
+	// FetchAccessToken should return server.ErrNoAccessToken if there is no
+	// record with the given hash
+	FetchAccessToken(ctx context.Context, hash string) (AccessTokenRecord, error)
+	// FetchUserAccessTokens returns all records belonging to the UserID
+	FetchUserAccessTokens(ctx context.Context, userID string) ([]AccessTokenRecord, error)
+	// RemoveAccessToken should return server.ErrNoAccessToken if the record
+	// does not exist
+	RemoveAccessToken(ctx context.Context, record AccessTokenRecord) error
+}
+
+// AccessTokenChecker is a TokenChecker and AccessTokenManager for personal
+// access tokens held in an AccessTokenStore.
+type AccessTokenChecker struct {
+	store AccessTokenStore
+	now   func() time.Time
+}
+
+// HashAccessToken returns the hash under which a token is stored
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenIsValid checks the token exists in the store
+func (c AccessTokenChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	identity, ok := c.TokenIdentity(ctx, token)
+	return identity.UserID, ok
+}
+
+// TokenIdentity checks the token exists in the store, recording that it was
+// used and returning the owner's identity limited to the token's scope.
+func (c AccessTokenChecker) TokenIdentity(ctx context.Context, token string) (server.Identity, bool) {
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		return server.Identity{}, false
+	}
+
+	record, err := c.store.FetchAccessToken(ctx, HashAccessToken(token))
+	if err != nil {
+		if !errors.Is(err, server.ErrNoAccessToken) {
+			log.Printf("!! failed to fetch access token: %s", err)
+		}
+		return server.Identity{}, false
+	}
+
+	now := c.now()
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= lastUsedResolution {
+		record.LastUsedAt = &now
+		if err := c.store.SaveAccessToken(ctx, record); err != nil {
+			log.Printf("!! failed to record access token %s use: %s", record.ID, err)
+		}
+	}
+
+	return server.Identity{
+		UserID:        record.UserID,
+		ReadOnly:      record.Scope != server.ScopeReadWrite,
+		AccessTokenID: record.ID,
+		Email:         record.Email,
+		EmailVerified: record.EmailVerified,
+		HostedDomain:  record.HostedDomain,
+	}, true
+}
+
+// CreateAccessToken generates and stores a new random token
+func (c AccessTokenChecker) CreateAccessToken(ctx context.Context, owner server.Identity, name string, scope server.AccessTokenScope) (string, server.AccessToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", server.AccessToken{}, fmt.Errorf("failed to generate access token: %w", err)
+	}
+	token := AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	record := AccessTokenRecord{
+		AccessToken: server.AccessToken{
+			ID:        xid.New().String(),
+			Name:      name,
+			Scope:     scope,
+			CreatedAt: c.now(),
+		},
+		UserID:        owner.UserID,
+		Hash:          HashAccessToken(token),
+		Email:         owner.Email,
+		EmailVerified: owner.EmailVerified,
+		HostedDomain:  owner.HostedDomain,
+	}
+	if err := c.store.SaveAccessToken(ctx, record); err != nil {
+		return "", server.AccessToken{}, fmt.Errorf("failed to save access token: %w", err)
+	}
+	return token, record.AccessToken, nil
+}
+
+// ListAccessTokens returns the user's tokens, oldest first
+func (c AccessTokenChecker) ListAccessTokens(ctx context.Context, userID string) ([]server.AccessToken, error) {
+	records, err := c.store.FetchUserAccessTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]server.AccessToken, 0, len(records))
+	for _, record := range records {
+		tokens = append(tokens, record.AccessToken)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+// RevokeAccessToken removes the user's token with the given ID
+func (c AccessTokenChecker) RevokeAccessToken(ctx context.Context, userID string, tokenID string) error {
+	records, err := c.store.FetchUserAccessTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.ID == tokenID {
+			return c.store.RemoveAccessToken(ctx, record)
+		}
+	}
+	return server.ErrNoAccessToken
+}
+
+// MakeAccessTokenChecker returns a new AccessTokenChecker using the store
+func MakeAccessTokenChecker(store AccessTokenStore) AccessTokenChecker {
+	return AccessTokenChecker{
+		store: store,
+		now:   time.Now,
+	}
+}
@@ -0,0 +1,135 @@
+package token
+
+import (
+	"context"
+	"py-server/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+// in memory AccessTokenStore
+type testAccessTokenStore struct {
+	records map[string]AccessTokenRecord
+	saves   int
+}
+
+func (s *testAccessTokenStore) SaveAccessToken(ctx context.Context, record AccessTokenRecord) error {
+	s.records[record.Hash] = record
+	s.saves++
+	return nil
+}
+
+func (s *testAccessTokenStore) FetchAccessToken(ctx context.Context, hash string) (AccessTokenRecord, error) {
+	record, ok := s.records[hash]
+	if !ok {
+		return record, server.ErrNoAccessToken
+	}
+	return record, nil
+}
+
+func (s *testAccessTokenStore) FetchUserAccessTokens(ctx context.Context, userID string) ([]AccessTokenRecord, error) {
+	records := []AccessTokenRecord{}
+	for _, record := range s.records {
+		if record.UserID == userID {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *testAccessTokenStore) RemoveAccessToken(ctx context.Context, record AccessTokenRecord) error {
+	if _, ok := s.records[record.Hash]; !ok {
+		return server.ErrNoAccessToken
+	}
+	delete(s.records, record.Hash)
+	return nil
+}
+
+func TestAccessTokenChecker(t *testing.T) {
+	ctx := context.Background()
+	store := &testAccessTokenStore{records: map[string]AccessTokenRecord{}}
+	checker := MakeAccessTokenChecker(store)
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+	owner := server.Identity{UserID: "user", Email: "user@example.com", EmailVerified: true}
+
+	readToken, readInfo, err := checker.CreateAccessToken(ctx, owner, "cron", server.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(readToken, AccessTokenPrefix) {
+		t.Errorf("expected token prefixed %s, got %s", AccessTokenPrefix, readToken)
+	}
+	if _, ok := store.records[readToken]; ok {
+		t.Error("expected token to be stored hashed")
+	}
+
+	identity, ok := checker.TokenIdentity(ctx, readToken)
+	if !ok || identity.UserID != "user" || !identity.ReadOnly {
+		t.Errorf("expected read only identity for user, got %+v %t", identity, ok)
+	}
+	if identity.Email != owner.Email || !identity.EmailVerified {
+		t.Errorf("expected identity to carry owner's email, got %+v", identity)
+	}
+	if used := store.records[HashAccessToken(readToken)].LastUsedAt; used == nil || !used.Equal(now) {
+		t.Errorf("expected last used time to be recorded, got %v", used)
+	}
+
+	savesBefore := store.saves
+	checker.TokenIdentity(ctx, readToken)
+	if store.saves != savesBefore {
+		t.Error("expected last used time not to be rewritten within resolution")
+	}
+
+	writeToken, _, err := checker.CreateAccessToken(ctx, owner, "deploy", server.ScopeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity, _ := checker.TokenIdentity(ctx, writeToken); identity.ReadOnly {
+		t.Error("expected read-write token not to be read only")
+	}
+
+	for _, invalid := range []string{"", "pyt_nope", readToken[len(AccessTokenPrefix):]} {
+		if _, ok := checker.TokenIsValid(ctx, invalid); ok {
+			t.Errorf("expected token %q to be invalid", invalid)
+		}
+	}
+
+	tokens, err := checker.ListAccessTokens(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(tokens))
+	}
+
+	if err := checker.RevokeAccessToken(ctx, "someone else", readInfo.ID); err != server.ErrNoAccessToken {
+		t.Errorf("expected ErrNoAccessToken revoking another user's token, got %v", err)
+	}
+	if err := checker.RevokeAccessToken(ctx, "user", readInfo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := checker.TokenIsValid(ctx, readToken); ok {
+		t.Error("expected revoked token to be invalid")
+	}
+}
+
+func TestMultiTokenChecker(t *testing.T) {
+	ctx := context.Background()
+	store := &testAccessTokenStore{records: map[string]AccessTokenRecord{}}
+	accessTokens := MakeAccessTokenChecker(store)
+	token, _, err := accessTokens.CreateAccessToken(ctx, server.Identity{UserID: "user"}, "cron", server.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker := MultiTokenChecker{MakeGoogleTokenChecker("fake"), accessTokens}
+	identity, ok := checker.TokenIdentity(ctx, token)
+	if !ok || identity.UserID != "user" || !identity.ReadOnly {
+		t.Errorf("expected read only identity from second checker, got %+v %t", identity, ok)
+	}
+	if _, ok := checker.TokenIsValid(ctx, "a"); ok {
+		t.Error("expected invalid token")
+	}
+}
@@ -0,0 +1,169 @@
+package token
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"py-server/server"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxNegativeTTL bounds how long a rejected token is remembered, so a token
+// rejected by a transient failure is retried soon
+const maxNegativeTTL = 30 * time.Second
+
+// CacheStats describe how well a CachingTokenChecker is doing
+type CacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Size    int     `json:"size"`
+	HitRate float64 `json:"hitRate"`
+}
+
+type cacheEntry struct {
+	key      [sha256.Size]byte
+	identity server.Identity
+	ok       bool
+	expires  time.Time
+}
+
+// CachingTokenChecker is a TokenChecker remembering the results of another
+// checker, keyed by a hash of the token. Accepted tokens are remembered until
+// they expire, but no longer than the maximum TTL, and rejected tokens for the
+// negative TTL. The least recently used results are dropped once full.
+type CachingTokenChecker struct {
+	// accessed atomically, first for alignment
+	hits   int64
+	misses int64
+
+	checker     server.TokenChecker
+	size        int
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	recent  *list.List
+}
+
+// TokenIsValid returns the cached ID for the token, checking it with the
+// underlying checker if not cached
+func (c *CachingTokenChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	identity, ok := c.TokenIdentity(ctx, token)
+	return identity.UserID, ok
+}
+
+// TokenIdentity returns the cached Identity for the token, checking it with
+// the underlying checker if not cached
+func (c *CachingTokenChecker) TokenIdentity(ctx context.Context, token string) (server.Identity, bool) {
+	key := sha256.Sum256([]byte(token))
+	now := c.now()
+
+	if entry, found := c.get(key, now); found {
+		atomic.AddInt64(&c.hits, 1)
+		return entry.identity, entry.ok
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	identity, ok := server.CheckTokenIdentity(ctx, c.checker, token)
+
+	expires := now.Add(c.negativeTTL)
+	if ok {
+		expires = now.Add(c.maxTTL)
+		if !identity.Expires.IsZero() && identity.Expires.Before(expires) {
+			expires = identity.Expires
+		}
+	}
+	if expires.After(now) {
+		c.put(&cacheEntry{key: key, identity: identity, ok: ok, expires: expires})
+	}
+	return identity, ok
+}
+
+func (c *CachingTokenChecker) get(key [sha256.Size]byte, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.recent.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.recent.MoveToFront(element)
+	return entry, true
+}
+
+func (c *CachingTokenChecker) put(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.entries[entry.key]; found {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.recent.PushFront(entry)
+	for c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// ForgetUser drops every result identifying the user
+func (c *CachingTokenChecker) ForgetUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.entries {
+		if element.Value.(*cacheEntry).identity.UserID == userID {
+			c.recent.Remove(element)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Stats returns the cache's hit rate and size so far
+func (c *CachingTokenChecker) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.recent.Len()
+	c.mu.Unlock()
+
+	stats := CacheStats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+		Size:   size,
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// MakeCachingTokenChecker returns a new CachingTokenChecker in front of the
+// checker, holding at most size results. The negative TTL is capped at 30s.
+func MakeCachingTokenChecker(checker server.TokenChecker, size int, maxTTL time.Duration, negativeTTL time.Duration) *CachingTokenChecker {
+	if size < 1 {
+		size = 1
+	}
+	if negativeTTL > maxNegativeTTL {
+		negativeTTL = maxNegativeTTL
+	}
+	return &CachingTokenChecker{
+		checker:     checker,
+		size:        size,
+		maxTTL:      maxTTL,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     map[[sha256.Size]byte]*list.Element{},
+		recent:      list.New(),
+	}
+}
@@ -0,0 +1,81 @@
+package token
+
+import (
+	"context"
+	"py-server/server"
+	"testing"
+	"time"
+)
+
+// checker accepting the token "valid", counting how often it is asked
+type countingTokenChecker struct {
+	checks  int
+	expires time.Time
+}
+
+func (c *countingTokenChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	identity, ok := c.TokenIdentity(ctx, token)
+	return identity.UserID, ok
+}
+
+func (c *countingTokenChecker) TokenIdentity(ctx context.Context, token string) (server.Identity, bool) {
+	c.checks++
+	if token != "valid" && token != "other" {
+		return server.Identity{}, false
+	}
+	return server.Identity{UserID: token, Expires: c.expires}, true
+}
+
+func TestCachingTokenChecker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	underlying := &countingTokenChecker{expires: now.Add(time.Minute)}
+	cache := MakeCachingTokenChecker(underlying, 1, time.Hour, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if userID, ok := cache.TokenIsValid(ctx, "valid"); !ok || userID != "valid" {
+			t.Fatalf("expected valid token, got %s %t", userID, ok)
+		}
+	}
+	if underlying.checks != 1 {
+		t.Errorf("expected 1 underlying check, got %d", underlying.checks)
+	}
+
+	now = now.Add(time.Minute)
+	cache.TokenIsValid(ctx, "valid")
+	if underlying.checks != 2 {
+		t.Errorf("expected token to be rechecked once expired, got %d checks", underlying.checks)
+	}
+
+	cache.TokenIsValid(ctx, "other")
+	cache.TokenIsValid(ctx, "valid")
+	if underlying.checks != 4 {
+		t.Errorf("expected least recently used token to be evicted, got %d checks", underlying.checks)
+	}
+
+	cache.TokenIsValid(ctx, "invalid")
+	cache.TokenIsValid(ctx, "invalid")
+	if underlying.checks != 5 {
+		t.Errorf("expected rejected token to be cached, got %d checks", underlying.checks)
+	}
+	now = now.Add(maxNegativeTTL)
+	if _, ok := cache.TokenIsValid(ctx, "invalid"); ok || underlying.checks != 6 {
+		t.Errorf("expected rejected token to be rechecked after negative TTL, got %d checks", underlying.checks)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 3 || stats.Misses != 6 || stats.Size != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.HitRate != 3.0/9.0 {
+		t.Errorf("expected hit rate %f, got %f", 3.0/9.0, stats.HitRate)
+	}
+
+	cache.TokenIsValid(ctx, "valid")
+	cache.ForgetUser("valid")
+	cache.TokenIsValid(ctx, "valid")
+	if underlying.checks != 8 {
+		t.Errorf("expected forgotten user's token to be rechecked, got %d checks", underlying.checks)
+	}
+}
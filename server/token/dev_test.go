@@ -0,0 +1,50 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDevTokenChecker(t *testing.T) {
+	ctx := context.Background()
+	checker := MakeDevTokenChecker("secret")
+
+	valid, err := checker.Mint(DevClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := checker.Mint(DevClaims{Subject: "user", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSubject, err := checker.Mint(DevClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, err := MakeDevTokenChecker("other").Mint(DevClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		valid:         true,
+		"dev:user":    true,
+		"dev:":        false,
+		expired:       false,
+		noSubject:     false,
+		otherSecret:   false,
+		valid + "a":   false,
+		"a.b.c":       false,
+		"not a token": false,
+	}
+	for token, expectValid := range tests {
+		userID, ok := checker.TokenIsValid(ctx, token)
+		if ok != expectValid {
+			t.Errorf("token %q: expected valid %t, got %t", token, expectValid, ok)
+		}
+		if ok && userID != "user" {
+			t.Errorf("token %q: expected user, got %s", token, userID)
+		}
+	}
+}
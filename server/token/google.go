@@ -2,6 +2,8 @@ package token
 
 import (
 	"context"
+	"py-server/server"
+	"time"
 
 	"google.golang.org/api/idtoken"
 )
@@ -15,12 +17,29 @@ type GoogleTokenChecker struct {
 // TokenIsValid checks the given token against google's oauth api,
 // using the provided clientId if any is given.
 func (c GoogleTokenChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	identity, ok := c.TokenIdentity(ctx, token)
+	return identity.UserID, ok
+}
+
+// TokenIdentity checks the token as with TokenIsValid, returning the identity
+// from its payload
+func (c GoogleTokenChecker) TokenIdentity(ctx context.Context, token string) (server.Identity, bool) {
 	payload, err := idtoken.Validate(ctx, token, c.clientId)
 	if err != nil {
-		return "", false
+		return server.Identity{}, false
 	}
 
-	return payload.Subject, true
+	email, _ := payload.Claims["email"].(string)
+	emailVerified, _ := payload.Claims["email_verified"].(bool)
+	hostedDomain, _ := payload.Claims["hd"].(string)
+	return server.Identity{
+		UserID:        payload.Subject,
+		Expires:       time.Unix(payload.Expires, 0),
+		Email:         email,
+		EmailVerified: emailVerified,
+		HostedDomain:  hostedDomain,
+		Claims:        payload.Claims,
+	}, true
 }
 
 // MakeGoogleTokenChecker returns a new GoogleTokenChecker
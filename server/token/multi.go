@@ -0,0 +1,26 @@
+package token
+
+import (
+	"context"
+	"py-server/server"
+)
+
+// MultiTokenChecker is a TokenChecker which tries each of its checkers in
+// order, accepting the token if any of them do.
+type MultiTokenChecker []server.TokenChecker
+
+// TokenIsValid returns the ID from the first checker accepting the token
+func (m MultiTokenChecker) TokenIsValid(ctx context.Context, token string) (string, bool) {
+	identity, ok := m.TokenIdentity(ctx, token)
+	return identity.UserID, ok
+}
+
+// TokenIdentity returns the Identity from the first checker accepting the token
+func (m MultiTokenChecker) TokenIdentity(ctx context.Context, token string) (server.Identity, bool) {
+	for _, checker := range m {
+		if identity, ok := server.CheckTokenIdentity(ctx, checker, token); ok {
+			return identity, true
+		}
+	}
+	return server.Identity{}, false
+}
@@ -0,0 +1,240 @@
+package usersave
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// AllocationStrategy is a way of splitting the SavingsAmount between goals
+type AllocationStrategy string
+
+const (
+	// StrategyDeadlineFirst pays what each goal needs to meet its deadline,
+	// soonest first, then fills goals in deadline order
+	StrategyDeadlineFirst AllocationStrategy = "deadline-first"
+	// StrategyProportional splits in proportion to what each goal has left
+	StrategyProportional AllocationStrategy = "proportional"
+	// StrategyPriority fills goals in order of their priority
+	StrategyPriority AllocationStrategy = "priority"
+	// StrategyEqual splits equally between goals not yet met
+	StrategyEqual AllocationStrategy = "equal"
+)
+
+// AllocationStrategies are every valid AllocationStrategy
+var AllocationStrategies = []AllocationStrategy{StrategyDeadlineFirst, StrategyProportional, StrategyPriority, StrategyEqual}
+
+// Valid returns true if the strategy is one of AllocationStrategies
+func (s AllocationStrategy) Valid() bool {
+	for _, strategy := range AllocationStrategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// GoalContribution is what a goal is allocated each cycle
+type GoalContribution struct {
+	Name         string `json:"name"`
+	Contribution Money  `json:"contribution"`
+}
+
+// Allocation splits the SavingsAmount between goals. No goal is allocated
+// more than it has left, so some may go unallocated.
+type Allocation struct {
+	Strategy AllocationStrategy `json:"strategy"`
+	// Contributions are in the same order as the save's goals
+	Contributions []GoalContribution `json:"contributions"`
+	Unallocated   Money              `json:"unallocated"`
+}
+
+// allocator tracks what's allocated to each goal out of the budget, all in
+// minor units
+type allocator struct {
+	remaining []int64
+	allocated []int64
+	budget    int64
+}
+
+// give allocates up to amount to the goal, no more than it has left or the
+// budget allows
+func (a *allocator) give(goal int, amount int64) {
+	if left := a.remaining[goal] - a.allocated[goal]; amount > left {
+		amount = left
+	}
+	if amount > a.budget {
+		amount = a.budget
+	}
+	if amount > 0 {
+		a.allocated[goal] += amount
+		a.budget -= amount
+	}
+}
+
+// fill gives each goal in order everything it has left
+func (a *allocator) fill(order []int) {
+	for _, goal := range order {
+		a.give(goal, a.remaining[goal])
+	}
+}
+
+// goalOrder returns the goal indexes sorted by less, keeping ties in order
+func goalOrder(count int, less func(a int, b int) bool) []int {
+	order := make([]int, count)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return less(order[i], order[j])
+	})
+	return order
+}
+
+func (a *allocator) deadlineFirst(userSave *JSONUserSave, now time.Time) {
+	now = now.In(userSave.Location())
+	order := goalOrder(len(userSave.Savings), func(i int, j int) bool {
+		di, dj := userSave.Savings[i].Deadline, userSave.Savings[j].Deadline
+		return di != nil && (dj == nil || di.Before(*dj))
+	})
+	for _, goal := range order {
+		deadline, ok := userSave.Savings[goal].DeadlineTime(now.Location())
+		if !ok || a.remaining[goal] < 1 {
+			continue
+		}
+		required := a.remaining[goal]
+		if cycles := cyclesUntil(userSave.Cycle, now, deadline); cycles > 0 {
+			required = ceilDiv(required, int64(cycles))
+		}
+		a.give(goal, required)
+	}
+	a.fill(order)
+}
+
+func (a *allocator) priority(userSave *JSONUserSave) {
+	a.fill(goalOrder(len(userSave.Savings), func(i int, j int) bool {
+		return userSave.Savings[i].Priority < userSave.Savings[j].Priority
+	}))
+}
+
+// proportional splits by largest remainder, so every minor unit is allocated
+func (a *allocator) proportional() {
+	total := new(big.Int)
+	for _, remaining := range a.remaining {
+		total.Add(total, big.NewInt(remaining))
+	}
+	if total.Sign() == 0 {
+		return
+	}
+	if total.Cmp(big.NewInt(a.budget)) <= 0 {
+		for goal, remaining := range a.remaining {
+			a.give(goal, remaining)
+		}
+		return
+	}
+
+	budget := a.budget
+	fractions := make([]*big.Int, len(a.remaining))
+	for goal, remaining := range a.remaining {
+		share, fraction := new(big.Int).QuoRem(
+			new(big.Int).Mul(big.NewInt(budget), big.NewInt(remaining)), total, new(big.Int),
+		)
+		fractions[goal] = fraction
+		a.give(goal, share.Int64())
+	}
+	order := goalOrder(len(a.remaining), func(i int, j int) bool {
+		return fractions[i].Cmp(fractions[j]) > 0
+	})
+	for _, goal := range order {
+		a.give(goal, 1)
+	}
+}
+
+// equal shares the budget out between unmet goals until it runs out or every
+// goal is met
+func (a *allocator) equal() {
+	for a.budget > 0 {
+		unmet := []int{}
+		for goal := range a.remaining {
+			if a.allocated[goal] < a.remaining[goal] {
+				unmet = append(unmet, goal)
+			}
+		}
+		if len(unmet) < 1 {
+			return
+		}
+		share := a.budget / int64(len(unmet))
+		if share < 1 {
+			for _, goal := range unmet {
+				a.give(goal, 1)
+			}
+			return
+		}
+		for _, goal := range unmet {
+			a.give(goal, share)
+		}
+	}
+}
+
+// Allocate splits the save's SavingsAmount between its goals each cycle
+// using the strategy
+func Allocate(userSave *JSONUserSave, strategy AllocationStrategy, now time.Time) (Allocation, error) {
+	if !strategy.Valid() {
+		return Allocation{}, fmt.Errorf("unknown allocation strategy %q", strategy)
+	}
+	if strategy == StrategyDeadlineFirst && !userSave.Cycle.Valid() {
+		return Allocation{}, ErrNoCycle
+	}
+
+	a := &allocator{
+		remaining: make([]int64, len(userSave.Savings)),
+		allocated: make([]int64, len(userSave.Savings)),
+		budget:    userSave.SavingsAmount.Minor,
+	}
+	currency := userSave.SavingsAmount.Currency
+	for i, savings := range userSave.Savings {
+		remaining, err := savings.Goal.Sub(savings.Saved)
+		if err != nil {
+			return Allocation{}, fmt.Errorf("savings %q: %w", savings.Name, err)
+		}
+		if len(currency) < 1 {
+			currency = remaining.Currency
+		} else if len(remaining.Currency) > 0 && remaining.Currency != currency {
+			return Allocation{}, fmt.Errorf("savings %q: %w: %s and %s", savings.Name, ErrCurrencyMismatch, currency, remaining.Currency)
+		}
+		if remaining.Minor > 0 {
+			a.remaining[i] = remaining.Minor
+		}
+	}
+
+	switch strategy {
+	case StrategyDeadlineFirst:
+		a.deadlineFirst(userSave, now)
+	case StrategyProportional:
+		a.proportional()
+	case StrategyPriority:
+		a.priority(userSave)
+	case StrategyEqual:
+		a.equal()
+	}
+
+	allocation := Allocation{
+		Strategy:      strategy,
+		Contributions: make([]GoalContribution, len(userSave.Savings)),
+		Unallocated:   Money{a.budget, currency},
+	}
+	for i, savings := range userSave.Savings {
+		allocation.Contributions[i] = GoalContribution{savings.Name, Money{a.allocated[i], currency}}
+	}
+	return allocation, nil
+}
+
+// ApplyAllocation sets each goal's amount to its contribution and keeps the
+// strategy in the save
+func ApplyAllocation(userSave *JSONUserSave, allocation Allocation) {
+	userSave.AllocationStrategy = allocation.Strategy
+	for i := range userSave.Savings {
+		userSave.Savings[i].Amount = allocation.Contributions[i].Contribution
+	}
+}
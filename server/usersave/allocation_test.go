@@ -0,0 +1,81 @@
+package usersave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllocate(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	july := Date{2021, time.July, 1}
+	september := Date{2021, time.September, 1}
+	userSave := &JSONUserSave{
+		Cycle:         CycleFortnightly,
+		SavingsAmount: nzd(1000),
+		Savings: []JSONSavings{
+			{Name: "A", Goal: nzd(3000), Priority: 2},
+			{Name: "B", Goal: nzd(600), Deadline: &july, Priority: 1},
+			{Name: "C", Goal: nzd(2000), Saved: nzd(1000), Deadline: &september, Priority: 3},
+			{Name: "D", Goal: nzd(100), Saved: nzd(100)},
+		},
+	}
+
+	tests := map[AllocationStrategy][]int64{
+		StrategyDeadlineFirst: {0, 600, 400, 0},
+		StrategyProportional:  {652, 131, 217, 0},
+		StrategyPriority:      {400, 600, 0, 0},
+		StrategyEqual:         {334, 333, 333, 0},
+	}
+	for strategy, expect := range tests {
+		allocation, err := Allocate(userSave, strategy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allocation.Strategy != strategy || !allocation.Unallocated.IsZero() {
+			t.Errorf("%s: expected everything allocated, got %+v", strategy, allocation)
+		}
+		for i, contribution := range allocation.Contributions {
+			if contribution.Name != userSave.Savings[i].Name || contribution.Contribution != nzd(expect[i]) {
+				t.Errorf("%s: expected %s to get %d, got %+v", strategy, userSave.Savings[i].Name, expect[i], contribution)
+			}
+		}
+	}
+
+	userSave.SavingsAmount = nzd(10000)
+	for _, strategy := range AllocationStrategies {
+		allocation, err := Allocate(userSave, strategy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allocation.Unallocated != nzd(5400) {
+			t.Errorf("%s: expected goals not to be overfunded, got %+v", strategy, allocation)
+		}
+	}
+
+	if _, err := Allocate(userSave, "random", now); err == nil {
+		t.Error("expected unknown strategy to fail")
+	}
+	userSave.Savings = append(userSave.Savings, JSONSavings{Goal: Money{1, "AUD"}})
+	if _, err := Allocate(userSave, StrategyEqual, now); err == nil {
+		t.Error("expected goals in another currency to fail")
+	}
+}
+
+func TestApplyAllocation(t *testing.T) {
+	userSave := &JSONUserSave{
+		SavingsAmount: Money{100, "NZD"},
+		Savings:       []JSONSavings{{Goal: Money{1000, "NZD"}}, {Goal: Money{1000, "NZD"}}},
+	}
+	allocation, err := Allocate(userSave, StrategyEqual, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyAllocation(userSave, allocation)
+	if userSave.AllocationStrategy != StrategyEqual || userSave.Savings[0].Amount.Minor != 50 || userSave.Savings[1].Amount.Minor != 50 {
+		t.Errorf("expected strategy and contributions to be saved, got %+v", userSave)
+	}
+	if err := userSave.Validate(); err != nil {
+		t.Errorf("expected allocated save to be valid, got %s", err)
+	}
+}
@@ -0,0 +1,95 @@
+package usersave
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvText neutralises text the user typed which a spreadsheet would read as a
+// formula, by prefixing it with a quote. Amounts are written as they are, so
+// negative ones stay numbers.
+func csvText(value string) string {
+	if len(value) > 0 && strings.IndexByte("=+-@\t\r", value[0]) >= 0 {
+		return "'" + value
+	}
+	return value
+}
+
+func writeCSV(w io.Writer, rows [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
+
+// WriteExpensesCSV writes the save's expenses as CSV with a header row
+func WriteExpensesCSV(userSave *JSONUserSave, w io.Writer) error {
+	rows := [][]string{{"name", "amount", "currency", "tag", "cycle"}}
+	for _, expense := range userSave.Expenses {
+		rows = append(rows, []string{
+			csvText(expense.Name),
+			expense.Amount.String(),
+			expense.Amount.Currency,
+			csvText(expense.Tag),
+			string(userSave.Cycle),
+		})
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteSavingsCSV writes the save's savings goals as CSV with a header row
+func WriteSavingsCSV(userSave *JSONUserSave, w io.Writer) error {
+	rows := [][]string{{"name", "goal", "amount", "currency", "deadline"}}
+	for _, savings := range userSave.Savings {
+		deadline := ""
+		if savings.Deadline != nil {
+			deadline = savings.Deadline.String()
+		}
+		rows = append(rows, []string{
+			csvText(savings.Name),
+			savings.Goal.String(),
+			savings.Amount.String(),
+			savings.Goal.Currency,
+			deadline,
+		})
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteBudgetCSV writes the save's expenses then its savings goals as CSV, a
+// row each with a header row, their type telling them apart
+func WriteBudgetCSV(userSave *JSONUserSave, w io.Writer) error {
+	rows := [][]string{{"type", "name", "amount", "currency", "tag", "goal", "saved", "deadline", "cycle"}}
+	for _, expense := range userSave.Expenses {
+		rows = append(rows, []string{
+			"expense",
+			csvText(expense.Name),
+			expense.Amount.String(),
+			expense.Amount.Currency,
+			csvText(expense.Tag),
+			"", "", "",
+			string(userSave.Cycle),
+		})
+	}
+	for _, savings := range userSave.Savings {
+		deadline := ""
+		if savings.Deadline != nil {
+			deadline = savings.Deadline.String()
+		}
+		rows = append(rows, []string{
+			"savings",
+			csvText(savings.Name),
+			savings.Amount.String(),
+			savings.Goal.Currency,
+			"",
+			savings.Goal.String(),
+			savings.Saved.String(),
+			deadline,
+			string(userSave.Cycle),
+		})
+	}
+	return writeCSV(w, rows)
+}
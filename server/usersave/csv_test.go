@@ -0,0 +1,71 @@
+package usersave
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	validJSON := readValidJSON()
+	defer validJSON.Close()
+	userSave, err := DecodeUserSave(validJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the example's legacy deadline isn't a date, so give it one to write
+	userSave.Savings[0].Deadline = &Date{2023, time.December, 1}
+
+	expenses := bytes.Buffer{}
+	if err := WriteExpensesCSV(userSave, &expenses); err != nil {
+		t.Fatal(err)
+	}
+	expect := "name,amount,currency,tag,cycle\nExpense A,333.54,NZD,Housing,Fortnightly\nExpense B,9991.33,NZD,,Fortnightly\n"
+	if got := expenses.String(); got != expect {
+		t.Errorf("expected expenses CSV:\n%s\ngot:\n%s", expect, got)
+	}
+
+	savings := bytes.Buffer{}
+	if err := WriteSavingsCSV(userSave, &savings); err != nil {
+		t.Fatal(err)
+	}
+	expect = "name,goal,amount,currency,deadline\nsavings A,9991.33,9991.33,NZD,2023-12-01\n"
+	if got := savings.String(); got != expect {
+		t.Errorf("expected savings CSV:\n%s\ngot:\n%s", expect, got)
+	}
+
+	budget := bytes.Buffer{}
+	if err := WriteBudgetCSV(userSave, &budget); err != nil {
+		t.Fatal(err)
+	}
+	expect = "type,name,amount,currency,tag,goal,saved,deadline,cycle\n" +
+		"expense,Expense A,333.54,NZD,Housing,,,,Fortnightly\n" +
+		"expense,Expense B,9991.33,NZD,,,,,Fortnightly\n" +
+		"savings,savings A,9991.33,NZD,,9991.33,0.00,2023-12-01,Fortnightly\n"
+	if got := budget.String(); got != expect {
+		t.Errorf("expected budget CSV:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestWriteCSVNeutralisesFormulas(t *testing.T) {
+	userSave := &JSONUserSave{
+		Cycle: CycleWeekly,
+		Expenses: []JSONExpense{
+			{Name: "=HYPERLINK(\"http://example.com\")", Amount: Money{-500, "NZD"}, Tag: "@Fun"},
+			{Name: "Rent - flat", Amount: Money{50000, "NZD"}},
+		},
+		Savings: []JSONSavings{{Name: "+Car", Goal: Money{100, "NZD"}}},
+	}
+
+	budget := bytes.Buffer{}
+	if err := WriteBudgetCSV(userSave, &budget); err != nil {
+		t.Fatal(err)
+	}
+	expect := "type,name,amount,currency,tag,goal,saved,deadline,cycle\n" +
+		"expense,\"'=HYPERLINK(\"\"http://example.com\"\")\",-5.00,NZD,'@Fun,,,,Weekly\n" +
+		"expense,Rent - flat,500.00,NZD,,,,,Weekly\n" +
+		"savings,'+Car,0.00,NZD,,1.00,0.00,,Weekly\n"
+	if got := budget.String(); got != expect {
+		t.Errorf("expected budget CSV:\n%s\ngot:\n%s", expect, got)
+	}
+}
@@ -0,0 +1,112 @@
+package usersave
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cycle is how often the user is paid, and so how often every amount in
+// their save recurs
+type Cycle string
+
+const (
+	CycleWeekly      Cycle = "Weekly"
+	CycleFortnightly Cycle = "Fortnightly"
+	CycleFourWeekly  Cycle = "Four-weekly"
+	CycleMonthly     Cycle = "Monthly"
+	CycleQuarterly   Cycle = "Quarterly"
+	CycleYearly      Cycle = "Yearly"
+)
+
+// Cycles are every valid Cycle, shortest first
+var Cycles = []Cycle{CycleWeekly, CycleFortnightly, CycleFourWeekly, CycleMonthly, CycleQuarterly, CycleYearly}
+
+// cyclesPerYear treats a year as 52 weeks or 12 months
+var cyclesPerYear = map[Cycle]int64{
+	CycleWeekly:      52,
+	CycleFortnightly: 26,
+	CycleFourWeekly:  13,
+	CycleMonthly:     12,
+	CycleQuarterly:   4,
+	CycleYearly:      1,
+}
+
+// ParseCycle returns the Cycle named, ignoring case, spaces, dashes and
+// underscores
+func ParseCycle(name string) (Cycle, error) {
+	key := strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(name))
+	for _, cycle := range Cycles {
+		if strings.Replace(strings.ToLower(string(cycle)), "-", "", -1) == key {
+			return cycle, nil
+		}
+	}
+	return "", fmt.Errorf("unknown cycle %q", name)
+}
+
+// Valid returns true if the cycle is one of Cycles
+func (c Cycle) Valid() bool {
+	_, found := cyclesPerYear[c]
+	return found
+}
+
+// PerYear returns how many times the cycle recurs in a year
+func (c Cycle) PerYear() int64 {
+	return cyclesPerYear[c]
+}
+
+// UnmarshalJSON normalises known cycles, keeping anything else as it is so
+// it can be reported by Validate
+func (c *Cycle) UnmarshalJSON(data []byte) error {
+	name := ""
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("cycle must be a string: %w", err)
+	}
+	if cycle, err := ParseCycle(name); err == nil {
+		*c = cycle
+		return nil
+	}
+	*c = Cycle(name)
+	return nil
+}
+
+// ConvertMoney converts an amount recurring every from cycle into the amount
+// recurring every to cycle with the same yearly total
+func ConvertMoney(amount Money, from Cycle, to Cycle) (Money, error) {
+	if !from.Valid() || !to.Valid() {
+		return Money{}, fmt.Errorf("can't convert from cycle %q to %q", from, to)
+	}
+	return amount.Scale(from.PerYear(), to.PerYear())
+}
+
+// addMonths adds months to the date, clamping the day to the end of shorter
+// months rather than overflowing into the next
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// Advance returns the time n cycles after t. Monthly cycles land on the same
+// day of the month, or the last day of shorter months.
+func (c Cycle) Advance(t time.Time, n int) time.Time {
+	switch c {
+	case CycleWeekly:
+		return t.AddDate(0, 0, 7*n)
+	case CycleFortnightly:
+		return t.AddDate(0, 0, 14*n)
+	case CycleFourWeekly:
+		return t.AddDate(0, 0, 28*n)
+	case CycleMonthly:
+		return addMonths(t, n)
+	case CycleQuarterly:
+		return addMonths(t, 3*n)
+	case CycleYearly:
+		return addMonths(t, 12*n)
+	}
+	return t
+}
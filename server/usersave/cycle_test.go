@@ -0,0 +1,85 @@
+package usersave
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseCycle(t *testing.T) {
+	tests := map[string]Cycle{
+		"weekly":      CycleWeekly,
+		"Fortnightly": CycleFortnightly,
+		"four-weekly": CycleFourWeekly,
+		"Four Weekly": CycleFourWeekly,
+		"FOUR_WEEKLY": CycleFourWeekly,
+		"monthly":     CycleMonthly,
+		"quarterly":   CycleQuarterly,
+		"Yearly":      CycleYearly,
+	}
+	for name, expect := range tests {
+		if got, err := ParseCycle(name); err != nil || got != expect {
+			t.Errorf("expected %q to parse as %s, got %s %v", name, expect, got, err)
+		}
+	}
+	if _, err := ParseCycle("daily"); err == nil {
+		t.Error("expected daily to be unknown")
+	}
+}
+
+func TestCycleJSON(t *testing.T) {
+	cycles := []Cycle{}
+	if err := json.Unmarshal([]byte(`["fortnightly", "Daily", ""]`), &cycles); err != nil {
+		t.Fatal(err)
+	}
+	if cycles[0] != CycleFortnightly || cycles[1] != "Daily" || cycles[2] != "" {
+		t.Errorf("expected known cycles normalised and others kept, got %v", cycles)
+	}
+	if cycles[1].Valid() || cycles[2].Valid() {
+		t.Error("expected unknown cycles to be invalid")
+	}
+}
+
+func TestConvertMoney(t *testing.T) {
+	tests := []struct {
+		amount int64
+		from   Cycle
+		to     Cycle
+		expect int64
+	}{
+		{100000, CycleFortnightly, CycleWeekly, 50000},
+		{100000, CycleFortnightly, CycleMonthly, 216667},
+		{120000, CycleYearly, CycleMonthly, 10000},
+		{10000, CycleMonthly, CycleQuarterly, 30000},
+		{10000, CycleFourWeekly, CycleFourWeekly, 10000},
+	}
+	for _, test := range tests {
+		got, err := ConvertMoney(Money{test.amount, "NZD"}, test.from, test.to)
+		if err != nil || got.Minor != test.expect {
+			t.Errorf("expected %d %s to be %d %s, got %+v %v", test.amount, test.from, test.expect, test.to, got, err)
+		}
+	}
+	if _, err := ConvertMoney(Money{1, "NZD"}, "Daily", CycleWeekly); err == nil {
+		t.Error("expected converting from an unknown cycle to fail")
+	}
+}
+
+func TestCycleAdvance(t *testing.T) {
+	start := time.Date(2021, time.January, 31, 0, 0, 0, 0, time.UTC)
+	tests := map[Cycle]string{
+		CycleWeekly:      "2021-02-07",
+		CycleFortnightly: "2021-02-14",
+		CycleFourWeekly:  "2021-02-28",
+		CycleMonthly:     "2021-02-28",
+		CycleQuarterly:   "2021-04-30",
+		CycleYearly:      "2022-01-31",
+	}
+	for cycle, expect := range tests {
+		if got := cycle.Advance(start, 1).Format("2006-01-02"); got != expect {
+			t.Errorf("expected %s after %s to be %s, got %s", cycle, start, expect, got)
+		}
+	}
+	if got := CycleMonthly.Advance(start, 2).Format("2006-01-02"); got != "2021-03-31" {
+		t.Errorf("expected monthly cycles to keep their day, got %s", got)
+	}
+}
@@ -0,0 +1,90 @@
+package usersave
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day without a time or place, encoded as an RFC 3339
+// full-date such as "2021-12-01"
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// ParseDate parses an RFC 3339 full-date
+func ParseDate(value string) (Date, error) {
+	parsed, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return Date{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return DateOf(parsed), nil
+}
+
+// DateOf returns the day the time falls on, in its location
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{year, month, day}
+}
+
+// In returns midnight at the start of the day in the location
+func (d Date) In(loc *time.Location) time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, loc)
+}
+
+// AddDays returns the date n days later
+func (d Date) AddDays(n int) Date {
+	return DateOf(d.In(time.UTC).AddDate(0, 0, n))
+}
+
+// Before returns true if the date is before the other
+func (d Date) Before(other Date) bool {
+	return d.In(time.UTC).Before(other.In(time.UTC))
+}
+
+// After returns true if the date is after the other
+func (d Date) After(other Date) bool {
+	return other.Before(d)
+}
+
+func (d Date) String() string {
+	return d.In(time.UTC).Format(dateLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	value := ""
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("date must be a YYYY-MM-DD string: %w", err)
+	}
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Today returns the date it is in the location
+func Today(now time.Time, loc *time.Location) Date {
+	return DateOf(now.In(loc))
+}
+
+// Location returns the save's timezone, UTC if it has none or it is unknown
+func (s *JSONUserSave) Location() *time.Location {
+	if len(s.Timezone) < 1 {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,220 @@
+package usersave
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GoalBalance is what a goal is given in a forecast cycle, and what has been
+// saved towards it by the end of that cycle
+type GoalBalance struct {
+	Name         string `json:"name"`
+	Contribution Money  `json:"contribution"`
+	Saved        Money  `json:"saved"`
+}
+
+// ForecastCycle is one simulated pay cycle
+type ForecastCycle struct {
+	// Cycle counts from 1, the next payday
+	Cycle int  `json:"cycle"`
+	Date  Date `json:"date"`
+	// Income, PaidToSavings and Expenses are as in the Summary
+	Income        Money `json:"income"`
+	PaidToSavings Money `json:"paidToSavings"`
+	// Unallocated is paid to savings but to no goal, as every goal is met
+	// or the allocation strategy left it over
+	Unallocated Money `json:"unallocated"`
+	// Shortfall is what the goals are given beyond the PaidToSavings, which
+	// comes out of what remains
+	Shortfall Money `json:"shortfall"`
+	Expenses  Money `json:"expenses"`
+	// Remaining is the Summary's, less any Shortfall
+	Remaining Money `json:"remaining"`
+	// Balance is the remaining money of this and every earlier cycle
+	Balance Money `json:"balance"`
+	// Overspent is set while the Balance is below zero, so an earlier
+	// cycle's surplus covers a later one's overspending
+	Overspent bool          `json:"overspent"`
+	Goals     []GoalBalance `json:"goals"`
+	// Completed names the goals met this cycle
+	Completed []string `json:"completed"`
+}
+
+// GoalCompletion is the cycle a goal is met in
+type GoalCompletion struct {
+	Name  string `json:"name"`
+	Cycle int    `json:"cycle"`
+	Date  Date   `json:"date"`
+}
+
+// Forecast simulates a save's cash flow over future pay cycles
+type Forecast struct {
+	// Currency is shared by every amount in the forecast
+	Currency string `json:"currency"`
+	// Strategy splits the SavingsAmount between goals each cycle, if the save
+	// has one. Otherwise each goal is given its amount.
+	Strategy    AllocationStrategy `json:"strategy,omitempty"`
+	Cycles      []ForecastCycle    `json:"cycles"`
+	Completions []GoalCompletion   `json:"completions"`
+	// OverspentCycles lists the cycles whose Balance is below zero
+	OverspentCycles []int `json:"overspentCycles"`
+}
+
+// forecastDates returns the date of each of the next count paydays, per the
+// pay schedule if the save has one or every cycle from now if not
+func forecastDates(userSave *JSONUserSave, now time.Time, count int) ([]Date, error) {
+	dates := make([]Date, 0, count)
+	if userSave.PaySchedule == nil {
+		for n := 1; n <= count; n++ {
+			dates = append(dates, DateOf(userSave.Cycle.Advance(now, n)))
+		}
+		return dates, nil
+	}
+	paydays, err := Paydays(userSave, now, count)
+	if err != nil {
+		return nil, err
+	}
+	for _, payday := range paydays {
+		dates = append(dates, payday.Date)
+	}
+	return dates, nil
+}
+
+// ForecastCashFlow simulates the next count pay cycles of the save from now in
+// the user's timezone, paying into savings first, then expenses. Each cycle's
+// SavingsAmount is allocated with the save's strategy, reallocating as goals
+// are met. Goals given more than the SavingsAmount take the shortfall from
+// what remains.
+func ForecastCashFlow(userSave *JSONUserSave, now time.Time, count int) (Forecast, error) {
+	summary, err := Summarize(userSave, "")
+	if err != nil {
+		return Forecast{}, err
+	}
+	now = now.In(userSave.Location())
+	dates, err := forecastDates(userSave, now, count)
+	if err != nil {
+		return Forecast{}, err
+	}
+
+	simulated := *userSave
+	simulated.Savings = append([]JSONSavings(nil), userSave.Savings...)
+	forecast := Forecast{
+		Currency:        userSave.Currency(),
+		Strategy:        userSave.AllocationStrategy,
+		Cycles:          make([]ForecastCycle, 0, count),
+		Completions:     []GoalCompletion{},
+		OverspentCycles: []int{},
+	}
+	balance := Money{}
+	for i, date := range dates {
+		wasMet := make([]bool, len(simulated.Savings))
+		for goal, savings := range simulated.Savings {
+			wasMet[goal] = savings.met()
+		}
+
+		contributions, err := simulated.contributeCycle(date.In(now.Location()))
+		if err != nil {
+			return Forecast{}, err
+		}
+		cycle := ForecastCycle{
+			Cycle:         i + 1,
+			Date:          date,
+			Income:        summary.Income,
+			PaidToSavings: summary.PaidToSavings,
+			Unallocated:   summary.PaidToSavings,
+			Expenses:      summary.Expenses,
+			Goals:         make([]GoalBalance, len(simulated.Savings)),
+			Completed:     []string{},
+		}
+		for goal, savings := range simulated.Savings {
+			if cycle.Unallocated, err = cycle.Unallocated.Sub(contributions[goal].Contribution); err != nil {
+				return Forecast{}, fmt.Errorf("savings %q: %w", savings.Name, err)
+			}
+			cycle.Goals[goal] = GoalBalance{savings.Name, contributions[goal].Contribution, savings.Saved}
+			if !wasMet[goal] && savings.met() {
+				cycle.Completed = append(cycle.Completed, savings.Name)
+				forecast.Completions = append(forecast.Completions, GoalCompletion{savings.Name, cycle.Cycle, date})
+			}
+		}
+		cycle.Shortfall = Money{0, cycle.Unallocated.Currency}
+		if cycle.Unallocated.Minor < 0 {
+			cycle.Shortfall.Minor, cycle.Unallocated.Minor = -cycle.Unallocated.Minor, 0
+		}
+		if cycle.Remaining, err = summary.Remaining.Sub(cycle.Shortfall); err != nil {
+			return Forecast{}, fmt.Errorf("failed to take shortfall from remaining money: %w", err)
+		}
+		if balance, err = balance.Add(cycle.Remaining); err != nil {
+			return Forecast{}, fmt.Errorf("failed to total remaining money: %w", err)
+		}
+		cycle.Balance = balance
+		cycle.Overspent = balance.Minor < 0
+		if cycle.Overspent {
+			forecast.OverspentCycles = append(forecast.OverspentCycles, cycle.Cycle)
+		}
+		forecast.Cycles = append(forecast.Cycles, cycle)
+	}
+	return forecast, nil
+}
+
+// met returns true if the goal has been saved
+func (s JSONSavings) met() bool {
+	return s.Saved.Minor >= s.Goal.Minor
+}
+
+// contributeCycle pays one cycle into the save's goals, allocated with its
+// strategy if it has one, adding the contributions to what's saved
+func (s *JSONUserSave) contributeCycle(now time.Time) ([]GoalContribution, error) {
+	if len(s.AllocationStrategy) < 1 {
+		return contribute(s.Savings)
+	}
+	allocation, err := Allocate(s, s.AllocationStrategy, now)
+	if err != nil {
+		return nil, err
+	}
+	for i, contribution := range allocation.Contributions {
+		if s.Savings[i].Saved, err = s.Savings[i].Saved.Add(contribution.Contribution); err != nil {
+			return nil, fmt.Errorf("savings %q: %w", s.Savings[i].Name, err)
+		}
+	}
+	return allocation.Contributions, nil
+}
+
+// WriteForecastCSV writes a row for each cycle of the forecast with a header
+// row, and a column of what's saved for each goal
+func WriteForecastCSV(forecast Forecast, w io.Writer) error {
+	header := []string{
+		"cycle", "date", "currency", "income", "paidToSavings", "unallocated",
+		"shortfall", "expenses", "remaining", "balance", "overspent", "completed",
+	}
+	if len(forecast.Cycles) > 0 {
+		for _, goal := range forecast.Cycles[0].Goals {
+			header = append(header, "saved: "+goal.Name)
+		}
+	}
+
+	rows := [][]string{header}
+	for _, cycle := range forecast.Cycles {
+		row := []string{
+			strconv.Itoa(cycle.Cycle),
+			cycle.Date.String(),
+			forecast.Currency,
+			cycle.Income.String(),
+			cycle.PaidToSavings.String(),
+			cycle.Unallocated.String(),
+			cycle.Shortfall.String(),
+			cycle.Expenses.String(),
+			cycle.Remaining.String(),
+			cycle.Balance.String(),
+			strconv.FormatBool(cycle.Overspent),
+			csvText(strings.Join(cycle.Completed, ";")),
+		}
+		for _, goal := range cycle.Goals {
+			row = append(row, goal.Saved.String())
+		}
+		rows = append(rows, row)
+	}
+	return writeCSV(w, rows)
+}
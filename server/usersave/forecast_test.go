@@ -0,0 +1,129 @@
+package usersave
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForecastCashFlow(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	userSave := &JSONUserSave{
+		Cycle:         CycleWeekly,
+		Income:        nzd(1000),
+		SavingsAmount: nzd(300),
+		Expenses:      []JSONExpense{{Amount: nzd(800)}},
+		Savings: []JSONSavings{
+			{Name: "A", Goal: nzd(250), Amount: nzd(100), Priority: 1},
+			{Name: "B", Goal: nzd(500), Amount: nzd(200)},
+		},
+	}
+
+	forecast, err := ForecastCashFlow(userSave, now, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forecast.Cycles) != 3 || forecast.Currency != "NZD" {
+		t.Fatalf("expected 3 NZD cycles, got %+v", forecast)
+	}
+	last := forecast.Cycles[2]
+	if last.Date.String() != "2021-06-22" || last.Balance != nzd(-300) || last.Unallocated != nzd(150) {
+		t.Errorf("unexpected last cycle %+v", last)
+	}
+	if last.Goals[0].Contribution != nzd(50) || last.Goals[0].Saved != nzd(250) || last.Goals[1].Saved != nzd(500) {
+		t.Errorf("expected goal contributions to stop once met, got %+v", last.Goals)
+	}
+	if len(forecast.Completions) != 2 || forecast.Completions[0].Name != "A" || forecast.Completions[0].Cycle != 3 {
+		t.Errorf("unexpected completions %+v", forecast.Completions)
+	}
+	if len(forecast.OverspentCycles) != 3 {
+		t.Errorf("expected every cycle to be overspent, got %v", forecast.OverspentCycles)
+	}
+	if userSave.Savings[0].Saved.Minor != 0 {
+		t.Error("expected forecasting not to change the save")
+	}
+
+	userSave.AllocationStrategy = StrategyPriority
+	forecast, err = ForecastCashFlow(userSave, now, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []GoalCompletion{{"B", 2, Date{2021, time.June, 15}}, {"A", 3, Date{2021, time.June, 22}}}
+	if len(forecast.Completions) != 2 || forecast.Completions[0] != expect[0] || forecast.Completions[1] != expect[1] {
+		t.Errorf("expected goals reallocated as they're met, got %+v", forecast.Completions)
+	}
+
+	userSave.PaySchedule = &PaySchedule{Anchor: Date{2021, time.June, 4}}
+	if forecast, err = ForecastCashFlow(userSave, now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if forecast.Cycles[0].Date != (Date{2021, time.June, 4}) {
+		t.Errorf("expected cycles on paydays, got %s", forecast.Cycles[0].Date)
+	}
+
+	if _, err := ForecastCashFlow(&JSONUserSave{}, now, 1); err != ErrNoCycle {
+		t.Errorf("expected save without a cycle to fail, got %v", err)
+	}
+}
+
+func TestForecastGoalsExceedingSavings(t *testing.T) {
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	userSave := &JSONUserSave{
+		Cycle:         CycleWeekly,
+		Income:        nzd(1000),
+		SavingsAmount: nzd(100),
+		Expenses:      []JSONExpense{{Amount: nzd(750)}},
+		Savings: []JSONSavings{
+			{Name: "A", Goal: nzd(400), Amount: nzd(200)},
+			{Name: "B", Goal: nzd(100), Amount: nzd(100)},
+		},
+	}
+
+	forecast, err := ForecastCashFlow(userSave, time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct{ unallocated, shortfall, remaining, balance Money }{
+		{nzd(0), nzd(200), nzd(-50), nzd(-50)},
+		{nzd(0), nzd(100), nzd(50), nzd(0)},
+		{nzd(100), nzd(0), nzd(150), nzd(150)},
+	}
+	for i, cycle := range forecast.Cycles {
+		if cycle.Unallocated != expect[i].unallocated || cycle.Shortfall != expect[i].shortfall ||
+			cycle.Remaining != expect[i].remaining || cycle.Balance != expect[i].balance {
+			t.Errorf("cycle %d: expected %+v, got %+v", cycle.Cycle, expect[i], cycle)
+		}
+	}
+	if len(forecast.OverspentCycles) != 1 || forecast.OverspentCycles[0] != 1 {
+		t.Errorf("expected only the first cycle overspent until the balance recovers, got %v", forecast.OverspentCycles)
+	}
+}
+
+func TestWriteForecastCSV(t *testing.T) {
+	userSave := &JSONUserSave{
+		Cycle:         CycleMonthly,
+		Income:        Money{100000, "NZD"},
+		SavingsAmount: Money{10000, "NZD"},
+		Savings:       []JSONSavings{{Name: "Car", Goal: Money{10000, "NZD"}, Amount: Money{10000, "NZD"}}},
+	}
+	forecast, err := ForecastCashFlow(userSave, time.Date(2021, time.January, 31, 0, 0, 0, 0, time.UTC), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := WriteForecastCSV(forecast, buffer); err != nil {
+		t.Fatal(err)
+	}
+	expect := strings.Join([]string{
+		"cycle,date,currency,income,paidToSavings,unallocated,shortfall,expenses,remaining,balance,overspent,completed,saved: Car",
+		"1,2021-02-28,NZD,1000.00,100.00,0.00,0.00,0.00,900.00,900.00,false,Car,100.00",
+		"2,2021-03-31,NZD,1000.00,100.00,100.00,0.00,0.00,900.00,1800.00,false,,100.00",
+		"",
+	}, "\n")
+	if buffer.String() != expect {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expect, buffer.String())
+	}
+}
@@ -0,0 +1,66 @@
+package usersave
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// budgetLine is the save without its tags, expenses and savings goals
+type budgetLine struct {
+	Type               string             `json:"type"`
+	SchemaVersion      int                `json:"schemaVersion"`
+	Cycle              Cycle              `json:"cycle"`
+	Income             Money              `json:"income"`
+	SavingsAmount      Money              `json:"savingsAmount"`
+	PaySchedule        *PaySchedule       `json:"paySchedule,omitempty"`
+	Timezone           string             `json:"timezone,omitempty"`
+	AllocationStrategy AllocationStrategy `json:"allocationStrategy,omitempty"`
+}
+
+type tagLine struct {
+	Type string `json:"type"`
+	TagDefinition
+}
+
+type expenseLine struct {
+	Type string `json:"type"`
+	JSONExpense
+}
+
+type savingsLine struct {
+	Type string `json:"type"`
+	JSONSavings
+}
+
+// WriteJSONLines writes the save as JSON Lines, a line for the budget then
+// one for each catalogued tag, expense and savings goal, their type telling
+// them apart
+func WriteJSONLines(userSave *JSONUserSave, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	lines := []interface{}{budgetLine{
+		Type:               "budget",
+		SchemaVersion:      userSave.SchemaVersion,
+		Cycle:              userSave.Cycle,
+		Income:             userSave.Income,
+		SavingsAmount:      userSave.SavingsAmount,
+		PaySchedule:        userSave.PaySchedule,
+		Timezone:           userSave.Timezone,
+		AllocationStrategy: userSave.AllocationStrategy,
+	}}
+	for _, tag := range userSave.Tags {
+		lines = append(lines, tagLine{"tag", tag})
+	}
+	for _, expense := range userSave.Expenses {
+		lines = append(lines, expenseLine{"expense", expense})
+	}
+	for _, savings := range userSave.Savings {
+		lines = append(lines, savingsLine{"savings", savings})
+	}
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to write JSON line: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package usersave
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteJSONLines(t *testing.T) {
+	validJSON := readValidJSON()
+	defer validJSON.Close()
+	userSave, err := DecodeUserSave(validJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Buffer{}
+	if err := WriteJSONLines(userSave, &lines); err != nil {
+		t.Fatal(err)
+	}
+	types := ""
+	scanner := bufio.NewScanner(&lines)
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected a JSON object per line, got %q: %s", scanner.Text(), err)
+		}
+		types += line["type"].(string) + " "
+		if line["type"] == "tag" && (line["id"] != "housing" || line["name"] != "Housing") {
+			t.Errorf("expected catalogued tag fields on its line, got %v", line)
+		}
+		if line["type"] == "expense" && line["name"] == "Expense A" && line["tag"] != "Housing" {
+			t.Errorf("expected expense fields on its line, got %v", line)
+		}
+		if _, ok := line["expenses"]; ok {
+			t.Errorf("expected expenses on their own lines, got %v", line)
+		}
+	}
+	if types != "budget tag expense expense savings " {
+		t.Errorf("expected budget, tag, expense and savings lines, got %s", types)
+	}
+}
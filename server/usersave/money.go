@@ -0,0 +1,223 @@
+package usersave
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrOverflow         = errors.New("money amount out of range")
+	ErrCurrencyMismatch = errors.New("money currencies differ")
+)
+
+// LegacyCurrency is the currency of amounts in saves from before currencies
+// were recorded
+var LegacyCurrency = "NZD"
+
+// currencyExponents lists the digits after the decimal point of currencies
+// which don't use 2
+var currencyExponents = map[string]int{
+	"BHD": 3, "BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3, "ISK": 0,
+	"JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3, "OMR": 3,
+	"PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// CurrencyExponent returns the digits after the decimal point of the
+// currency's amounts
+func CurrencyExponent(currency string) int {
+	if exponent, found := currencyExponents[currency]; found {
+		return exponent
+	}
+	return 2
+}
+
+// ValidCurrency returns true if the currency looks like an ISO 4217 code
+func ValidCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Money is an amount in the minor units of its currency, such as cents. It
+// encodes as JSON with the amount as a decimal string:
+// {"amount": "9000.33", "currency": "NZD"}
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// ParseMoney parses a decimal amount of the currency
+func ParseMoney(amount string, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, fmt.Errorf("invalid currency %q", currency)
+	}
+	exponent := CurrencyExponent(currency)
+
+	digits := strings.TrimPrefix(amount, "-")
+	negative := len(digits) < len(amount)
+	whole, fraction := digits, ""
+	if point := strings.IndexByte(digits, '.'); point >= 0 {
+		whole, fraction = digits[:point], digits[point+1:]
+	}
+	if len(whole) < 1 || len(fraction) > exponent || (len(fraction) < 1 && len(whole) < len(digits)) {
+		return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	minor := int64(0)
+	for _, c := range whole + fraction {
+		if c < '0' || c > '9' {
+			return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+		}
+		if minor > (math.MaxInt64-int64(c-'0'))/10 {
+			return Money{}, ErrOverflow
+		}
+		minor = minor*10 + int64(c-'0')
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{minor, currency}, nil
+}
+
+// String formats the amount as a decimal without the currency
+func (m Money) String() string {
+	exponent := CurrencyExponent(m.Currency)
+	sign := ""
+	magnitude := new(big.Int).SetInt64(m.Minor)
+	if m.Minor < 0 {
+		sign = "-"
+		magnitude.Neg(magnitude)
+	}
+	digits := magnitude.String()
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{m.String(), m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	decoded := jsonMoney{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("money must be an object of amount and currency: %w", err)
+	}
+	// zero amounts may leave out their currency, as Money{} encodes
+	if len(decoded.Currency) < 1 {
+		switch decoded.Amount {
+		case "", "0", "0.00":
+			*m = Money{}
+			return nil
+		}
+	}
+	parsed, err := ParseMoney(decoded.Amount, decoded.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// IsZero returns true for no money in any currency
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// currency returns the currency shared by both amounts. A zero amount without
+// a currency takes on the other's.
+func (m Money) currency(other Money) (string, error) {
+	switch {
+	case m.Currency == other.Currency:
+		return m.Currency, nil
+	case len(m.Currency) < 1 && m.IsZero():
+		return other.Currency, nil
+	case len(other.Currency) < 1 && other.IsZero():
+		return m.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+}
+
+// Add returns the sum of the amounts, which must share a currency
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.currency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	if (other.Minor > 0 && m.Minor > math.MaxInt64-other.Minor) ||
+		(other.Minor < 0 && m.Minor < math.MinInt64-other.Minor) {
+		return Money{}, ErrOverflow
+	}
+	return Money{m.Minor + other.Minor, currency}, nil
+}
+
+// Sub returns the difference of the amounts, which must share a currency
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Minor == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{-other.Minor, other.Currency})
+}
+
+// Mul returns the amount multiplied by n
+func (m Money) Mul(n int64) (Money, error) {
+	return m.Scale(n, 1)
+}
+
+// Scale returns the amount multiplied by num/den, rounded half away from zero
+// to the nearest minor unit
+func (m Money) Scale(num int64, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("money scaled by division by zero")
+	}
+	product := new(big.Int).Mul(big.NewInt(m.Minor), big.NewInt(num))
+	divisor := big.NewInt(den)
+	if divisor.Sign() < 0 {
+		product.Neg(product)
+		divisor.Neg(divisor)
+	}
+	half := new(big.Int).Quo(divisor, big.NewInt(2))
+	if product.Sign() < 0 {
+		product.Sub(product, half)
+	} else {
+		product.Add(product, half)
+	}
+	quotient := product.Quo(product, divisor)
+	if !quotient.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{quotient.Int64(), m.Currency}, nil
+}
+
+// SumMoney adds up the amounts, which must share a currency
+func SumMoney(amounts ...Money) (Money, error) {
+	total := Money{}
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
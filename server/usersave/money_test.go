@@ -0,0 +1,107 @@
+package usersave
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyString(t *testing.T) {
+	tests := map[Money]string{
+		{0, "NZD"}:             "0.00",
+		{5, "NZD"}:             "0.05",
+		{900033, "NZD"}:        "9000.33",
+		{-12345, "NZD"}:        "-123.45",
+		{1500, "JPY"}:          "1500",
+		{1500, "KWD"}:          "1.500",
+		{math.MinInt64, "NZD"}: "-92233720368547758.08",
+	}
+	for money, expect := range tests {
+		if got := money.String(); got != expect {
+			t.Errorf("expected %+v to format as %s, got %s", money, expect, got)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	valid := map[string]Money{
+		"9000.33": {900033, "NZD"},
+		"9000.3":  {900030, "NZD"},
+		"9000":    {900000, "NZD"},
+		"-0.05":   {-5, "NZD"},
+	}
+	for amount, expect := range valid {
+		if got, err := ParseMoney(amount, "NZD"); err != nil || got != expect {
+			t.Errorf("expected %s to parse as %+v, got %+v %v", amount, expect, got, err)
+		}
+	}
+
+	invalid := []string{"", "-", "1.", ".5", "1.005", "1,00", "+1", "1e5", "92233720368547758.08"}
+	for _, amount := range invalid {
+		if got, err := ParseMoney(amount, "NZD"); err == nil {
+			t.Errorf("expected %q to be invalid, got %+v", amount, got)
+		}
+	}
+	if _, err := ParseMoney("1", "nzd"); err == nil {
+		t.Error("expected lower case currency to be invalid")
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	encoded, err := json.Marshal(Money{900033, "NZD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `{"amount":"9000.33","currency":"NZD"}` {
+		t.Errorf("unexpected encoding %s", encoded)
+	}
+
+	decoded := Money{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded != (Money{900033, "NZD"}) {
+		t.Errorf("expected round trip, got %+v %v", decoded, err)
+	}
+	zero, _ := json.Marshal(Money{})
+	if err := json.Unmarshal(zero, &decoded); err != nil || decoded != (Money{}) {
+		t.Errorf("expected zero money without a currency to round trip, got %+v %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`900033`), &decoded); err == nil {
+		t.Error("expected bare number to be invalid")
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+
+	if sum, err := SumMoney(nzd(1), nzd(2), nzd(3)); err != nil || sum != nzd(6) {
+		t.Errorf("expected sum of 6, got %+v %v", sum, err)
+	}
+	if _, err := nzd(1).Add(Money{1, "AUD"}); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected currency mismatch, got %v", err)
+	}
+	if _, err := nzd(math.MaxInt64).Add(nzd(1)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected overflow, got %v", err)
+	}
+	if _, err := nzd(math.MinInt64).Sub(nzd(1)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected overflow, got %v", err)
+	}
+	if _, err := nzd(math.MaxInt64 / 2).Mul(3); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected overflow, got %v", err)
+	}
+
+	scales := []struct {
+		minor, num, den, expect int64
+	}{
+		{100, 26, 12, 217},
+		{5, 1, 2, 3},
+		{-5, 1, 2, -3},
+		{5, -1, 2, -3},
+		{math.MaxInt64, 12, 12, math.MaxInt64},
+	}
+	for _, scale := range scales {
+		got, err := nzd(scale.minor).Scale(scale.num, scale.den)
+		if err != nil || got != nzd(scale.expect) {
+			t.Errorf("expected %d * %d/%d = %d, got %+v %v", scale.minor, scale.num, scale.den, scale.expect, got, err)
+		}
+	}
+}
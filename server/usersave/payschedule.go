@@ -0,0 +1,205 @@
+package usersave
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNoPaySchedule = errors.New("usersave has no pay schedule")
+
+// maxPaydaySearch bounds how many cycles are stepped through from the
+// estimate to find the next payday
+const maxPaydaySearch = 8
+
+// BusinessDayAdjustment moves paydays falling on weekends
+type BusinessDayAdjustment string
+
+const (
+	// AdjustNone leaves paydays on weekends
+	AdjustNone BusinessDayAdjustment = ""
+	// AdjustPreceding moves paydays to the Friday before
+	AdjustPreceding BusinessDayAdjustment = "preceding"
+	// AdjustFollowing moves paydays to the Monday after
+	AdjustFollowing BusinessDayAdjustment = "following"
+)
+
+// Valid returns true if the adjustment is known
+func (a BusinessDayAdjustment) Valid() bool {
+	return a == AdjustNone || a == AdjustPreceding || a == AdjustFollowing
+}
+
+// Adjust moves the date off a weekend as the adjustment says
+func (a BusinessDayAdjustment) Adjust(date Date) Date {
+	weekday := date.In(time.UTC).Weekday()
+	switch {
+	case a == AdjustPreceding && weekday == time.Saturday:
+		return date.AddDays(-1)
+	case a == AdjustPreceding && weekday == time.Sunday:
+		return date.AddDays(-2)
+	case a == AdjustFollowing && weekday == time.Saturday:
+		return date.AddDays(2)
+	case a == AdjustFollowing && weekday == time.Sunday:
+		return date.AddDays(1)
+	}
+	return date
+}
+
+// PaySchedule places the save's cycle in time, paying every cycle before and
+// after the anchor
+type PaySchedule struct {
+	// Anchor is any payday, past or future
+	Anchor     Date                  `json:"anchor"`
+	Adjustment BusinessDayAdjustment `json:"adjustment,omitempty"`
+}
+
+// Payday is a day the user is paid, and what to move into savings
+type Payday struct {
+	Date Date `json:"date"`
+	// Scheduled is the date before any business day adjustment
+	Scheduled Date `json:"scheduled"`
+	// Savings is the SavingsAmount, paid into savings first
+	Savings Money `json:"savings"`
+	// Contributions are each goal's amount, until the goal is met
+	Contributions []GoalContribution `json:"contributions"`
+}
+
+// Period is the days from one payday up to the next
+type Period struct {
+	Start Date `json:"start"`
+	// End is the last day before the next payday
+	End Date `json:"end"`
+}
+
+// defaultPaySchedule places the cycles of saves without a pay schedule, so
+// monthly cycles are calendar months and weekly cycles start on Mondays
+var defaultPaySchedule = PaySchedule{Anchor: Date{2001, time.January, 1}}
+
+// scheduled returns the nth payday from the anchor, before adjustment
+func (p PaySchedule) scheduled(cycle Cycle, n int) Date {
+	return DateOf(cycle.Advance(p.Anchor.In(time.UTC), n))
+}
+
+// payday returns the nth payday from the anchor, after adjustment
+func (p PaySchedule) payday(cycle Cycle, n int) Date {
+	return p.Adjustment.Adjust(p.scheduled(cycle, n))
+}
+
+// estimate returns roughly which payday from the anchor falls on the date,
+// counting whole days for cycles of weeks and whole months for the rest
+func (p PaySchedule) estimate(cycle Cycle, date Date) int {
+	switch cycle {
+	case CycleWeekly, CycleFortnightly, CycleFourWeekly:
+		days := (date.In(time.UTC).Unix() - p.Anchor.In(time.UTC).Unix()) / (24 * 60 * 60)
+		return int(days / (7 * 52 / cycle.PerYear()))
+	}
+	months := (date.Year-p.Anchor.Year)*12 + int(date.Month-p.Anchor.Month)
+	return months / int(12/cycle.PerYear())
+}
+
+// next returns which payday from the anchor is the first on or after from,
+// stepping from the estimate past any rounding and business day adjustment
+func (p PaySchedule) next(cycle Cycle, from Date) (int, error) {
+	estimate := p.estimate(cycle, from)
+	n := estimate
+	for p.payday(cycle, n).Before(from) {
+		if n++; n > estimate+maxPaydaySearch {
+			return 0, fmt.Errorf("no payday found after %s from anchor %s", from, p.Anchor)
+		}
+	}
+	for !p.payday(cycle, n-1).Before(from) {
+		if n--; n < estimate-maxPaydaySearch {
+			return 0, fmt.Errorf("no payday found before %s from anchor %s", from, p.Anchor)
+		}
+	}
+	return n, nil
+}
+
+// Paydays lists the next count paydays from today in the user's timezone,
+// with what to move into each goal. Goals stop being contributed to once met.
+func Paydays(userSave *JSONUserSave, now time.Time, count int) ([]Payday, error) {
+	if !userSave.Cycle.Valid() {
+		return nil, ErrNoCycle
+	}
+	if userSave.PaySchedule == nil {
+		return nil, ErrNoPaySchedule
+	}
+	schedule := *userSave.PaySchedule
+
+	first, err := schedule.next(userSave.Cycle, Today(now, userSave.Location()))
+	if err != nil {
+		return nil, err
+	}
+
+	savings := append([]JSONSavings(nil), userSave.Savings...)
+	paydays := make([]Payday, 0, count)
+	for n := first; n < first+count; n++ {
+		contributions, err := contribute(savings)
+		if err != nil {
+			return nil, err
+		}
+		scheduled := schedule.scheduled(userSave.Cycle, n)
+		paydays = append(paydays, Payday{
+			Date:          schedule.Adjustment.Adjust(scheduled),
+			Scheduled:     scheduled,
+			Savings:       userSave.SavingsAmount,
+			Contributions: contributions,
+		})
+	}
+	return paydays, nil
+}
+
+// contribute pays each goal its amount, no more than it has left, adding it
+// to what's saved
+func contribute(savings []JSONSavings) ([]GoalContribution, error) {
+	contributions := make([]GoalContribution, len(savings))
+	for i := range savings {
+		remaining, err := savings[i].Goal.Sub(savings[i].Saved)
+		if err != nil {
+			return nil, fmt.Errorf("savings %q: %w", savings[i].Name, err)
+		}
+		contribution := savings[i].Amount
+		if contribution.Minor > remaining.Minor {
+			contribution.Minor = remaining.Minor
+		}
+		if contribution.Minor < 0 {
+			contribution.Minor = 0
+		}
+		if savings[i].Saved, err = savings[i].Saved.Add(contribution); err != nil {
+			return nil, fmt.Errorf("savings %q: %w", savings[i].Name, err)
+		}
+		contributions[i] = GoalContribution{savings[i].Name, contribution}
+	}
+	return contributions, nil
+}
+
+// Periods returns the count pay cycles up to and including the one today
+// falls in, oldest first, in the user's timezone. Saves without a pay schedule
+// have cycles counted from Monday 1 January 2001.
+func Periods(userSave *JSONUserSave, now time.Time, count int) ([]Period, error) {
+	if !userSave.Cycle.Valid() {
+		return nil, ErrNoCycle
+	}
+	schedule := defaultPaySchedule
+	if userSave.PaySchedule != nil {
+		schedule = *userSave.PaySchedule
+	}
+
+	today := Today(now, userSave.Location())
+	current, err := schedule.next(userSave.Cycle, today)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.payday(userSave.Cycle, current) != today {
+		current--
+	}
+
+	periods := make([]Period, 0, count)
+	for n := current - count + 1; n <= current; n++ {
+		periods = append(periods, Period{
+			Start: schedule.payday(userSave.Cycle, n),
+			End:   schedule.payday(userSave.Cycle, n+1).AddDays(-1),
+		})
+	}
+	return periods, nil
+}
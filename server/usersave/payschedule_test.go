@@ -0,0 +1,107 @@
+package usersave
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPaydays(t *testing.T) {
+	date := func(value string) Date {
+		parsed, err := ParseDate(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	at := func(value string) time.Time {
+		return date(value).In(time.UTC).Add(12 * time.Hour)
+	}
+
+	tests := []struct {
+		name     string
+		cycle    Cycle
+		schedule PaySchedule
+		now      time.Time
+		expect   []string
+	}{
+		{"fortnightly from past anchor", CycleFortnightly, PaySchedule{Anchor: date("2021-05-28")}, at("2021-06-01"),
+			[]string{"2021-06-11", "2021-06-25", "2021-07-09"}},
+		{"fortnightly from future anchor", CycleFortnightly, PaySchedule{Anchor: date("2021-07-09")}, at("2021-06-01"),
+			[]string{"2021-06-11", "2021-06-25", "2021-07-09"}},
+		{"payday today", CycleWeekly, PaySchedule{Anchor: date("2021-06-01")}, at("2021-06-01"),
+			[]string{"2021-06-01", "2021-06-08", "2021-06-15"}},
+		{"monthly preceding", CycleMonthly, PaySchedule{Anchor: date("2021-01-31"), Adjustment: AdjustPreceding}, at("2021-02-27"),
+			[]string{"2021-03-31", "2021-04-30", "2021-05-31"}},
+		{"monthly following", CycleMonthly, PaySchedule{Anchor: date("2021-07-31"), Adjustment: AdjustFollowing}, at("2021-08-01"),
+			[]string{"2021-08-02", "2021-08-31", "2021-09-30"}},
+		{"weekly from distant anchor", CycleWeekly, PaySchedule{Anchor: date("1901-01-07")}, at("2021-06-01"),
+			[]string{"2021-06-07", "2021-06-14", "2021-06-21"}},
+		{"quarterly from future anchor", CycleQuarterly, PaySchedule{Anchor: date("2031-01-15")}, at("2021-06-01"),
+			[]string{"2021-07-15", "2021-10-15", "2022-01-15"}},
+	}
+	for _, test := range tests {
+		userSave := &JSONUserSave{Cycle: test.cycle, PaySchedule: &test.schedule}
+		paydays, err := Paydays(userSave, test.now, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, payday := range paydays {
+			if payday.Date.String() != test.expect[i] {
+				t.Errorf("%s: expected payday %d on %s, got %s", test.name, i, test.expect[i], payday.Date)
+			}
+		}
+	}
+
+	userSave := &JSONUserSave{
+		Cycle:         CycleWeekly,
+		PaySchedule:   &PaySchedule{Anchor: date("2021-06-01")},
+		SavingsAmount: Money{100, "NZD"},
+		Savings:       []JSONSavings{{Name: "A", Goal: Money{250, "NZD"}, Amount: Money{100, "NZD"}}},
+	}
+	paydays, err := Paydays(userSave, at("2021-06-01"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expect := range []int64{100, 100, 50, 0} {
+		if got := paydays[i].Contributions[0].Contribution.Minor; got != expect || paydays[i].Savings.Minor != 100 {
+			t.Errorf("expected payday %d to contribute %d, got %d", i, expect, got)
+		}
+	}
+
+	userSave.PaySchedule = nil
+	if _, err := Paydays(userSave, at("2021-06-01"), 1); !errors.Is(err, ErrNoPaySchedule) {
+		t.Errorf("expected save without a pay schedule to fail, got %v", err)
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	tests := []struct {
+		cycle    Cycle
+		schedule *PaySchedule
+		now      time.Time
+		expect   []Period
+	}{
+		{CycleWeekly, nil, time.Date(2021, time.June, 2, 0, 0, 0, 0, time.UTC),
+			[]Period{{Date{2021, time.May, 24}, Date{2021, time.May, 30}}, {Date{2021, time.May, 31}, Date{2021, time.June, 6}}}},
+		{CycleMonthly, nil, time.Date(2021, time.March, 15, 0, 0, 0, 0, time.UTC),
+			[]Period{{Date{2021, time.February, 1}, Date{2021, time.February, 28}}, {Date{2021, time.March, 1}, Date{2021, time.March, 31}}}},
+		{CycleFortnightly, &PaySchedule{Anchor: Date{2021, time.June, 4}}, time.Date(2021, time.June, 4, 0, 0, 0, 0, time.UTC),
+			[]Period{{Date{2021, time.May, 21}, Date{2021, time.June, 3}}, {Date{2021, time.June, 4}, Date{2021, time.June, 17}}}},
+	}
+	for _, test := range tests {
+		periods, err := Periods(&JSONUserSave{Cycle: test.cycle, PaySchedule: test.schedule}, test.now, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, period := range periods {
+			if period != test.expect[i] {
+				t.Errorf("%s: expected period %d %+v, got %+v", test.cycle, i, test.expect[i], period)
+			}
+		}
+	}
+
+	if _, err := Periods(&JSONUserSave{}, time.Now(), 1); !errors.Is(err, ErrNoCycle) {
+		t.Errorf("expected save without a cycle to fail, got %v", err)
+	}
+}
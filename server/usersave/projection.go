@@ -0,0 +1,140 @@
+package usersave
+
+import (
+	"fmt"
+	"time"
+)
+
+// GoalStatus describes whether a savings goal will be met
+type GoalStatus string
+
+const (
+	// GoalComplete has already been saved
+	GoalComplete GoalStatus = "complete"
+	// GoalOnTrack will be met by its deadline, if it has one
+	GoalOnTrack GoalStatus = "on-track"
+	// GoalAtRisk won't be met by its deadline at the current contribution
+	GoalAtRisk GoalStatus = "at-risk"
+	// GoalStalled has no deadline and nothing contributed to it
+	GoalStalled GoalStatus = "stalled"
+)
+
+// Projection forecasts a savings goal, saving its amount every cycle
+type Projection struct {
+	Name         string `json:"name"`
+	Goal         Money  `json:"goal"`
+	Saved        Money  `json:"saved"`
+	Contribution Money  `json:"contribution"`
+	// Remaining is what is left to save
+	Remaining Money `json:"remaining"`
+	Deadline  *Date `json:"deadline,omitempty"`
+	// CyclesToDeadline counts the paydays left before the deadline
+	CyclesToDeadline int `json:"cyclesToDeadline,omitempty"`
+	// RequiredPerCycle is what must be saved each payday to meet the deadline
+	RequiredPerCycle *Money `json:"requiredPerCycle,omitempty"`
+	// CyclesToComplete counts the paydays until the goal is met, if ever
+	CyclesToComplete    int        `json:"cyclesToComplete,omitempty"`
+	ProjectedCompletion *time.Time `json:"projectedCompletion,omitempty"`
+	Status              GoalStatus `json:"status"`
+}
+
+// maxProjectedCycles bounds projections to a century of weekly paydays
+const maxProjectedCycles = 52 * 100
+
+// ceilDiv divides positive a by positive b, rounding up
+func ceilDiv(a int64, b int64) int64 {
+	quotient := a / b
+	if a%b != 0 {
+		quotient++
+	}
+	return quotient
+}
+
+// cyclesUntil counts the paydays after now, up to and including the deadline,
+// no more than maxProjectedCycles
+func cyclesUntil(cycle Cycle, now time.Time, deadline time.Time) int {
+	cycles := 0
+	for cycles < maxProjectedCycles && !cycle.Advance(now, cycles+1).After(deadline) {
+		cycles++
+	}
+	return cycles
+}
+
+// DeadlineTime returns the end of the day the goal is due in the location,
+// if it has a deadline
+func (s JSONSavings) DeadlineTime(loc *time.Location) (time.Time, bool) {
+	if s.Deadline == nil {
+		return time.Time{}, false
+	}
+	return s.Deadline.AddDays(1).In(loc).Add(-time.Nanosecond), true
+}
+
+// Project forecasts the goal from now, contributing its amount every cycle.
+// Deadlines are compared in now's location.
+func (s JSONSavings) Project(cycle Cycle, now time.Time) (Projection, error) {
+	if !cycle.Valid() {
+		return Projection{}, ErrNoCycle
+	}
+	remaining, err := s.Goal.Sub(s.Saved)
+	if err != nil {
+		return Projection{}, fmt.Errorf("savings %q: %w", s.Name, err)
+	}
+	if remaining.Minor < 0 {
+		remaining.Minor = 0
+	}
+	projection := Projection{
+		Name:         s.Name,
+		Goal:         s.Goal,
+		Saved:        s.Saved,
+		Contribution: s.Amount,
+		Remaining:    remaining,
+	}
+
+	if remaining.IsZero() {
+		projection.Status = GoalComplete
+	} else if s.Amount.Minor > 0 {
+		// goals taking longer than maxProjectedCycles are treated as never met
+		if cycles := ceilDiv(remaining.Minor, s.Amount.Minor); cycles <= maxProjectedCycles {
+			projection.CyclesToComplete = int(cycles)
+			completion := cycle.Advance(now, projection.CyclesToComplete)
+			projection.ProjectedCompletion = &completion
+		}
+	}
+
+	deadline, hasDeadline := s.DeadlineTime(now.Location())
+	if hasDeadline {
+		projection.Deadline = s.Deadline
+		projection.CyclesToDeadline = cyclesUntil(cycle, now, deadline)
+		required := remaining
+		if projection.CyclesToDeadline > 0 {
+			required.Minor = ceilDiv(remaining.Minor, int64(projection.CyclesToDeadline))
+		}
+		projection.RequiredPerCycle = &required
+	}
+
+	switch {
+	case projection.Status == GoalComplete:
+	case hasDeadline && (projection.ProjectedCompletion == nil || projection.ProjectedCompletion.After(deadline)):
+		projection.Status = GoalAtRisk
+	case projection.ProjectedCompletion == nil:
+		projection.Status = GoalStalled
+	default:
+		projection.Status = GoalOnTrack
+	}
+	return projection, nil
+}
+
+// ProjectSavings forecasts every savings goal in the save from now, in the
+// user's timezone
+func ProjectSavings(userSave *JSONUserSave, now time.Time) ([]Projection, error) {
+	now = now.In(userSave.Location())
+	projections := make([]Projection, 0, len(userSave.Savings))
+	for _, savings := range userSave.Savings {
+		projection, err := savings.Project(userSave.Cycle, now)
+		if err != nil {
+			return nil, err
+		}
+		projections = append(projections, projection)
+	}
+	return projections, nil
+}
@@ -0,0 +1,74 @@
+package usersave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProject(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	may := Date{2021, time.May, 1}
+	july := Date{2021, time.July, 1}
+	december := Date{2021, time.December, 1}
+
+	tests := []struct {
+		name             string
+		savings          JSONSavings
+		status           GoalStatus
+		required         int64
+		cyclesToComplete int
+	}{
+		{"complete", JSONSavings{Goal: nzd(1000), Saved: nzd(1500), Amount: nzd(10)}, GoalComplete, 0, 0},
+		{"on track", JSONSavings{Goal: nzd(1000), Saved: nzd(500), Amount: nzd(100), Deadline: &december}, GoalOnTrack, 39, 5},
+		{"at risk", JSONSavings{Goal: nzd(10000), Amount: nzd(100), Deadline: &december}, GoalAtRisk, 770, 100},
+		{"nothing contributed", JSONSavings{Goal: nzd(1000), Deadline: &july}, GoalAtRisk, 500, 0},
+		{"deadline passed", JSONSavings{Goal: nzd(1000), Amount: nzd(100), Deadline: &may}, GoalAtRisk, 1000, 10},
+		{"no deadline", JSONSavings{Goal: nzd(1000), Amount: nzd(300)}, GoalOnTrack, 0, 4},
+		{"stalled", JSONSavings{Goal: nzd(1000)}, GoalStalled, 0, 0},
+		{"never", JSONSavings{Goal: nzd(1 << 40), Amount: nzd(1)}, GoalStalled, 0, 0},
+	}
+	for _, test := range tests {
+		projection, err := test.savings.Project(CycleFortnightly, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if projection.Status != test.status {
+			t.Errorf("%s: expected status %s, got %s", test.name, test.status, projection.Status)
+		}
+		if test.required > 0 && (projection.RequiredPerCycle == nil || projection.RequiredPerCycle.Minor != test.required) {
+			t.Errorf("%s: expected %d required per cycle, got %+v", test.name, test.required, projection.RequiredPerCycle)
+		}
+		if projection.CyclesToComplete != test.cyclesToComplete {
+			t.Errorf("%s: expected %d cycles to complete, got %d", test.name, test.cyclesToComplete, projection.CyclesToComplete)
+		}
+		if (projection.ProjectedCompletion != nil) != (test.cyclesToComplete > 0) {
+			t.Errorf("%s: expected projected completion only when it will complete, got %v", test.name, projection.ProjectedCompletion)
+		}
+	}
+
+	projection, _ := JSONSavings{Goal: nzd(1000), Amount: nzd(100), Deadline: &december}.Project(CycleFortnightly, now)
+	if *projection.Deadline != december || projection.CyclesToDeadline != 13 {
+		t.Errorf("expected 13 fortnights to a deadline 6 months away, got %d to %s", projection.CyclesToDeadline, projection.Deadline)
+	}
+	if projection.ProjectedCompletion.Format("2006-01-02") != "2021-10-19" {
+		t.Errorf("expected completion after 10 fortnights, got %s", projection.ProjectedCompletion)
+	}
+
+	auckland := &JSONUserSave{Cycle: CycleWeekly, Timezone: "Pacific/Auckland", Savings: []JSONSavings{
+		{Goal: nzd(100), Amount: nzd(100), Deadline: &Date{2021, time.June, 8}},
+	}}
+	// 1pm on the 31st of May in UTC is already the 1st of June in Auckland,
+	// so the first payday is the 8th there
+	projections, err := ProjectSavings(auckland, time.Date(2021, time.May, 31, 13, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projections[0].CyclesToDeadline != 1 || projections[0].Status != GoalOnTrack {
+		t.Errorf("expected deadline compared in the user's timezone, got %+v", projections[0])
+	}
+
+	if _, err := ProjectSavings(&JSONUserSave{Savings: []JSONSavings{{}}}, now); err == nil {
+		t.Error("expected projecting without a cycle to fail")
+	}
+}
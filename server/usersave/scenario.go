@@ -0,0 +1,253 @@
+package usersave
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Adjustment changes an amount in a save, by a percentage or to a new amount
+type Adjustment struct {
+	// Target names the amount: "income", "savingsAmount", "expenses/<name>",
+	// or "savings/<name>/amount", "savings/<name>/goal" or
+	// "savings/<name>/saved"
+	Target string `json:"target"`
+	// Percent changes the amount by a decimal percentage, such as "10" or
+	// "-2.5"
+	Percent string `json:"percent,omitempty"`
+	// Set replaces the amount
+	Set *Money `json:"set,omitempty"`
+}
+
+// target finds the amount the adjustment targets in the save
+func (a Adjustment) target(userSave *JSONUserSave) (*Money, error) {
+	switch a.Target {
+	case "income":
+		return &userSave.Income, nil
+	case "savingsAmount":
+		return &userSave.SavingsAmount, nil
+	}
+	if name := strings.TrimPrefix(a.Target, "expenses/"); name != a.Target {
+		for i := range userSave.Expenses {
+			if userSave.Expenses[i].Name == name {
+				return &userSave.Expenses[i].Amount, nil
+			}
+		}
+		return nil, fmt.Errorf("no expense named %q", name)
+	}
+	if path := strings.TrimPrefix(a.Target, "savings/"); path != a.Target {
+		separator := strings.LastIndex(path, "/")
+		if separator < 0 {
+			return nil, fmt.Errorf("adjustment target %q has no savings field", a.Target)
+		}
+		name, field := path[:separator], path[separator+1:]
+		for i := range userSave.Savings {
+			if userSave.Savings[i].Name != name {
+				continue
+			}
+			switch field {
+			case "amount":
+				return &userSave.Savings[i].Amount, nil
+			case "goal":
+				return &userSave.Savings[i].Goal, nil
+			case "saved":
+				return &userSave.Savings[i].Saved, nil
+			}
+			return nil, fmt.Errorf("unknown savings field %q", field)
+		}
+		return nil, fmt.Errorf("no savings named %q", name)
+	}
+	return nil, fmt.Errorf("unknown adjustment target %q", a.Target)
+}
+
+// apply makes the adjustment to the save
+func (a Adjustment) apply(userSave *JSONUserSave) error {
+	amount, err := a.target(userSave)
+	if err != nil {
+		return err
+	}
+	if (a.Set == nil) == (len(a.Percent) < 1) {
+		return fmt.Errorf("adjustment of %q must either set an amount or change it by a percent", a.Target)
+	}
+	if a.Set != nil {
+		*amount = *a.Set
+		return nil
+	}
+
+	percent, ok := new(big.Rat).SetString(a.Percent)
+	if !ok {
+		return fmt.Errorf("invalid percent %q", a.Percent)
+	}
+	factor := new(big.Rat).Add(big.NewRat(1, 1), percent.Quo(percent, big.NewRat(100, 1)))
+	if !factor.Num().IsInt64() || !factor.Denom().IsInt64() {
+		return fmt.Errorf("percent %q is too precise: %w", a.Percent, ErrOverflow)
+	}
+	scaled, err := amount.Scale(factor.Num().Int64(), factor.Denom().Int64())
+	if err != nil {
+		return fmt.Errorf("failed to adjust %q: %w", a.Target, err)
+	}
+	*amount = scaled
+	return nil
+}
+
+// Adjust returns a copy of the save with the adjustments made in order,
+// leaving the save unchanged
+func Adjust(userSave *JSONUserSave, adjustments []Adjustment) (*JSONUserSave, error) {
+	adjusted := *userSave
+	adjusted.Savings = append([]JSONSavings(nil), userSave.Savings...)
+	adjusted.Expenses = append([]JSONExpense(nil), userSave.Expenses...)
+	for _, adjustment := range adjustments {
+		if err := adjustment.apply(&adjusted); err != nil {
+			return nil, err
+		}
+	}
+	return &adjusted, nil
+}
+
+// Outcome is what a plan comes to
+type Outcome struct {
+	Summary  Summary  `json:"summary"`
+	Forecast Forecast `json:"forecast"`
+}
+
+// GoalDifference compares when a goal is met, and how much is saved towards it
+// by the end of the forecast, between two plans. Goals only in one plan have
+// no cycle in the other.
+type GoalDifference struct {
+	Name string `json:"name"`
+	// CurrentCycle and SimulatedCycle are the cycles the goal is met in, each
+	// counted in its own plan's cycle, if it is within the forecast.
+	// CurrentDate and SimulatedDate are the paydays it's met on.
+	CurrentCycle   *int  `json:"currentCycle,omitempty"`
+	SimulatedCycle *int  `json:"simulatedCycle,omitempty"`
+	CurrentDate    *Date `json:"currentDate,omitempty"`
+	SimulatedDate  *Date `json:"simulatedDate,omitempty"`
+	Saved          Money `json:"saved"`
+}
+
+// OutcomeDifference is how much the simulated outcome is above the current
+type OutcomeDifference struct {
+	Income        Money `json:"income"`
+	PaidToSavings Money `json:"paidToSavings"`
+	Expenses      Money `json:"expenses"`
+	Remaining     Money `json:"remaining"`
+	// Balance compares the remaining money at the end of the forecast
+	Balance         Money            `json:"balance"`
+	OverspentCycles int              `json:"overspentCycles"`
+	Goals           []GoalDifference `json:"goals"`
+}
+
+// Simulation compares a hypothetical plan to the current one
+type Simulation struct {
+	Current    Outcome           `json:"current"`
+	Simulated  Outcome           `json:"simulated"`
+	Difference OutcomeDifference `json:"difference"`
+}
+
+// outcome summarises the save in the cycle and forecasts count of its cycles
+// from now
+func outcome(userSave *JSONUserSave, cycle Cycle, now time.Time, count int) (Outcome, error) {
+	summary, err := Summarize(userSave, cycle)
+	if err != nil {
+		return Outcome{}, err
+	}
+	forecast, err := ForecastCashFlow(userSave, now, count)
+	if err != nil {
+		return Outcome{}, err
+	}
+	return Outcome{summary, forecast}, nil
+}
+
+// goalEnds returns what's saved towards each goal by the end of the forecast
+func (f Forecast) goalEnds() map[string]Money {
+	saved := map[string]Money{}
+	if len(f.Cycles) > 0 {
+		for _, goal := range f.Cycles[len(f.Cycles)-1].Goals {
+			saved[goal.Name] = goal.Saved
+		}
+	}
+	return saved
+}
+
+// completion returns the cycle the goal is met in and its payday, nil if it
+// isn't
+func (f Forecast) completion(name string) (*int, *Date) {
+	for _, completion := range f.Completions {
+		if completion.Name == name {
+			cycle, date := completion.Cycle, completion.Date
+			return &cycle, &date
+		}
+	}
+	return nil, nil
+}
+
+// Simulate compares the outcome of the simulated save to the current save
+// over count cycles from now. A simulated save with a different cycle is
+// summarised in the current save's cycle, and forecast over as many of its
+// own cycles as cover the current save's, rounding up.
+func Simulate(current *JSONUserSave, simulated *JSONUserSave, now time.Time, count int) (Simulation, error) {
+	if !current.Cycle.Valid() {
+		return Simulation{}, fmt.Errorf("current save: %w", ErrNoCycle)
+	}
+	if !simulated.Cycle.Valid() {
+		return Simulation{}, fmt.Errorf("simulated save: %w", ErrNoCycle)
+	}
+	simulatedCount := int(ceilDiv(int64(count)*simulated.Cycle.PerYear(), current.Cycle.PerYear()))
+
+	var simulation Simulation
+	var err error
+	if simulation.Current, err = outcome(current, current.Cycle, now, count); err != nil {
+		return Simulation{}, fmt.Errorf("current save: %w", err)
+	}
+	if simulation.Simulated, err = outcome(simulated, current.Cycle, now, simulatedCount); err != nil {
+		return Simulation{}, fmt.Errorf("simulated save: %w", err)
+	}
+
+	was, is := simulation.Current, simulation.Simulated
+	difference := OutcomeDifference{
+		OverspentCycles: len(is.Forecast.OverspentCycles) - len(was.Forecast.OverspentCycles),
+		Goals:           []GoalDifference{},
+	}
+	type moneyDifference struct {
+		was, is Money
+		to      *Money
+	}
+	differences := []moneyDifference{
+		{was.Summary.Income, is.Summary.Income, &difference.Income},
+		{was.Summary.PaidToSavings, is.Summary.PaidToSavings, &difference.PaidToSavings},
+		{was.Summary.Expenses, is.Summary.Expenses, &difference.Expenses},
+		{was.Summary.Remaining, is.Summary.Remaining, &difference.Remaining},
+	}
+	if count > 0 {
+		differences = append(differences, moneyDifference{
+			was.Forecast.Cycles[count-1].Balance, is.Forecast.Cycles[simulatedCount-1].Balance, &difference.Balance,
+		})
+	}
+	for _, amount := range differences {
+		if *amount.to, err = amount.is.Sub(amount.was); err != nil {
+			return Simulation{}, err
+		}
+	}
+
+	goals := []string{}
+	seen := map[string]bool{}
+	for _, savings := range append(append([]JSONSavings(nil), current.Savings...), simulated.Savings...) {
+		if !seen[savings.Name] {
+			seen[savings.Name] = true
+			goals = append(goals, savings.Name)
+		}
+	}
+	wasSaved, isSaved := was.Forecast.goalEnds(), is.Forecast.goalEnds()
+	for _, name := range goals {
+		goal := GoalDifference{Name: name}
+		goal.CurrentCycle, goal.CurrentDate = was.Forecast.completion(name)
+		goal.SimulatedCycle, goal.SimulatedDate = is.Forecast.completion(name)
+		if goal.Saved, err = isSaved[name].Sub(wasSaved[name]); err != nil {
+			return Simulation{}, fmt.Errorf("savings %q: %w", name, err)
+		}
+		difference.Goals = append(difference.Goals, goal)
+	}
+	simulation.Difference = difference
+	return simulation, nil
+}
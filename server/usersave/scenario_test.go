@@ -0,0 +1,122 @@
+package usersave
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdjust(t *testing.T) {
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	userSave := &JSONUserSave{
+		Income:        nzd(100000),
+		SavingsAmount: nzd(10000),
+		Expenses:      []JSONExpense{{Name: "Rent", Amount: nzd(50000)}},
+		Savings:       []JSONSavings{{Name: "Car/Van", Goal: nzd(500000), Amount: nzd(10000)}},
+	}
+
+	adjusted, err := Adjust(userSave, []Adjustment{
+		{Target: "savingsAmount", Percent: "10"},
+		{Target: "income", Percent: "-2.5"},
+		{Target: "expenses/Rent", Set: &Money{45000, "NZD"}},
+		{Target: "savings/Car/Van/goal", Percent: "0.001"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adjusted.SavingsAmount != nzd(11000) || adjusted.Income != nzd(97500) {
+		t.Errorf("expected amounts changed by percent, got %+v", adjusted)
+	}
+	if adjusted.Expenses[0].Amount != nzd(45000) || adjusted.Savings[0].Goal != nzd(500005) {
+		t.Errorf("expected expenses and savings adjusted, got %+v %+v", adjusted.Expenses, adjusted.Savings)
+	}
+	if userSave.SavingsAmount != nzd(10000) || userSave.Expenses[0].Amount != nzd(50000) || userSave.Savings[0].Goal != nzd(500000) {
+		t.Errorf("expected the save to be unchanged, got %+v", userSave)
+	}
+
+	invalid := []Adjustment{
+		{Target: "cycle", Percent: "10"},
+		{Target: "expenses/Food", Percent: "10"},
+		{Target: "savings/Car/Van/deadline", Percent: "10"},
+		{Target: "savings/Car", Percent: "10"},
+		{Target: "income"},
+		{Target: "income", Percent: "10", Set: &Money{1, "NZD"}},
+		{Target: "income", Percent: "ten"},
+	}
+	for _, adjustment := range invalid {
+		if _, err := Adjust(userSave, []Adjustment{adjustment}); err == nil {
+			t.Errorf("expected adjustment %+v to fail", adjustment)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	current := &JSONUserSave{
+		Cycle:         CycleWeekly,
+		Income:        nzd(1000),
+		SavingsAmount: nzd(100),
+		Expenses:      []JSONExpense{{Name: "Rent", Amount: nzd(850)}},
+		Savings:       []JSONSavings{{Name: "Car", Goal: nzd(400), Amount: nzd(100)}},
+	}
+	simulated, err := Adjust(current, []Adjustment{
+		{Target: "savingsAmount", Percent: "100"},
+		{Target: "savings/Car/amount", Percent: "100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulated.Savings = append(simulated.Savings, JSONSavings{Name: "Boat", Goal: nzd(1000)})
+
+	simulation, err := Simulate(current, simulated, now, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	difference := simulation.Difference
+	if difference.PaidToSavings != nzd(100) || difference.Remaining != nzd(-100) || difference.Balance != nzd(-300) {
+		t.Errorf("unexpected difference %+v", difference)
+	}
+	if difference.OverspentCycles != 3 {
+		t.Errorf("expected simulation to overspend every cycle, got %d", difference.OverspentCycles)
+	}
+	if len(difference.Goals) != 2 {
+		t.Fatalf("expected goals from both saves, got %+v", difference.Goals)
+	}
+	car := difference.Goals[0]
+	if car.CurrentCycle != nil || car.SimulatedCycle == nil || *car.SimulatedCycle != 2 || car.Saved != nzd(100) {
+		t.Errorf("expected car to be met sooner, got %+v", car)
+	}
+	if boat := difference.Goals[1]; boat.Name != "Boat" || boat.CurrentCycle != nil || boat.SimulatedCycle != nil {
+		t.Errorf("unexpected boat %+v", boat)
+	}
+
+	// the same plan paid fortnightly comes to the same
+	fortnightly := &JSONUserSave{
+		Cycle:         CycleFortnightly,
+		Income:        nzd(2000),
+		SavingsAmount: nzd(200),
+		Expenses:      []JSONExpense{{Name: "Rent", Amount: nzd(1700)}},
+		Savings:       []JSONSavings{{Name: "Car", Goal: nzd(400), Amount: nzd(200)}},
+	}
+	simulation, err = Simulate(current, fortnightly, now, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simulation.Simulated.Summary.Cycle != CycleWeekly || len(simulation.Simulated.Forecast.Cycles) != 2 {
+		t.Errorf("expected a weekly summary and 2 fortnights forecast, got %+v", simulation.Simulated)
+	}
+	difference = simulation.Difference
+	if !difference.Income.IsZero() || !difference.Remaining.IsZero() || !difference.Balance.IsZero() {
+		t.Errorf("expected no difference paid fortnightly, got %+v", difference)
+	}
+	car = difference.Goals[0]
+	if *car.CurrentCycle != 4 || *car.SimulatedCycle != 2 || *car.CurrentDate != *car.SimulatedDate {
+		t.Errorf("expected car to be met on the same day, got %+v", car)
+	}
+
+	simulated.Cycle = "Daily"
+	if _, err := Simulate(current, simulated, now, 3); !errors.Is(err, ErrNoCycle) {
+		t.Errorf("expected a save without a known cycle to fail, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package usersave
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CurrentSchemaVersion is the version of JSONUserSave this server reads and
+// writes. Saves without a schemaVersion are version 0.
+const CurrentSchemaVersion = 4
+
+var ErrNewerSchema = errors.New("usersave is from a newer schema version")
+
+// document is a usersave decoded without a schema, numbers kept as
+// json.Numbers so migrations don't lose precision
+type document = map[string]interface{}
+
+// migration upgrades a document from one version to the next, in place
+type migration func(doc document) error
+
+// migrations are keyed by the version they upgrade from. Each new schema
+// version needs a migration from the one before.
+var migrations = map[int]migration{
+	// version 1 only adds the schemaVersion field
+	0: func(doc document) error { return nil },
+	// version 2 makes amounts Money, in the LegacyCurrency
+	1: migrateMoney,
+	// version 3 makes savings deadlines dates
+	2: migrateDeadlines,
+	// version 4 catalogues the tags of expenses
+	3: migrateTags,
+}
+
+// schemaVersion reads the document's version, 0 if it has none
+func schemaVersion(doc document) (int, error) {
+	raw, found := doc["schemaVersion"]
+	if !found || raw == nil {
+		return 0, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("schemaVersion must be a number, got %v", raw)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid schemaVersion %s", number)
+	}
+	return int(version), nil
+}
+
+// upgradeDocument migrates the document step by step to the current version
+func upgradeDocument(doc document) error {
+	version, err := schemaVersion(doc)
+	if err != nil {
+		return err
+	}
+	if version > CurrentSchemaVersion {
+		return fmt.Errorf("%w: %d, server understands up to %d", ErrNewerSchema, version, CurrentSchemaVersion)
+	}
+
+	for ; version < CurrentSchemaVersion; version++ {
+		migrate, found := migrations[version]
+		if !found {
+			return fmt.Errorf("no migration from usersave schema version %d", version)
+		}
+		if err := migrate(doc); err != nil {
+			return fmt.Errorf("failed to migrate usersave from schema version %d: %w", version, err)
+		}
+	}
+	doc["schemaVersion"] = CurrentSchemaVersion
+	return nil
+}
+
+// legacyMoney converts an amount of cents into a Money document
+func legacyMoney(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("amount must be a number, got %v", value)
+	}
+	cents, err := number.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("amount must be a whole number of cents, got %s", number)
+	}
+	money := Money{cents, LegacyCurrency}
+	return document{"amount": money.String(), "currency": money.Currency}, nil
+}
+
+// migrateFields converts each of the fields present in the document
+func migrateFields(doc document, convert func(interface{}) (interface{}, error), fields ...string) error {
+	for _, field := range fields {
+		value, found := doc[field]
+		if !found {
+			continue
+		}
+		converted, err := convert(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		doc[field] = converted
+	}
+	return nil
+}
+
+// migrateList converts the fields of each document in the list
+func migrateList(doc document, list string, convert func(interface{}) (interface{}, error), fields ...string) error {
+	items, _ := doc[list].([]interface{})
+	for i, item := range items {
+		itemDoc, ok := item.(document)
+		if !ok {
+			continue
+		}
+		if err := migrateFields(itemDoc, convert, fields...); err != nil {
+			return fmt.Errorf("%s %d: %w", list, i, err)
+		}
+	}
+	return nil
+}
+
+func migrateMoney(doc document) error {
+	if err := migrateFields(doc, legacyMoney, "income", "savingsAmount"); err != nil {
+		return err
+	}
+	if err := migrateList(doc, "savings", legacyMoney, "goal", "amount"); err != nil {
+		return err
+	}
+	return migrateList(doc, "expenses", legacyMoney, "amount")
+}
+
+// earliestLegacyDeadline is the first day a legacy deadline can be. Earlier
+// ones weren't dates since the epoch, such as a count of months, and are
+// dropped rather than read as days in 1970.
+var earliestLegacyDeadline = Date{2000, time.January, 1}
+
+// legacyDeadline converts a deadline of unknown unit into a date. Clients have
+// sent days, seconds or milliseconds since the epoch, which are told apart by
+// size.
+func legacyDeadline(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("deadline must be a number, got %v", value)
+	}
+	n, err := number.Int64()
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid deadline %s", number)
+	}
+
+	var deadline time.Time
+	switch {
+	case n == 0:
+		return nil, nil
+	case n < 1e6:
+		deadline = time.Unix(0, 0).UTC().AddDate(0, 0, int(n))
+	case n < 1e11:
+		deadline = time.Unix(n, 0).UTC()
+	default:
+		deadline = time.Unix(n/1000, n%1000*int64(time.Millisecond)).UTC()
+	}
+	if DateOf(deadline).Before(earliestLegacyDeadline) {
+		return nil, nil
+	}
+	return DateOf(deadline).String(), nil
+}
+
+func migrateDeadlines(doc document) error {
+	return migrateList(doc, "savings", legacyDeadline, "deadline")
+}
+
+// tagID makes an ID for the tag from its name, unique among those taken
+func tagID(name string, taken map[string]bool) string {
+	id := strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, name), "-")
+	if len(id) < 1 {
+		id = "tag"
+	}
+	unique := id
+	for n := 2; taken[unique]; n++ {
+		unique = id + "-" + strconv.Itoa(n)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// migrateTags catalogues each tag used by an expense, in the order they're
+// first used. IDs are made from the names so decoding is repeatable.
+func migrateTags(doc document) error {
+	tags := []interface{}{}
+	catalogued, taken := map[string]bool{}, map[string]bool{}
+	expenses, _ := doc["expenses"].([]interface{})
+	for _, item := range expenses {
+		expense, _ := item.(document)
+		name, _ := expense["tag"].(string)
+		if len(name) < 1 || catalogued[name] {
+			continue
+		}
+		catalogued[name] = true
+		tags = append(tags, document{"id": tagID(name, taken), "name": name})
+	}
+	doc["tags"] = tags
+	return nil
+}
@@ -0,0 +1,135 @@
+package usersave
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsCoverEveryVersion(t *testing.T) {
+	for version := 0; version < CurrentSchemaVersion; version++ {
+		if _, found := migrations[version]; !found {
+			t.Errorf("no migration from version %d", version)
+		}
+	}
+}
+
+func TestDecodeUpgradesSchema(t *testing.T) {
+	validJSON := readValidJSON()
+	defer validJSON.Close()
+	userSave, err := DecodeUserSave(validJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSave.SchemaVersion != CurrentSchemaVersion {
+		t.Errorf("expected unversioned save to be upgraded to %d, got %d", CurrentSchemaVersion, userSave.SchemaVersion)
+	}
+
+	encoded := bytes.Buffer{}
+	unversioned := &JSONUserSave{}
+	if err := EncodeUserSave(unversioned, &encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(encoded.String(), fmt.Sprintf(`"schemaVersion":%d`, CurrentSchemaVersion)) {
+		t.Errorf("expected current schema version to be written, got %s", encoded.String())
+	}
+	if unversioned.SchemaVersion != 0 {
+		t.Errorf("expected encoding not to change the save, got version %d", unversioned.SchemaVersion)
+	}
+}
+
+func TestDecodeRejectsSchema(t *testing.T) {
+	_, err := DecodeUserSave(strings.NewReader(`{"schemaVersion": 999}`))
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("expected newer schema to be rejected, got %v", err)
+	}
+
+	invalid := []string{`{"schemaVersion": "1"}`, `{"schemaVersion": -1}`, `{"schemaVersion": 1.5}`}
+	for _, doc := range invalid {
+		if _, err := DecodeUserSave(strings.NewReader(doc)); err == nil || errors.Is(err, ErrNewerSchema) {
+			t.Errorf("%s: expected invalid schema version, got %v", doc, err)
+		}
+	}
+}
+
+func TestMigrateMoney(t *testing.T) {
+	userSave, err := DecodeUserSave(strings.NewReader(
+		`{"schemaVersion": 1, "income": 900033, "savings": [{"goal": 100}], "expenses": [{"amount": -1}]}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSave.Income != (Money{900033, LegacyCurrency}) {
+		t.Errorf("expected income in legacy currency, got %+v", userSave.Income)
+	}
+	if userSave.Savings[0].Goal.Minor != 100 || userSave.Savings[0].Amount != (Money{}) {
+		t.Errorf("expected goal migrated and missing amount left zero, got %+v", userSave.Savings[0])
+	}
+	if userSave.Expenses[0].Amount.Minor != -1 {
+		t.Errorf("expected expense migrated, got %+v", userSave.Expenses[0])
+	}
+
+	if _, err := DecodeUserSave(strings.NewReader(`{"schemaVersion": 1, "income": 1.5}`)); err == nil {
+		t.Error("expected fractional cents to fail migration")
+	}
+}
+
+func TestMigrateDeadlines(t *testing.T) {
+	tests := map[string]string{
+		"10957":         "2000-01-01",
+		"18779":         "2021-06-01",
+		"1622505600":    "2021-06-01",
+		"1622505600000": "2021-06-01",
+	}
+	for legacy, expect := range tests {
+		userSave, err := DecodeUserSave(strings.NewReader(`{"schemaVersion": 2, "savings": [{"deadline": ` + legacy + `}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deadline := userSave.Savings[0].Deadline; deadline == nil || deadline.String() != expect {
+			t.Errorf("expected deadline %s to migrate to %s, got %v", legacy, expect, deadline)
+		}
+	}
+
+	// numbers too small to be dates since 2000, like the example save's 30,
+	// aren't dates and are dropped
+	userSave, err := DecodeUserSave(strings.NewReader(`{"schemaVersion": 2, "savings": ` +
+		`[{"deadline": 0}, {}, {"deadline": 30}, {"deadline": 1200}, {"deadline": 946684799}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, savings := range userSave.Savings {
+		if savings.Deadline != nil {
+			t.Errorf("expected savings %d to have no deadline, got %s", i, savings.Deadline)
+		}
+	}
+	if _, err := DecodeUserSave(strings.NewReader(`{"schemaVersion": 2, "savings": [{"deadline": -1}]}`)); err == nil {
+		t.Error("expected negative deadline to fail migration")
+	}
+}
+
+func TestMigrateTags(t *testing.T) {
+	userSave, err := DecodeUserSave(strings.NewReader(`{"schemaVersion": 3, "expenses": [` +
+		`{"tag": "Eating Out"}, {"tag": ""}, {"tag": "Eating Out"}, {"tag": "eating-out"}, {"tag": "!!"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []TagDefinition{
+		{ID: "eating-out", Name: "Eating Out"},
+		{ID: "eating-out-2", Name: "eating-out"},
+		{ID: "tag", Name: "!!"},
+	}
+	if len(userSave.Tags) != len(expect) {
+		t.Fatalf("expected %d tags catalogued, got %+v", len(expect), userSave.Tags)
+	}
+	for i, tag := range expect {
+		if userSave.Tags[i] != tag {
+			t.Errorf("expected tag %+v, got %+v", tag, userSave.Tags[i])
+		}
+	}
+	if err := userSave.Validate(); err != nil {
+		t.Errorf("expected migrated save to be valid, got %s", err)
+	}
+}
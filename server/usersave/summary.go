@@ -0,0 +1,138 @@
+package usersave
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrNoCycle = errors.New("usersave has no cycle")
+
+// TagTotal is the total of the expenses with a tag
+type TagTotal struct {
+	Tag   Tag   `json:"tag"`
+	Total Money `json:"total"`
+}
+
+// TagRollup is the total of the expenses with a catalogued tag or any tag
+// beneath it
+type TagRollup struct {
+	ID     string `json:"id"`
+	Tag    Tag    `json:"tag"`
+	Parent string `json:"parent,omitempty"`
+	Total  Money  `json:"total"`
+	// Limit is the tag's limit, if it has one, and OverLimit is set when the
+	// total exceeds it
+	Limit     *Money `json:"limit,omitempty"`
+	OverLimit bool   `json:"overLimit"`
+}
+
+// Summary is a Pay Yourself First breakdown of a save's budget each cycle
+type Summary struct {
+	Cycle Cycle `json:"cycle"`
+	// Income is paid into savings first, then spent on expenses
+	Income        Money `json:"income"`
+	PaidToSavings Money `json:"paidToSavings"`
+	Expenses      Money `json:"expenses"`
+	// Remaining is the discretionary money left over, negative if overspent
+	Remaining Money `json:"remaining"`
+	// Tags totals expenses by tag, sorted by tag, untagged expenses first
+	Tags []TagTotal `json:"tags"`
+	// Rollups totals expenses by catalogued tag including the tags beneath
+	// each, in catalogue order
+	Rollups []TagRollup `json:"rollups"`
+	// OverBudget is set when expenses exceed what's left after savings in the
+	// save's own cycle, before any conversion rounds the amounts
+	OverBudget bool `json:"overBudget"`
+}
+
+// Summarize breaks down the save's budget per cycle, converted from the
+// save's cycle if another is given
+func Summarize(userSave *JSONUserSave, cycle Cycle) (Summary, error) {
+	if !userSave.Cycle.Valid() {
+		return Summary{}, ErrNoCycle
+	}
+	if len(cycle) < 1 {
+		cycle = userSave.Cycle
+	}
+	convert := func(amount Money) (Money, error) {
+		return ConvertMoney(amount, userSave.Cycle, cycle)
+	}
+
+	expenses := Money{}
+	tagTotals := map[Tag]Money{}
+	for _, expense := range userSave.Expenses {
+		var err error
+		if expenses, err = expenses.Add(expense.Amount); err != nil {
+			return Summary{}, fmt.Errorf("failed to total expenses: %w", err)
+		}
+		if tagTotals[expense.Tag], err = tagTotals[expense.Tag].Add(expense.Amount); err != nil {
+			return Summary{}, fmt.Errorf("failed to total expenses tagged %q: %w", expense.Tag, err)
+		}
+	}
+	afterSavings, err := userSave.Income.Sub(userSave.SavingsAmount)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to pay savings: %w", err)
+	}
+	remaining, err := afterSavings.Sub(expenses)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to pay expenses: %w", err)
+	}
+
+	rollups, err := userSave.RollUp(tagTotals)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	summary := Summary{
+		Cycle:      cycle,
+		OverBudget: remaining.Minor < 0,
+		Tags:       []TagTotal{},
+		Rollups:    make([]TagRollup, 0, len(userSave.Tags)),
+	}
+	converted := []struct {
+		from Money
+		to   *Money
+	}{
+		{userSave.Income, &summary.Income},
+		{userSave.SavingsAmount, &summary.PaidToSavings},
+		{expenses, &summary.Expenses},
+	}
+	for _, amount := range converted {
+		if *amount.to, err = convert(amount.from); err != nil {
+			return Summary{}, err
+		}
+	}
+	// remaining is worked out again from the converted amounts, so rounding
+	// each of them doesn't leave the summary failing to add up
+	if summary.Remaining, err = summary.Income.Sub(summary.PaidToSavings); err != nil {
+		return Summary{}, fmt.Errorf("failed to pay savings: %w", err)
+	}
+	if summary.Remaining, err = summary.Remaining.Sub(summary.Expenses); err != nil {
+		return Summary{}, fmt.Errorf("failed to pay expenses: %w", err)
+	}
+	for tag, total := range tagTotals {
+		if total, err = convert(total); err != nil {
+			return Summary{}, err
+		}
+		summary.Tags = append(summary.Tags, TagTotal{tag, total})
+	}
+	sort.Slice(summary.Tags, func(i, j int) bool {
+		return summary.Tags[i].Tag < summary.Tags[j].Tag
+	})
+	for _, tag := range userSave.Tags {
+		rollup := TagRollup{ID: tag.ID, Tag: tag.Name, Parent: tag.Parent}
+		if rollup.Total, err = convert(rollups[tag.ID]); err != nil {
+			return Summary{}, err
+		}
+		if tag.Limit != nil {
+			limit, err := convert(*tag.Limit)
+			if err != nil {
+				return Summary{}, err
+			}
+			rollup.Limit, rollup.OverLimit = &limit, rollup.Total.Minor > limit.Minor
+		}
+		summary.Rollups = append(summary.Rollups, rollup)
+	}
+	return summary, nil
+}
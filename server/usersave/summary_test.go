@@ -0,0 +1,106 @@
+package usersave
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	userSave := &JSONUserSave{
+		Cycle:         CycleFortnightly,
+		Income:        nzd(200000),
+		SavingsAmount: nzd(50000),
+		Expenses: []JSONExpense{
+			{Name: "Rent", Amount: nzd(100000), Tag: "Housing"},
+			{Name: "Power", Amount: nzd(10000), Tag: "Housing"},
+			{Name: "Coffee", Amount: nzd(5000)},
+		},
+	}
+
+	summary, err := Summarize(userSave, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Cycle != CycleFortnightly || summary.Income != nzd(200000) || summary.PaidToSavings != nzd(50000) {
+		t.Errorf("unexpected income and savings %+v", summary)
+	}
+	if summary.Expenses != nzd(115000) || summary.Remaining != nzd(35000) || summary.OverBudget {
+		t.Errorf("unexpected expenses and remaining %+v", summary)
+	}
+	if len(summary.Tags) != 2 || summary.Tags[0] != (TagTotal{"", nzd(5000)}) || summary.Tags[1] != (TagTotal{"Housing", nzd(110000)}) {
+		t.Errorf("unexpected tag totals %+v", summary.Tags)
+	}
+
+	if len(summary.Rollups) != 0 {
+		t.Errorf("expected no rollups without a catalogue, got %+v", summary.Rollups)
+	}
+
+	weekly, err := Summarize(userSave, CycleWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weekly.Income != nzd(100000) || weekly.Remaining != nzd(17500) {
+		t.Errorf("expected amounts halved weekly, got %+v", weekly)
+	}
+
+	rounded, err := Summarize(&JSONUserSave{
+		Cycle:         CycleFortnightly,
+		Income:        nzd(3),
+		SavingsAmount: nzd(1),
+		Expenses:      []JSONExpense{{Name: "Gum", Amount: nzd(1)}},
+	}, CycleWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rounded.Income != nzd(2) || rounded.PaidToSavings != nzd(1) || rounded.Expenses != nzd(1) || rounded.Remaining != nzd(0) {
+		t.Errorf("expected remaining to add up after rounding each amount, got %+v", rounded)
+	}
+
+	userSave.Expenses = append(userSave.Expenses, JSONExpense{Name: "Car", Amount: nzd(40000)})
+	if summary, err = Summarize(userSave, ""); err != nil || !summary.OverBudget || summary.Remaining != nzd(-5000) {
+		t.Errorf("expected overspending to be flagged, got %+v %v", summary, err)
+	}
+
+	if _, err := Summarize(&JSONUserSave{}, ""); !errors.Is(err, ErrNoCycle) {
+		t.Errorf("expected save without a cycle to fail, got %v", err)
+	}
+}
+
+func TestSummarizeRollups(t *testing.T) {
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	limit := nzd(100000)
+	userSave := &JSONUserSave{
+		Cycle:  CycleFortnightly,
+		Income: nzd(200000),
+		Expenses: []JSONExpense{
+			{Name: "Rent", Amount: nzd(100000), Tag: "Rent"},
+			{Name: "Power", Amount: nzd(10000), Tag: "Utilities"},
+		},
+		Tags: []TagDefinition{
+			{ID: "housing", Name: "Housing", Limit: &limit},
+			{ID: "rent", Name: "Rent", Parent: "housing"},
+			{ID: "utilities", Name: "Utilities", Parent: "housing"},
+		},
+	}
+
+	summary, err := Summarize(userSave, CycleWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []TagRollup{
+		{ID: "housing", Tag: "Housing", Total: nzd(55000), Limit: &Money{50000, "NZD"}, OverLimit: true},
+		{ID: "rent", Tag: "Rent", Parent: "housing", Total: nzd(50000)},
+		{ID: "utilities", Tag: "Utilities", Parent: "housing", Total: nzd(5000)},
+	}
+	if len(summary.Rollups) != len(expect) {
+		t.Fatalf("expected %d rollups, got %+v", len(expect), summary.Rollups)
+	}
+	for i, rollup := range summary.Rollups {
+		if rollup.ID != expect[i].ID || rollup.Parent != expect[i].Parent || rollup.Total != expect[i].Total ||
+			rollup.OverLimit != expect[i].OverLimit || (rollup.Limit == nil) != (expect[i].Limit == nil) ||
+			(rollup.Limit != nil && *rollup.Limit != *expect[i].Limit) {
+			t.Errorf("expected rollup %+v, got %+v", expect[i], rollup)
+		}
+	}
+}
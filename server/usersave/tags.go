@@ -0,0 +1,131 @@
+package usersave
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var ErrNoTag = errors.New("no such tag")
+
+// tagColour matches colours as #RRGGBB
+var tagColour = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// TagDefinition is a tag in the save's catalogue. Expenses name their tag,
+// while tags refer to their parent by ID so renaming a tag keeps its place.
+type TagDefinition struct {
+	ID   string `json:"id"`
+	Name Tag    `json:"name"`
+	// Colour is shown for the tag, as #RRGGBB
+	Colour string `json:"colour,omitempty"`
+	// Limit is the most to spend each cycle on the tag and those beneath it
+	Limit *Money `json:"limit,omitempty"`
+	// Parent is the ID of the tag this one is beneath, if any
+	Parent string `json:"parent,omitempty"`
+}
+
+// FindTag returns the index of the catalogued tag with the ID
+func (s *JSONUserSave) FindTag(id string) (int, error) {
+	for i, tag := range s.Tags {
+		if tag.ID == id {
+			return i, nil
+		}
+	}
+	return 0, ErrNoTag
+}
+
+// validateTags checks the catalogue's IDs and names are unique, its colours
+// are valid and its parents exist without looping, and that every expense's
+// tag is catalogued
+func (s *JSONUserSave) validateTags() error {
+	ids := map[string]TagDefinition{}
+	names := map[Tag]bool{}
+	for i, tag := range s.Tags {
+		if len(tag.ID) < 1 || len(tag.Name) < 1 {
+			return fmt.Errorf("tag %d has no ID or name", i)
+		}
+		if _, found := ids[tag.ID]; found {
+			return fmt.Errorf("tag ID %q is used twice", tag.ID)
+		}
+		if names[tag.Name] {
+			return fmt.Errorf("tag %q is catalogued twice", tag.Name)
+		}
+		if len(tag.Colour) > 0 && !tagColour.MatchString(tag.Colour) {
+			return fmt.Errorf("tag %q colour %q must be #RRGGBB", tag.Name, tag.Colour)
+		}
+		ids[tag.ID], names[tag.Name] = tag, true
+	}
+
+	for _, tag := range s.Tags {
+		// a tag can't be above itself, so a parent chain longer than the
+		// catalogue loops
+		parent := tag.Parent
+		for depth := 0; len(parent) > 0; depth++ {
+			above, found := ids[parent]
+			if !found {
+				return fmt.Errorf("tag %q parent %q isn't catalogued", tag.Name, parent)
+			}
+			if depth >= len(s.Tags) || above.ID == tag.ID {
+				return fmt.Errorf("tag %q is beneath itself", tag.Name)
+			}
+			parent = above.Parent
+		}
+	}
+
+	for i, expense := range s.Expenses {
+		if len(expense.Tag) > 0 && !names[expense.Tag] {
+			return fmt.Errorf("expense %d tag %q isn't catalogued", i, expense.Tag)
+		}
+	}
+	return nil
+}
+
+// RenameTag renames the catalogued tag with the ID, and the expenses with it,
+// returning its previous name
+func (s *JSONUserSave) RenameTag(id string, name Tag) (Tag, error) {
+	i, err := s.FindTag(id)
+	if err != nil {
+		return "", err
+	}
+	if len(name) < 1 {
+		return "", errors.New("tag must have a name")
+	}
+	for _, tag := range s.Tags {
+		if tag.Name == name && tag.ID != id {
+			return "", fmt.Errorf("tag %q is already catalogued", name)
+		}
+	}
+
+	previous := s.Tags[i].Name
+	s.Tags[i].Name = name
+	for j := range s.Expenses {
+		if s.Expenses[j].Tag == previous {
+			s.Expenses[j].Tag = name
+		}
+	}
+	return previous, nil
+}
+
+// RollUp totals amounts by tag name into each catalogued tag and every tag
+// above it, keyed by ID. Amounts of tags not in the catalogue are left out.
+func (s *JSONUserSave) RollUp(totals map[Tag]Money) (map[string]Money, error) {
+	byName := map[Tag]TagDefinition{}
+	byID := map[string]TagDefinition{}
+	for _, tag := range s.Tags {
+		byName[tag.Name], byID[tag.ID] = tag, tag
+	}
+
+	rollups := map[string]Money{}
+	for name, total := range totals {
+		tag, found := byName[name]
+		for depth := 0; found && depth <= len(s.Tags); depth++ {
+			rollup, err := rollups[tag.ID].Add(total)
+			if err != nil {
+				return nil, fmt.Errorf("failed to roll up %q into %q: %w", name, tag.Name, err)
+			}
+			rollups[tag.ID] = rollup
+			tag, found = byID[tag.Parent]
+		}
+	}
+	return rollups, nil
+}
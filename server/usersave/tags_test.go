@@ -0,0 +1,88 @@
+package usersave
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateTags(t *testing.T) {
+	nzd := Money{100, "NZD"}
+	valid := []JSONUserSave{
+		{},
+		{Tags: []TagDefinition{{ID: "a", Name: "Food", Colour: "#00ff7F", Limit: &nzd}}, Expenses: []JSONExpense{{Tag: "Food"}, {}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A"}, {ID: "b", Name: "B", Parent: "a"}, {ID: "c", Name: "C", Parent: "b"}}},
+	}
+	for _, userSave := range valid {
+		if err := userSave.Validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %s", userSave, err)
+		}
+	}
+
+	invalid := []JSONUserSave{
+		{Expenses: []JSONExpense{{Tag: "Food"}}},
+		{Tags: []TagDefinition{{Name: "Food"}}},
+		{Tags: []TagDefinition{{ID: "a"}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A"}, {ID: "a", Name: "B"}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A"}, {ID: "b", Name: "A"}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A", Colour: "red"}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A", Parent: "b"}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A", Parent: "a"}}},
+		{Tags: []TagDefinition{{ID: "a", Name: "A", Parent: "b"}, {ID: "b", Name: "B", Parent: "a"}}},
+	}
+	for _, userSave := range invalid {
+		if err := userSave.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", userSave)
+		}
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	userSave := &JSONUserSave{
+		Tags:     []TagDefinition{{ID: "a", Name: "Fod"}, {ID: "b", Name: "Fun"}},
+		Expenses: []JSONExpense{{Name: "Groceries", Tag: "Fod"}, {Name: "Movies", Tag: "Fun"}},
+	}
+	previous, err := userSave.RenameTag("a", "Food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != "Fod" || userSave.Tags[0].Name != "Food" || userSave.Expenses[0].Tag != "Food" || userSave.Expenses[1].Tag != "Fun" {
+		t.Errorf("expected only Fod renamed, got %+v", userSave)
+	}
+	if err := userSave.Validate(); err != nil {
+		t.Errorf("expected renamed save to be valid, got %s", err)
+	}
+
+	if _, err := userSave.RenameTag("c", "Food"); !errors.Is(err, ErrNoTag) {
+		t.Errorf("expected ErrNoTag renaming an unknown tag, got %v", err)
+	}
+	if _, err := userSave.RenameTag("a", "Fun"); err == nil {
+		t.Error("expected renaming to a catalogued name to fail")
+	}
+	if _, err := userSave.RenameTag("a", ""); err == nil {
+		t.Error("expected renaming to nothing to fail")
+	}
+}
+
+func TestRollUp(t *testing.T) {
+	nzd := func(minor int64) Money { return Money{minor, "NZD"} }
+	userSave := &JSONUserSave{Tags: []TagDefinition{
+		{ID: "a", Name: "A"}, {ID: "b", Name: "B", Parent: "a"}, {ID: "c", Name: "C", Parent: "b"}, {ID: "d", Name: "D"},
+	}}
+	rollups, err := userSave.RollUp(map[Tag]Money{"A": nzd(1), "B": nzd(10), "C": nzd(100), "E": nzd(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]Money{"a": nzd(111), "b": nzd(110), "c": nzd(100)}
+	if len(rollups) != len(expect) {
+		t.Errorf("expected %d rollups, got %+v", len(expect), rollups)
+	}
+	for id, total := range expect {
+		if rollups[id] != total {
+			t.Errorf("expected %s to roll up to %s, got %s", id, total, rollups[id])
+		}
+	}
+
+	if _, err := userSave.RollUp(map[Tag]Money{"B": nzd(1), "C": {1, "AUD"}}); err == nil {
+		t.Error("expected rolling up different currencies to fail")
+	}
+}
@@ -2,49 +2,172 @@ package usersave
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
-type Cycle = string
 type Tag = string
 
 type JSONExpense struct {
 	Name   string `json:"name"`
-	Amount int32  `json:"amount"`
+	Amount Money  `json:"amount"`
 	Tag    Tag    `json:"tag"`
 }
 
+// JSONSavings is a savings goal, contributed to by its amount every cycle
 type JSONSavings struct {
-	Name     string `json:"name"`
-	Goal     int32  `json:"goal"`
-	Amount   int32  `json:"amount"`
-	Deadline int    `json:"deadline"`
+	Name   string `json:"name"`
+	Goal   Money  `json:"goal"`
+	Amount Money  `json:"amount"`
+	// Saved is how much of the goal has been saved so far
+	Saved Money `json:"saved"`
+	// Deadline is the day the goal is due, if it is
+	Deadline *Date `json:"deadline,omitempty"`
+	// Priority orders goals for StrategyPriority, lowest first
+	Priority int `json:"priority,omitempty"`
 }
 
 type JSONUserSave struct {
+	SchemaVersion int           `json:"schemaVersion"`
 	Cycle         Cycle         `json:"cycle"`
-	Income        int32         `json:"income"`
-	SavingsAmount int32         `json:"savingsAmount"`
+	Income        Money         `json:"income"`
+	SavingsAmount Money         `json:"savingsAmount"`
 	Savings       []JSONSavings `json:"savings"`
 	Expenses      []JSONExpense `json:"expenses"`
+	// PaySchedule places the cycle's paydays, if set
+	PaySchedule *PaySchedule `json:"paySchedule,omitempty"`
+	// Timezone is the IANA name of the user's timezone, deciding which day
+	// it is for them. UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+	// AllocationStrategy is how the SavingsAmount was last split between
+	// the savings goals, if it was
+	AllocationStrategy AllocationStrategy `json:"allocationStrategy,omitempty"`
+	// Tags catalogues the tags expenses may have
+	Tags []TagDefinition `json:"tags,omitempty"`
+}
+
+// amounts lists every amount in the save
+func (s *JSONUserSave) amounts() []Money {
+	amounts := []Money{s.Income, s.SavingsAmount}
+	for _, savings := range s.Savings {
+		amounts = append(amounts, savings.Goal, savings.Amount, savings.Saved)
+	}
+	for _, expense := range s.Expenses {
+		amounts = append(amounts, expense.Amount)
+	}
+	for _, tag := range s.Tags {
+		if tag.Limit != nil {
+			amounts = append(amounts, *tag.Limit)
+		}
+	}
+	return amounts
+}
+
+// Currency returns the currency of the save's amounts, the first given if
+// they are in more than one, or empty if none has one
+func (s *JSONUserSave) Currency() string {
+	for _, amount := range s.amounts() {
+		if len(amount.Currency) > 0 {
+			return amount.Currency
+		}
+	}
+	return ""
+}
+
+// Validate checks the save's cycle, allocation strategy, pay schedule and
+// timezone are known if set, and that its tags are catalogued. Amounts in more
+// than one currency are left to fail the computations which total them.
+func (s *JSONUserSave) Validate() error {
+	if len(s.Cycle) > 0 && !s.Cycle.Valid() {
+		return fmt.Errorf("unknown cycle %q", s.Cycle)
+	}
+	if len(s.AllocationStrategy) > 0 && !s.AllocationStrategy.Valid() {
+		return fmt.Errorf("unknown allocation strategy %q", s.AllocationStrategy)
+	}
+	if s.PaySchedule != nil {
+		if s.PaySchedule.Anchor == (Date{}) {
+			return errors.New("pay schedule has no anchor")
+		}
+		if !s.PaySchedule.Adjustment.Valid() {
+			return fmt.Errorf("unknown business day adjustment %q", s.PaySchedule.Adjustment)
+		}
+	}
+	if len(s.Timezone) > 0 {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", s.Timezone)
+		}
+	}
+	return s.validateTags()
 }
 
+// ValidateChanges checks the changes from the previous save, if any, to this
+// one. New or changed savings deadlines must be after today in the user's
+// timezone, while deadlines kept from the previous save may have passed.
+func (s *JSONUserSave) ValidateChanges(previous *JSONUserSave, now time.Time) error {
+	kept := map[string]Date{}
+	if previous != nil {
+		for _, savings := range previous.Savings {
+			if savings.Deadline != nil {
+				kept[savings.Name] = *savings.Deadline
+			}
+		}
+	}
+
+	today := Today(now, s.Location())
+	for i, savings := range s.Savings {
+		if savings.Deadline == nil {
+			continue
+		}
+		if deadline, found := kept[savings.Name]; found && deadline == *savings.Deadline {
+			continue
+		}
+		if !savings.Deadline.After(today) {
+			return fmt.Errorf("savings %d deadline %s must be after today, %s", i, savings.Deadline, today)
+		}
+	}
+	return nil
+}
+
+// DecodeUserSave decodes a usersave of any schema version up to the current
+// one, upgrading it to the current version. Saves from newer versions fail
+// with ErrNewerSchema.
 func DecodeUserSave(jsonReader io.Reader) (*JSONUserSave, error) {
 	decoder := json.NewDecoder(jsonReader)
+	decoder.UseNumber()
 
-	userSave := JSONUserSave{}
-	err := decoder.Decode(&userSave)
+	doc := document{}
+	err := decoder.Decode(&doc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode UserSave JSON: %w", err)
 	}
+	if doc == nil {
+		doc = document{}
+	}
+	if err := upgradeDocument(doc); err != nil {
+		return nil, err
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-encode upgraded UserSave: %w", err)
+	}
+	userSave := JSONUserSave{}
+	if err := json.Unmarshal(upgraded, &userSave); err != nil {
+		return nil, fmt.Errorf("failed to decode UserSave JSON: %w", err)
+	}
 
 	return &userSave, nil
 }
 
+// EncodeUserSave encodes the usersave as the current schema version, leaving
+// the usersave itself unchanged
 func EncodeUserSave(userSave *JSONUserSave, w io.Writer) error {
+	versioned := *userSave
+	versioned.SchemaVersion = CurrentSchemaVersion
 	encoder := json.NewEncoder(w)
-	if err := encoder.Encode(userSave); err != nil {
+	if err := encoder.Encode(&versioned); err != nil {
 		return fmt.Errorf("failed to encode user save %w", err)
 	}
 	return nil
@@ -1,9 +1,12 @@
 package usersave
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func readValidJSON() io.ReadCloser {
@@ -28,12 +31,12 @@ func TestDecodeUserSave(t *testing.T) {
 		t.Errorf("expected Fortnightly cycle, got %s", userSave.Cycle)
 	}
 
-	if userSave.Income != 900033 {
-		t.Errorf("expected 900033 cents, got %d", userSave.Income)
+	if userSave.Income != (Money{900033, "NZD"}) {
+		t.Errorf("expected 900033 cents, got %v", userSave.Income)
 	}
 
-	if userSave.SavingsAmount != 405015 {
-		t.Errorf("expected 405015 cents, got %d", userSave.SavingsAmount)
+	if userSave.SavingsAmount.Minor != 405015 {
+		t.Errorf("expected 405015 cents, got %d", userSave.SavingsAmount.Minor)
 	}
 
 	if size := len(userSave.Savings); size != 1 {
@@ -48,3 +51,74 @@ func TestDecodeUserSave(t *testing.T) {
 		t.Errorf("expected Housing tag, got %s", userSave.Expenses[0].Tag)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		`{"cycle": ""}`,
+		`{"paySchedule": {"anchor": "2021-06-01", "adjustment": "preceding"}}`,
+		`{"timezone": "Pacific/Auckland", "savings": [{"deadline": "2021-12-01"}]}`,
+		`{"cycle": "weekly", "income": {"amount": "100", "currency": "NZD"}}`,
+		`{"income": {"amount": "100", "currency": "NZD"}, "savingsAmount": {"amount": "0", "currency": ""}}`,
+		`{"income": {"amount": "-1", "currency": "NZD"}}`,
+		`{"income": {"amount": "1", "currency": "NZD"}, "expenses": [{"amount": {"amount": "1", "currency": "AUD"}}]}`,
+	}
+	for _, doc := range valid {
+		userSave, err := DecodeUserSave(strings.NewReader(strings.Replace(doc, "{", fmt.Sprintf(`{"schemaVersion": %d, `, CurrentSchemaVersion), 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userSave.Validate(); err != nil {
+			t.Errorf("%s: expected valid, got %s", doc, err)
+		}
+	}
+
+	invalid := []string{
+		`{"cycle": "daily"}`,
+		`{"timezone": "Mars/Olympus_Mons"}`,
+		`{"paySchedule": {"anchor": "2021-06-01", "adjustment": "nearest"}}`,
+	}
+	for _, doc := range invalid {
+		userSave, err := DecodeUserSave(strings.NewReader(strings.Replace(doc, "{", fmt.Sprintf(`{"schemaVersion": %d, `, CurrentSchemaVersion), 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userSave.Validate(); err == nil {
+			t.Errorf("%s: expected invalid", doc)
+		}
+	}
+}
+
+func TestValidateChanges(t *testing.T) {
+	now := time.Date(2021, time.May, 31, 13, 0, 0, 0, time.UTC)
+	date := func(value string) *Date {
+		parsed, err := ParseDate(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &parsed
+	}
+	previous := &JSONUserSave{Savings: []JSONSavings{{Name: "Car", Deadline: date("2021-01-01")}}}
+
+	tests := []struct {
+		name     string
+		timezone string
+		savings  JSONSavings
+		valid    bool
+	}{
+		{"kept past deadline", "", JSONSavings{Name: "Car", Deadline: date("2021-01-01")}, true},
+		{"changed to past deadline", "", JSONSavings{Name: "Car", Deadline: date("2021-02-01")}, false},
+		{"new future deadline", "", JSONSavings{Name: "Bike", Deadline: date("2021-06-01")}, true},
+		{"new deadline today", "", JSONSavings{Name: "Bike", Deadline: date("2021-05-31")}, false},
+		{"new deadline today in timezone", "Pacific/Auckland", JSONSavings{Name: "Bike", Deadline: date("2021-06-01")}, false},
+		{"no deadline", "", JSONSavings{Name: "Bike"}, true},
+	}
+	for _, test := range tests {
+		userSave := &JSONUserSave{Timezone: test.timezone, Savings: []JSONSavings{test.savings}}
+		if err := userSave.ValidateChanges(previous, now); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %t, got %v", test.name, test.valid, err)
+		}
+	}
+	if err := (&JSONUserSave{Savings: []JSONSavings{{Deadline: date("2021-01-01")}}}).ValidateChanges(nil, now); err == nil {
+		t.Error("expected past deadline in a new save to be invalid")
+	}
+}
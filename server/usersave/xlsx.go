@@ -0,0 +1,196 @@
+package usersave
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// xlsxCell is a spreadsheet cell of text, or a number if number is set
+type xlsxCell struct {
+	value  string
+	number bool
+}
+
+func textCell(value string) xlsxCell {
+	return xlsxCell{value: value}
+}
+
+func moneyCell(amount Money) xlsxCell {
+	return xlsxCell{value: amount.String(), number: true}
+}
+
+type xlsxSheet struct {
+	name string
+	rows [][]xlsxCell
+}
+
+const (
+	xlsxHeader          = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+	xlsxMain            = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelationships   = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xlsxDocumentTypes   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxWorksheetFormat = "application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"
+)
+
+// xlsxColumn returns the letters naming the column at the index, from A
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxEscape escapes the text for XML, replacing characters XML can't hold
+func xlsxEscape(text string) string {
+	escaped := &bytes.Buffer{}
+	xml.EscapeText(escaped, []byte(text))
+	return escaped.String()
+}
+
+// worksheet returns the sheet's worksheet XML, with text inline rather than
+// in a shared strings part
+func (s xlsxSheet) worksheet() string {
+	content := &bytes.Buffer{}
+	fmt.Fprintf(content, `%s<worksheet xmlns="%s"><sheetData>`, xlsxHeader, xlsxMain)
+	for i, row := range s.rows {
+		fmt.Fprintf(content, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			if len(cell.value) < 1 {
+				continue
+			}
+			reference := xlsxColumn(j) + strconv.Itoa(i+1)
+			if cell.number {
+				fmt.Fprintf(content, `<c r="%s"><v>%s</v></c>`, reference, cell.value)
+			} else {
+				fmt.Fprintf(content, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`,
+					reference, xlsxEscape(cell.value))
+			}
+		}
+		content.WriteString(`</row>`)
+	}
+	content.WriteString(`</sheetData></worksheet>`)
+	return content.String()
+}
+
+// writeXLSX writes an Office Open XML workbook of the sheets
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	types := &bytes.Buffer{}
+	workbook := &bytes.Buffer{}
+	workbookRelationships := &bytes.Buffer{}
+	fmt.Fprintf(types, `%s<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`,
+		xlsxHeader)
+	fmt.Fprintf(workbook, `%s<workbook xmlns="%s" xmlns:r="%s"><sheets>`, xlsxHeader, xlsxMain, xlsxDocumentTypes)
+	fmt.Fprintf(workbookRelationships, `%s<Relationships xmlns="%s">`, xlsxHeader, xlsxRelationships)
+	for i, sheet := range sheets {
+		fmt.Fprintf(types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="%s"/>`, i+1, xlsxWorksheetFormat)
+		fmt.Fprintf(workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(sheet.name), i+1, i+1)
+		fmt.Fprintf(workbookRelationships, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`,
+			i+1, xlsxDocumentTypes, i+1)
+	}
+	types.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	workbookRelationships.WriteString(`</Relationships>`)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", fmt.Sprintf(`%s<Relationships xmlns="%s">`+
+			`<Relationship Id="rId1" Type="%s/officeDocument" Target="xl/workbook.xml"/></Relationships>`,
+			xlsxHeader, xlsxRelationships, xlsxDocumentTypes)},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", workbookRelationships.String()},
+	}
+	for i, sheet := range sheets {
+		parts = append(parts, struct{ name, content string }{
+			fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.worksheet(),
+		})
+	}
+
+	archive := zip.NewWriter(w)
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("failed to write XLSX: %w", err)
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return fmt.Errorf("failed to write XLSX: %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write XLSX: %w", err)
+	}
+	return nil
+}
+
+// WriteXLSX writes the save as an XLSX workbook, with sheets of the budget,
+// its tag catalogue, its expenses and its savings goals
+func WriteXLSX(userSave *JSONUserSave, w io.Writer) error {
+	budget := xlsxSheet{name: "Budget", rows: [][]xlsxCell{
+		{textCell("cycle"), textCell(string(userSave.Cycle))},
+		{textCell("currency"), textCell(userSave.Currency())},
+		{textCell("income"), moneyCell(userSave.Income)},
+		{textCell("savingsAmount"), moneyCell(userSave.SavingsAmount)},
+		{textCell("timezone"), textCell(userSave.Timezone)},
+		{textCell("allocationStrategy"), textCell(string(userSave.AllocationStrategy))},
+	}}
+
+	tags := xlsxSheet{name: "Tags", rows: [][]xlsxCell{
+		{textCell("id"), textCell("name"), textCell("colour"), textCell("limit"), textCell("currency"),
+			textCell("parent")},
+	}}
+	for _, tag := range userSave.Tags {
+		limit, currency := textCell(""), ""
+		if tag.Limit != nil {
+			limit, currency = moneyCell(*tag.Limit), tag.Limit.Currency
+		}
+		tags.rows = append(tags.rows, []xlsxCell{
+			textCell(tag.ID),
+			textCell(tag.Name),
+			textCell(tag.Colour),
+			limit,
+			textCell(currency),
+			textCell(tag.Parent),
+		})
+	}
+
+	expenses := xlsxSheet{name: "Expenses", rows: [][]xlsxCell{
+		{textCell("name"), textCell("amount"), textCell("currency"), textCell("tag")},
+	}}
+	for _, expense := range userSave.Expenses {
+		expenses.rows = append(expenses.rows, []xlsxCell{
+			textCell(expense.Name),
+			moneyCell(expense.Amount),
+			textCell(expense.Amount.Currency),
+			textCell(expense.Tag),
+		})
+	}
+
+	savings := xlsxSheet{name: "Savings", rows: [][]xlsxCell{
+		{textCell("name"), textCell("goal"), textCell("amount"), textCell("saved"), textCell("currency"),
+			textCell("deadline"), textCell("priority")},
+	}}
+	for _, goal := range userSave.Savings {
+		deadline := ""
+		if goal.Deadline != nil {
+			deadline = goal.Deadline.String()
+		}
+		savings.rows = append(savings.rows, []xlsxCell{
+			textCell(goal.Name),
+			moneyCell(goal.Goal),
+			moneyCell(goal.Amount),
+			moneyCell(goal.Saved),
+			textCell(goal.Goal.Currency),
+			textCell(deadline),
+			{value: strconv.Itoa(goal.Priority), number: true},
+		})
+	}
+
+	return writeXLSX(w, []xlsxSheet{budget, tags, expenses, savings})
+}
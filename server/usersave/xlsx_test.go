@@ -0,0 +1,88 @@
+package usersave
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestXLSXColumn(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for index, expect := range tests {
+		if got := xlsxColumn(index); got != expect {
+			t.Errorf("expected column %d to be %s, got %s", index, expect, got)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	validJSON := readValidJSON()
+	defer validJSON.Close()
+	userSave, err := DecodeUserSave(validJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSave.Expenses[0].Name = "Rent & <bills>"
+
+	workbook := bytes.Buffer{}
+	if err := WriteXLSX(userSave, &workbook); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(workbook.Bytes()), int64(workbook.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every part must be well formed XML
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := decoder.Token(); err != nil {
+				if err != io.EOF {
+					t.Errorf("%s isn't well formed: %s", file.Name, err)
+				}
+				break
+			}
+		}
+		parts[file.Name] = string(content)
+	}
+
+	for _, name := range []string{
+		"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels",
+		"xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml", "xl/worksheets/sheet3.xml",
+		"xl/worksheets/sheet4.xml",
+	} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("expected workbook to have %s", name)
+		}
+	}
+	for _, sheet := range []string{`name="Budget"`, `name="Tags"`, `name="Expenses"`, `name="Savings"`} {
+		if !strings.Contains(parts["xl/workbook.xml"], sheet) {
+			t.Errorf("expected workbook to have sheet %s", sheet)
+		}
+	}
+	if tags := parts["xl/worksheets/sheet2.xml"]; !strings.Contains(tags, `<t xml:space="preserve">housing</t>`) {
+		t.Errorf("expected the catalogued housing tag, got %s", tags)
+	}
+	expenses := parts["xl/worksheets/sheet3.xml"]
+	if !strings.Contains(expenses, `<c r="A2" t="inlineStr"><is><t xml:space="preserve">Rent &amp; &lt;bills&gt;</t></is></c>`) {
+		t.Errorf("expected escaped expense name in A2, got %s", expenses)
+	}
+	if !strings.Contains(expenses, `<c r="B2"><v>333.54</v></c>`) {
+		t.Errorf("expected numeric expense amount in B2, got %s", expenses)
+	}
+}